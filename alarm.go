@@ -0,0 +1,164 @@
+package quick
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiwjd/quick/support/alarm"
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	// Option 是New的选项
+	Option func(o *options)
+
+	options struct {
+		alarm alarm.Alarm
+	}
+
+	// PanicErr 是从panic恢复的错误
+	PanicErr struct {
+		Value interface{}
+		Stack string
+	}
+)
+
+// WithAlarm 设置警报器，5xx错误、panic和定时任务失败会通过它上报，设置后忽略配置中的alarm.backend
+func WithAlarm(a alarm.Alarm) Option {
+	return func(o *options) {
+		o.alarm = a
+	}
+}
+
+func (pe *PanicErr) Error() string {
+	return fmt.Sprintf("panic: %v", pe.Value)
+}
+
+// Unwrap panic的值是error时返回它
+func (pe *PanicErr) Unwrap() error {
+	err, _ := pe.Value.(error)
+	return err
+}
+
+// recoverMiddleware 把handler中的panic转成PanicErr交给HTTPErrorHandler处理
+func recoverMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					if r == http.ErrAbortHandler {
+						panic(r)
+					}
+					err = &PanicErr{Value: r, Stack: string(debug.Stack())}
+				}
+			}()
+			return next(c)
+		}
+	}
+}
+
+// alarmReporter 上报警报，相同指纹的警报在interval内只上报一次，被抑制的次数附加在下一次上报中
+type alarmReporter struct {
+	alarm    alarm.Alarm
+	interval time.Duration
+	mu       sync.Mutex
+	states   map[string]*alarmState
+}
+
+type alarmState struct {
+	reportedAt time.Time
+	suppressed int
+}
+
+func newAlarmReporter(a alarm.Alarm, interval time.Duration) *alarmReporter {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &alarmReporter{
+		alarm:    a,
+		interval: interval,
+		states:   make(map[string]*alarmState),
+	}
+}
+
+// report 上报msg，r为nil时忽略
+func (r *alarmReporter) report(fingerprint string, msg string) {
+	if r == nil {
+		return
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	st, ok := r.states[fingerprint]
+	if ok && now.Sub(st.reportedAt) < r.interval {
+		st.suppressed++
+		r.mu.Unlock()
+		return
+	}
+	if !ok {
+		r.prune(now)
+		st = &alarmState{}
+		r.states[fingerprint] = st
+	}
+	suppressed := st.suppressed
+	st.reportedAt = now
+	st.suppressed = 0
+	r.mu.Unlock()
+
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%s\nsuppressed: %d in last %s", msg, suppressed, r.interval)
+	}
+	r.alarm.Report(msg)
+}
+
+// prune 指纹过多时清理已过期的
+func (r *alarmReporter) prune(now time.Time) {
+	if len(r.states) < 1000 {
+		return
+	}
+	for fp, st := range r.states {
+		if now.Sub(st.reportedAt) >= r.interval {
+			delete(r.states, fp)
+		}
+	}
+}
+
+// reportHTTP 上报HTTP请求的错误
+func (r *alarmReporter) reportHTTP(c echo.Context, err error, code int, caller string) {
+	if r == nil {
+		return
+	}
+
+	req := c.Request()
+	var b strings.Builder
+	fmt.Fprintf(&b, "[HTTP %d] %s %s", code, req.Method, req.URL.Path)
+	if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		fmt.Fprintf(&b, "\nrequestId: %s", id)
+	}
+	if caller != "" {
+		fmt.Fprintf(&b, "\ncaller: %s", caller)
+	}
+	fmt.Fprintf(&b, "\ncause: %s", FormatChain(err))
+	if pe, ok := err.(*PanicErr); ok {
+		fmt.Fprintf(&b, "\nstack: %s", pe.Stack)
+	}
+
+	r.report(fmt.Sprintf("http|%s %s|%d|%s|%s", req.Method, c.Path(), code, caller, Fingerprint(err)), b.String())
+}
+
+// reportJob 上报定时任务的错误
+func (r *alarmReporter) reportJob(expr string, err error) {
+	if r == nil {
+		return
+	}
+
+	msg := fmt.Sprintf("[CRON] %s\ncause: %s", expr, FormatChain(err))
+	if pe, ok := err.(*PanicErr); ok {
+		msg += "\nstack: " + pe.Stack
+	}
+	r.report("cron|"+expr+"|"+Fingerprint(err), msg)
+}
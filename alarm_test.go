@@ -0,0 +1,82 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hiwjd/quick/support/alarm"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlarmReporter(t *testing.T) {
+	var msgs []string
+	r := newAlarmReporter(alarm.AlarmFunc(func(msg string) { msgs = append(msgs, msg) }), 50*time.Millisecond)
+
+	r.report("a", "a1")
+	r.report("a", "a2")
+	r.report("a", "a3")
+	r.report("b", "b1")
+	assert.Equal(t, []string{"a1", "b1"}, msgs)
+
+	time.Sleep(60 * time.Millisecond)
+	r.report("a", "a4")
+	assert.Equal(t, "a4\nsuppressed: 2 in last 50ms", msgs[2])
+
+	var nilReporter *alarmReporter
+	nilReporter.report("a", "ignored")
+}
+
+func TestErrorHandlerAlarm(t *testing.T) {
+	var msgs []string
+	r := newAlarmReporter(alarm.AlarmFunc(func(msg string) { msgs = append(msgs, msg) }), time.Minute)
+
+	e := echo.New()
+	e.Use(recoverMiddleware())
+	e.HTTPErrorHandler = NewCustomHTTPErrorHandler(e, func(format string, args ...interface{}) {}, withAlarmReporter(r))
+	e.GET("/bad", func(c echo.Context) error { return NewBadArgs("bad", nil) })
+	e.GET("/quota", func(c echo.Context) error { return NewSrvErr("quota", errors.New("no quota")) })
+	e.GET("/panic", func(c echo.Context) error { panic("boom") })
+
+	for _, path := range []string{"/bad", "/quota", "/quota", "/panic"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		rec.Header().Set(echo.HeaderXRequestID, "r1")
+		e.ServeHTTP(rec, req)
+		if path == "/panic" {
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		}
+	}
+
+	if assert.Len(t, msgs, 2) {
+		assert.True(t, strings.HasPrefix(msgs[0], "[HTTP 500] GET /quota\nrequestId: r1"), msgs[0])
+		assert.Contains(t, msgs[0], "cause: quota")
+		assert.True(t, strings.HasPrefix(msgs[1], "[HTTP 500] GET /panic"), msgs[1])
+		assert.Contains(t, msgs[1], "cause: panic: boom")
+		assert.Contains(t, msgs[1], "stack: ")
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	var msgs []string
+	ac := &quickContext{
+		logger: log.New(io.Discard, "", 0),
+		alarm:  newAlarmReporter(alarm.AlarmFunc(func(msg string) { msgs = append(msgs, msg) }), time.Minute),
+	}
+
+	ac.runJob("@every 1s", func(ctx context.Context) error { return nil })
+	ac.runJob("@every 2s", func(ctx context.Context) error { return errors.New("failed") })
+	ac.runJob("@every 3s", func(ctx context.Context) error { panic("boom") })
+
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, "[CRON] @every 2s\ncause: failed", msgs[0])
+		assert.True(t, strings.HasPrefix(msgs[1], "[CRON] @every 3s\ncause: panic: boom\nstack: "), msgs[1])
+	}
+}
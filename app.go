@@ -5,9 +5,11 @@ import (
 	"io"
 	"log"
 	"os"
+	"time"
 
 	"github.com/go-redis/redis/v7"
 	"github.com/google/uuid"
+	"github.com/hiwjd/quick/support/alarm"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/robfig/cron/v3"
@@ -50,8 +52,13 @@ type App struct {
 	logger *log.Logger
 }
 
-// New 构造App
-func New(config Config) *App {
+// New 构造App，opts可以设置警报器等
+func New(config Config, opts ...Option) *App {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	logWriter := initLoggerWriter(config.Log)
 	logTimeFormt := "2006/01/02 15:04:05.00000"
 	logger := log.New(logWriter, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
@@ -71,12 +78,20 @@ func New(config Config) *App {
 		},
 		RequestIDHandler: func(c echo.Context, s string) {
 			c.Set(echo.HeaderXRequestID, s)
+			req := c.Request()
+			c.SetRequest(req.WithContext(WithRequestID(req.Context(), s)))
 		},
 	}))
+	e.Use(recoverMiddleware())
 	e.HideBanner = true
+
+	db := initDB(config.MysqlDSN, logger)
+	redisClient := initRedis(config.Redis)
+	reporter := initAlarm(config.Alarm, o.alarm, db, redisClient)
+
 	e.HTTPErrorHandler = NewCustomHTTPErrorHandler(e, func(format string, args ...interface{}) {
 		logger.Printf(format, args...)
-	})
+	}, append(errorHandlerOptions(config.Error), withAlarmReporter(reporter))...)
 	e.Validator = NewCustomValidator()
 
 	cronParserOption := cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor
@@ -86,11 +101,20 @@ func New(config Config) *App {
 	ac.config = config
 	ac.logger = logger
 	ac.c = c
-	ac.db = initDB(config.MysqlDSN, logger)
-	ac.redisClient = initRedis(config.Redis)
+	ac.db = db
+	ac.redisClient = redisClient
+	ac.alarm = reporter
 	ac.e = e
 	ac.resource = make(map[string]interface{})
-	ac.pubsub = newMemPubSub(ac.Logf)
+	ac.done = make(chan struct{})
+	ac.pubsub = initPubSub(config.PubSub, ac.redisClient, ac.Logf)
+	ac.deadLetters = initDeadLetterStore(config.PubSub, ac.db)
+	ac.subHandlers = make(map[string]*retryHandler)
+	ac.subCounts = make(map[string]int)
+	ac.tasks = newTaskQueue(initTaskBroker(config.TaskQueue, ac.db, ac.redisClient), config.TaskQueue, ac.Logf)
+	if config.OpenAPI.Enable {
+		ac.serveOpenAPI()
+	}
 
 	return &App{
 		ac:     ac,
@@ -159,6 +183,80 @@ func initRedis(cfg Redis) *redis.Client {
 	return redisClient
 }
 
+func initPubSub(cfg PubSubConfig, redisClient *redis.Client, logf Logf) PubSub {
+	switch cfg.Backend {
+	case "redis":
+		if redisClient == nil {
+			panic("PubSub Backend redis Requires Redis Config")
+		}
+		return newRedisPubSub(redisClient, cfg, logf)
+	default:
+		return newMemPubSub(logf)
+	}
+}
+
+func initAlarm(cfg AlarmConfig, a alarm.Alarm, db *gorm.DB, redisClient *redis.Client) *alarmReporter {
+	if a == nil {
+		switch cfg.Backend {
+		case "":
+			return nil
+		case "redis":
+			if redisClient == nil {
+				panic("Alarm redis Requires Redis Config")
+			}
+			a = alarm.NewRedisStoreAlarm(redisClient)
+		case "mysql":
+			if db == nil {
+				panic("Alarm mysql Requires MysqlDSN Config")
+			}
+			a = alarm.NewMysqlStoreAlarm(db)
+		default:
+			panic("Unknown Alarm Backend: " + cfg.Backend)
+		}
+	}
+	return newAlarmReporter(a, time.Duration(cfg.Interval)*time.Second)
+}
+
+func errorHandlerOptions(cfg ErrorConfig) []ErrorHandlerOption {
+	var opts []ErrorHandlerOption
+	if cfg.Format != "" {
+		opts = append(opts, WithErrorFormat(ErrorFormat(cfg.Format)))
+	}
+	if cfg.TypeBase != "" {
+		opts = append(opts, WithProblemTypeBase(cfg.TypeBase))
+	}
+	return opts
+}
+
+func initDeadLetterStore(cfg PubSubConfig, db *gorm.DB) DeadLetterStore {
+	switch cfg.DeadLetter {
+	case "db":
+		if db == nil {
+			panic("PubSub DeadLetter db Requires MysqlDSN Config")
+		}
+		return NewDBDeadLetterStore(db)
+	default:
+		return NewMemDeadLetterStore(1000)
+	}
+}
+
+func initTaskBroker(cfg TaskQueue, db *gorm.DB, redisClient *redis.Client) TaskBroker {
+	switch cfg.Backend {
+	case "redis":
+		if redisClient == nil {
+			panic("TaskQueue Backend redis Requires Redis Config")
+		}
+		return NewRedisTaskBroker(cfg.Prefix, redisClient)
+	case "db":
+		if db == nil {
+			panic("TaskQueue Backend db Requires MysqlDSN Config")
+		}
+		return NewDBTaskBroker(db)
+	default:
+		return NewMemTaskBroker()
+	}
+}
+
 func initLoggerWriter(cfg Log) io.Writer {
 	var w io.Writer
 	switch cfg.Output {
@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/valyala/fasttemplate"
 	"gopkg.in/validator.v2"
 )
 
@@ -15,20 +19,73 @@ var (
 	ErrUnsupported = errors.New("Checker not support this type")
 )
 
+// StructChecker 是需要跨字段校验的struct实现的接口，Checker在检查完struct的字段后调用CheckStruct，
+// 返回的ErrorData.Field是字段的json名，为空表示struct自身，Title为空时使用字段的标题，错误会合并到同一个ErrorArray中
+type StructChecker interface {
+	CheckStruct() ErrorArray
+}
+
 // Checker 校验器
 type Checker interface {
 	// Check 校验v，并返回结构化的错误信息ErrorArray或者其他error
 	Check(v interface{}) error
+	// CheckLang 和Check相同，提示使用acceptLanguage协商出的语言，acceptLanguage可以是Accept-Language头或者单个语言标签
+	CheckLang(v interface{}, acceptLanguage string) error
+	// RegisterRule 注册名为name的校验规则，注册后可以在校验标签中使用，msg是校验失败时的默认提示，应在校验前注册
+	RegisterRule(name string, rule Rule, msg string) error
+	// SetMessages 设置lang语言的提示，键和errorMsgMap相同，应在校验前设置
+	SetMessages(lang string, msgs map[string]string)
 }
 
+// defaultsErrorMsgMap 是默认的提示，可以使用参数：{title}是字段标题，{param}是规则的参数，
+// 规则的参数也可以用规则名引用，比如 min=6 的 {min}，{field}是跨字段规则引用的字段的标题
+// minLength、maxLength 用于字符串、slice、map的min、max，没有设置时使用min、max
 var defaultsErrorMsgMap = map[string]string{
 	"zeroValue":    "不能为空",
-	"min":          "太小了",
-	"max":          "太大了",
-	"len":          "长度不正确",
+	"min":          "不能小于{min}",
+	"minLength":    "长度不能小于{min}",
+	"max":          "不能大于{max}",
+	"maxLength":    "长度不能大于{max}",
+	"len":          "长度必须为{len}",
 	"regexp":       "不符合规则",
 	"unsupport":    "类型错误",
 	"badParameter": "类型错误",
+	"eqfield":      "必须与{field}相同",
+	"nefield":      "不能与{field}相同",
+	"gtfield":      "必须大于{field}",
+	"gtefield":     "不能小于{field}",
+	"ltfield":      "必须小于{field}",
+	"ltefield":     "不能大于{field}",
+	"required_if":  "不能为空",
+}
+
+// defaultLocaleMsgMaps 是内置的其他语言的提示
+var defaultLocaleMsgMaps = map[string]map[string]string{
+	"en": {
+		"zeroValue":    "is required",
+		"min":          "must be at least {min}",
+		"minLength":    "must be at least {min} characters",
+		"max":          "must be at most {max}",
+		"maxLength":    "must be at most {max} characters",
+		"len":          "must be {len} characters",
+		"regexp":       "is invalid",
+		"unsupport":    "has an invalid type",
+		"badParameter": "has an invalid type",
+		"mobile":       "is not a valid mobile number",
+		"idcard":       "is not a valid ID card number",
+		"uscc":         "is not a valid unified social credit code",
+		"email":        "is not a valid email address",
+		"url":          "is not a valid URL",
+		"enum":         "must be one of {enum}",
+		"password":     "is too weak",
+		"eqfield":      "must be the same as {field}",
+		"nefield":      "must be different from {field}",
+		"gtfield":      "must be greater than {field}",
+		"gtefield":     "must not be less than {field}",
+		"ltfield":      "must be less than {field}",
+		"ltefield":     "must not be greater than {field}",
+		"required_if":  "is required",
+	},
 }
 
 // dftChecker 是默认的Checker
@@ -39,24 +96,40 @@ func Check(v interface{}) error {
 	return dftChecker.Check(v)
 }
 
-// New 返回Checker实例
-func NewChecker(ruleTagName, titleTagName string, errorMsgMap map[string]string) Checker {
-	emm := defaultsErrorMsgMap
-	if errorMsgMap != nil {
-		for k, v := range errorMsgMap {
-			emm[k] = v
-		}
-	}
+// CheckLang 调用默认实现的CheckLang
+func CheckLang(v interface{}, acceptLanguage string) error {
+	return dftChecker.CheckLang(v, acceptLanguage)
+}
 
+// RegisterRule 在默认的Checker上注册校验规则，echo的参数校验也使用默认的Checker
+func RegisterRule(name string, rule Rule, msg string) error {
+	return dftChecker.RegisterRule(name, rule, msg)
+}
+
+// NewChecker 返回Checker实例，内置了mobile、idcard、uscc、email、url、enum、password规则，
+// errorMsgMap覆盖默认语言的提示，包括内置规则的提示，Checker之间不共享提示
+func NewChecker(ruleTagName, titleTagName string, errorMsgMap map[string]string) Checker {
 	validator := validator.NewValidator()
 	validator.SetTag(ruleTagName)
 
-	return &checker{
-		ruleTagName:  ruleTagName,
-		titleTagName: titleTagName,
-		errorMsgMap:  emm,
-		validator:    validator,
+	c := &checker{
+		ruleTagName:   ruleTagName,
+		titleTagName:  titleTagName,
+		errorMsgMap:   make(map[string]string, len(defaultsErrorMsgMap)+len(defaultRuleMsgMap)),
+		localeMsgMaps: make(map[string]map[string]string, len(defaultLocaleMsgMaps)),
+		validator:     validator,
+	}
+	for name, rule := range builtinRules {
+		c.RegisterRule(name, rule, defaultRuleMsgMap[name])
+	}
+	for k, v := range defaultsErrorMsgMap {
+		c.errorMsgMap[k] = v
 	}
+	mergeMessages(c.errorMsgMap, errorMsgMap)
+	for lang, msgs := range defaultLocaleMsgMaps {
+		c.SetMessages(lang, msgs)
+	}
+	return c
 }
 
 // ErrorData 是某个字段的校验错误
@@ -85,99 +158,385 @@ func (eArr ErrorArray) Error() string {
 }
 
 type checker struct {
-	ruleTagName  string
-	titleTagName string
-	errorMsgMap  map[string]string
-	validator    *validator.Validator
+	ruleTagName   string
+	titleTagName  string
+	errorMsgMap   map[string]string            // 默认语言的提示
+	localeMsgMaps map[string]map[string]string // 语言标签 => 提示
+	validator     *validator.Validator
 }
 
-// Check 检查struct并返回友好的提示
-func (c *checker) Check(v interface{}) (err error) {
-	var titleMap map[string]string
-	if titleMap, err = c.extractStructFields(v); err != nil {
+// RegisterRule 注册校验规则
+func (c *checker) RegisterRule(name string, rule Rule, msg string) error {
+	err := c.validator.SetValidationFunc(name, func(v interface{}, param string) error {
+		if rule(v, param) {
+			return nil
+		}
+		return ruleError{name: name}
+	})
+	if err != nil {
 		return err
 	}
+	c.errorMsgMap[name] = msg
+	return nil
+}
 
-	getTitle := func(field string) string {
-		if title, ok := titleMap[field]; ok && title != "" {
-			return title
+// SetMessages 设置lang语言的提示，和已有的提示合并
+func (c *checker) SetMessages(lang string, msgs map[string]string) {
+	m, ok := c.localeMsgMaps[lang]
+	if !ok {
+		m = make(map[string]string, len(msgs))
+		c.localeMsgMaps[lang] = m
+	}
+	mergeMessages(m, msgs)
+}
+
+// lengthMsgKeys 是min、max对应的长度提示的键
+var lengthMsgKeys = map[string]string{"min": "minLength", "max": "maxLength"}
+
+// mergeMessages 把msgs合并到m中
+// msgs设置了min、max而没有设置minLength、maxLength时删除m中已有的长度提示，使自定义的min、max也用于字符串、slice、map
+func mergeMessages(m, msgs map[string]string) {
+	for k, v := range msgs {
+		m[k] = v
+	}
+	for k, lk := range lengthMsgKeys {
+		if _, ok := msgs[k]; !ok {
+			continue
+		}
+		if _, ok := msgs[lk]; !ok {
+			delete(m, lk)
 		}
-		return field
 	}
+}
 
-	err = c.validator.Validate(v)
-	if err == nil {
+// localeMsgMap 返回lang语言的提示，没有时依次尝试基础语言（en-US => en），都没有时返回nil
+func (c *checker) localeMsgMap(lang string) map[string]string {
+	if lang == "" {
 		return nil
 	}
+	if m, ok := c.localeMsgMaps[lang]; ok {
+		return m
+	}
+	if i := strings.IndexByte(lang, '-'); i > 0 {
+		return c.localeMsgMaps[lang[:i]]
+	}
+	return nil
+}
+
+// Check 检查struct并返回友好的提示
+// 会递归检查嵌套的struct、指针、slice、array和map，ErrorData.Field是json标签名组成的路径，比如 roles[2].name，
+// 没有json标签时使用字段名，错误按字段定义的顺序排列
+func (c *checker) Check(v interface{}) error {
+	return c.CheckLang(v, "")
+}
 
-	var errArr []ErrorData
-	if errMap, ok := err.(validator.ErrorMap); ok {
-		for k, eArr := range errMap {
-			ed := ErrorData{
-				Field:  k,
-				Title:  getTitle(k),
-				Errors: c.fmtErrArr(eArr),
+// CheckLang 检查struct并返回acceptLanguage语言的提示
+func (c *checker) CheckLang(v interface{}, acceptLanguage string) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return ErrUnsupported
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return ErrUnsupported
+	}
+
+	lang := negotiate(acceptLanguage, func(lang string) bool {
+		return c.localeMsgMap(lang) != nil
+	})
+	cc := checkContext{checker: c, msgs: c.localeMsgMap(lang)}
+
+	var errArr ErrorArray
+	cc.checkStruct(rv, "", &errArr)
+	if len(errArr) == 0 {
+		return nil
+	}
+	return errArr
+}
+
+// checkStruct 按字段顺序检查struct的导出字段，prefix是struct自身的路径
+func (c checkContext) checkStruct(sv reflect.Value, prefix string, errArr *ErrorArray) {
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		sf := st.Field(i)
+		exported := sf.PkgPath == ""
+		// 未导出类型的嵌入字段中仍然可能有导出的字段
+		if !exported && !sf.Anonymous {
+			continue
+		}
+		rule := sf.Tag.Get(c.ruleTagName)
+		if rule == "-" {
+			continue
+		}
+
+		fv := sv.Field(i)
+		path := prefix
+		// 没有json标签的嵌入字段和json一样展开到上一层
+		if !sf.Anonymous || sf.Tag.Get("json") != "" {
+			path = joinFieldPath(prefix, jsonFieldName(sf))
+		}
+
+		if rule != "" && exported {
+			title := sf.Tag.Get(c.titleTagName)
+			if title == "" {
+				title = path
+			}
+			if errs := c.fmtErrArr(fv, title, c.validate(sv, fv, rule)); len(errs) > 0 {
+				*errArr = append(*errArr, ErrorData{Field: path, Title: title, Errors: errs})
+			}
+		}
+		c.checkNested(fv, path, errArr)
+	}
+
+	if sc, ok := structChecker(sv); ok {
+		for _, ed := range sc.CheckStruct() {
+			if ed.Title == "" {
+				ed.Title = c.fieldTitle(st, ed.Field)
+			}
+			ed.Field = joinFieldPath(prefix, ed.Field)
+			mergeErrorData(errArr, ed)
+		}
+	}
+}
+
+// structChecker 返回sv或者sv的指针实现的StructChecker
+func structChecker(sv reflect.Value) (StructChecker, bool) {
+	// 未导出的嵌入字段不能调用
+	if !sv.CanInterface() {
+		return nil, false
+	}
+	if sv.CanAddr() {
+		if sc, ok := sv.Addr().Interface().(StructChecker); ok {
+			return sc, true
+		}
+	}
+	sc, ok := sv.Interface().(StructChecker)
+	return sc, ok
+}
+
+// fieldTitle 返回json名为name的字段的标题，没有标题时返回name
+func (c *checker) fieldTitle(st reflect.Type, name string) string {
+	for i := 0; i < st.NumField(); i++ {
+		sf := st.Field(i)
+		if jsonFieldName(sf) == name || sf.Name == name {
+			if title := sf.Tag.Get(c.titleTagName); title != "" {
+				return title
 			}
-			errArr = append(errArr, ed)
+			break
 		}
 	}
-	return ErrorArray(errArr)
+	return name
 }
 
-func (c checker) fmtErrArr(errArr validator.ErrorArray) []string {
+// mergeErrorData 把ed合并到errArr中相同字段的错误，没有时追加
+func mergeErrorData(errArr *ErrorArray, ed ErrorData) {
+	for i := range *errArr {
+		if (*errArr)[i].Field == ed.Field {
+			(*errArr)[i].Errors = append((*errArr)[i].Errors, ed.Errors...)
+			return
+		}
+	}
+	*errArr = append(*errArr, ed)
+}
+
+// checkNested 检查v中嵌套的struct
+func (c checkContext) checkNested(v reflect.Value, path string, errArr *ErrorArray) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			c.checkNested(v.Elem(), path, errArr)
+		}
+	case reflect.Struct:
+		c.checkStruct(v, path, errArr)
+	case reflect.Slice, reflect.Array:
+		switch v.Type().Elem().Kind() {
+		case reflect.Struct, reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Array, reflect.Map:
+			for i := 0; i < v.Len(); i++ {
+				c.checkNested(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errArr)
+			}
+		}
+	case reflect.Map:
+		switch v.Type().Elem().Kind() {
+		case reflect.Struct, reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Array, reflect.Map:
+			keys := v.MapKeys()
+			sort.Slice(keys, func(i, j int) bool {
+				return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+			})
+			for _, key := range keys {
+				c.checkNested(v.MapIndex(key), fmt.Sprintf("%s[%v]", path, key.Interface()), errArr)
+			}
+		}
+	}
+}
+
+// checkContext 是一次校验的上下文
+type checkContext struct {
+	*checker
+	msgs map[string]string // 协商出的语言的提示，为nil时使用默认语言
+}
+
+// ruleFailure 是一条校验规则的失败
+type ruleFailure struct {
+	name  string
+	param string
+	field string // 跨字段规则引用的字段的标题
+	err   error
+}
+
+// separate by no escaped commas，和validator.v2相同
+var ruleSepPattern = regexp.MustCompile(`((?:^|[^\\])(?:\\\\)*),`)
+
+// splitRules 把标签按未转义的逗号分成单条规则
+func splitRules(rule string) []string {
+	var rules []string
+	last := 0
+	for _, is := range ruleSepPattern.FindAllStringIndex(rule, -1) {
+		rules = append(rules, rule[last:is[1]-1])
+		last = is[1]
+	}
+	return append(rules, rule[last:])
+}
+
+// validate 逐条按规则校验字段的值，以便得到每条失败规则的参数，sv是字段所在的struct，用于跨字段的规则
+func (c *checker) validate(sv, v reflect.Value, rule string) []ruleFailure {
+	v = indirect(v)
+	var value interface{}
+	if v.IsValid() {
+		value = v.Interface()
+	}
+
+	var failures []ruleFailure
+	for _, r := range splitRules(rule) {
+		nameParam := strings.SplitN(r, "=", 2)
+		f := ruleFailure{name: strings.TrimSpace(nameParam[0])}
+		if len(nameParam) > 1 {
+			f.param = strings.TrimSpace(strings.Replace(nameParam[1], `\,`, ",", -1))
+		}
+
+		if fr, ok := fieldRules[f.name]; ok {
+			// 参数的第一部分是同一struct中的字段名
+			otherName := strings.SplitN(f.param, " ", 2)[0]
+			sf, found := sv.Type().FieldByName(otherName)
+			if !found || sf.PkgPath != "" {
+				f.err = validator.ErrBadParameter
+				failures = append(failures, f)
+				continue
+			}
+			if !fr(v, indirect(sv.FieldByIndex(sf.Index)), f.param) {
+				f.err = ruleError{name: f.name}
+				f.field = sf.Tag.Get(c.titleTagName)
+				if f.field == "" {
+					f.field = otherName
+				}
+				failures = append(failures, f)
+			}
+			continue
+		}
+
+		err := c.validator.Valid(value, r)
+		if err == nil {
+			continue
+		}
+		if errArr, ok := err.(validator.ErrorArray); ok {
+			for _, e := range errArr {
+				f.err = e
+				failures = append(failures, f)
+			}
+		} else {
+			f.err = err
+			failures = append(failures, f)
+		}
+	}
+	return failures
+}
+
+// jsonFieldName 返回字段的json名，没有json标签或者为-时返回字段名
+func jsonFieldName(sf reflect.StructField) string {
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+func joinFieldPath(prefix, name string) string {
+	if prefix == "" || name == "" {
+		return prefix + name
+	}
+	return prefix + "." + name
+}
+
+// fmtErrArr 把失败的规则格式化成提示，没有提示的错误被忽略
+func (c checkContext) fmtErrArr(v reflect.Value, title string, failures []ruleFailure) []string {
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	lengthy := false
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		lengthy = true
+	}
+
 	ss := []string{}
-	for _, er := range errArr {
-		switch er {
+	for _, f := range failures {
+		var keys []string
+		switch f.err {
 		case validator.ErrZeroValue:
-			ss = append(ss, c.errorMsgMap["zeroValue"])
-			break
+			keys = []string{"zeroValue"}
 		case validator.ErrMin:
-			ss = append(ss, c.errorMsgMap["min"])
-			break
+			if lengthy {
+				keys = append(keys, "minLength")
+			}
+			keys = append(keys, "min")
 		case validator.ErrMax:
-			ss = append(ss, c.errorMsgMap["max"])
-			break
+			if lengthy {
+				keys = append(keys, "maxLength")
+			}
+			keys = append(keys, "max")
 		case validator.ErrLen:
-			ss = append(ss, c.errorMsgMap["len"])
-			break
+			keys = []string{"len"}
 		case validator.ErrRegexp:
-			ss = append(ss, c.errorMsgMap["regexp"])
-			break
+			keys = []string{"regexp"}
 		case validator.ErrUnsupported:
-			ss = append(ss, c.errorMsgMap["unsupport"])
-			break
+			keys = []string{"unsupport"}
 		case validator.ErrBadParameter:
-			ss = append(ss, c.errorMsgMap["badParameter"])
-			break
+			keys = []string{"badParameter"}
+		default:
+			if re, ok := f.err.(ruleError); ok {
+				keys = []string{re.name}
+			}
+		}
+
+		if msg, ok := c.message(keys); ok {
+			ss = append(ss, fmtMsg(msg, map[string]string{"title": title, "param": f.param, "field": f.field, f.name: f.param}))
 		}
 	}
 	return ss
 }
 
-// extractStructFields 从struct中提取标题，组成以字段名为键标题为值的map返回
-func (c checker) extractStructFields(v interface{}) (map[string]string, error) {
-	rv := reflect.ValueOf(v)
-	kind := rv.Kind()
-	if kind != reflect.Struct && kind != reflect.Ptr {
-		return nil, ErrUnsupported
-	}
-
-	if kind == reflect.Ptr {
-		if rv.Elem().Kind() != reflect.Struct {
-			return nil, ErrUnsupported
+// message 按顺序查找keys的提示，先查协商出的语言，再查默认语言
+func (c checkContext) message(keys []string) (string, bool) {
+	for _, m := range []map[string]string{c.msgs, c.errorMsgMap} {
+		for _, k := range keys {
+			if msg, ok := m[k]; ok {
+				return msg, true
+			}
 		}
-		rv = rv.Elem()
 	}
+	return "", false
+}
 
-	st := rv.Type()
-	nfields := st.NumField()
-
-	m := make(map[string]string, nfields)
-	for i := 0; i < nfields; i++ {
-		sf := st.Field(i)
-		title := sf.Tag.Get(c.titleTagName)
-		m[sf.Name] = title
+// fmtMsg 替换提示中的{name}参数，没有的参数保持原样
+func fmtMsg(msg string, params map[string]string) string {
+	if !strings.Contains(msg, "{") {
+		return msg
 	}
-
-	return m, nil
+	return fasttemplate.ExecuteFuncString(msg, "{", "}", func(w io.Writer, tag string) (int, error) {
+		if v, ok := params[tag]; ok {
+			return w.Write([]byte(v))
+		}
+		return w.Write([]byte("{" + tag + "}"))
+	})
 }
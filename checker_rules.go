@@ -0,0 +1,310 @@
+package quick
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Rule 是自定义校验规则，v是字段的值（指针已解引用），param是标签中的参数，比如 enum=a|b 中的 a|b，校验通过时返回true
+type Rule func(v interface{}, param string) bool
+
+// ruleError 是自定义规则校验失败的错误
+type ruleError struct {
+	name string
+}
+
+func (re ruleError) Error() string {
+	return re.name + " mismatch"
+}
+
+// defaultRuleMsgMap 是内置规则的提示
+var defaultRuleMsgMap = map[string]string{
+	"mobile":   "不是有效的手机号",
+	"idcard":   "不是有效的身份证号",
+	"uscc":     "不是有效的统一社会信用代码",
+	"email":    "不是有效的邮箱",
+	"url":      "不是有效的网址",
+	"enum":     "不在可选范围内",
+	"password": "强度不够",
+}
+
+// builtinRules 是内置规则，空字符串和nil指针都视为通过，必填需要和nonzero一起使用
+var builtinRules = map[string]Rule{
+	"mobile":   optional(stringRule(isMobile)),
+	"idcard":   optional(stringRule(isIDCard)),
+	"uscc":     optional(stringRule(isUSCC)),
+	"email":    optional(stringRule(isEmail)),
+	"url":      optional(stringRule(isURL)),
+	"enum":     optional(isEnum),
+	"password": optional(passwordRule),
+}
+
+// optional 使nil指针通过校验
+func optional(rule Rule) Rule {
+	return func(v interface{}, param string) bool {
+		if v == nil {
+			return true
+		}
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return true
+		}
+		return rule(v, param)
+	}
+}
+
+// stringRule 把只接受字符串的校验转成Rule，非字符串不通过
+func stringRule(fn func(s string) bool) Rule {
+	return func(v interface{}, param string) bool {
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		return s == "" || fn(s)
+	}
+}
+
+var (
+	mobileRe = regexp.MustCompile(`^1[3-9]\d{9}$`)
+	emailRe  = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
+)
+
+// isMobile 大陆手机号
+func isMobile(s string) bool {
+	return mobileRe.MatchString(s)
+}
+
+var (
+	idcardWeights = []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	idcardChecks  = "10X98765432"
+)
+
+// isIDCard 18位居民身份证号，校验出生日期和校验位
+func isIDCard(s string) bool {
+	if len(s) != 18 {
+		return false
+	}
+	s = strings.ToUpper(s)
+	sum := 0
+	for i := 0; i < 17; i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+		sum += int(s[i]-'0') * idcardWeights[i]
+	}
+	if _, err := time.Parse("20060102", s[6:14]); err != nil {
+		return false
+	}
+	return s[17] == idcardChecks[sum%11]
+}
+
+var (
+	usccChars   = "0123456789ABCDEFGHJKLMNPQRTUWXY"
+	usccWeights = []int{1, 3, 9, 27, 19, 26, 16, 17, 20, 29, 25, 13, 8, 24, 10, 30, 28}
+)
+
+// isUSCC 18位统一社会信用代码，校验字符集和校验位
+func isUSCC(s string) bool {
+	if len(s) != 18 {
+		return false
+	}
+	s = strings.ToUpper(s)
+	sum := 0
+	for i := 0; i < 17; i++ {
+		n := strings.IndexByte(usccChars, s[i])
+		if n < 0 {
+			return false
+		}
+		sum += n * usccWeights[i]
+	}
+	check := (31 - sum%31) % 31
+	return s[17] == usccChars[check]
+}
+
+// isEmail 邮箱
+func isEmail(s string) bool {
+	return emailRe.MatchString(s)
+}
+
+// isURL http或https的网址
+func isURL(s string) bool {
+	u, err := url.ParseRequestURI(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isEnum 值在param中，param用|分隔，比如 enum=male|female、enum=1|2|3
+func isEnum(v interface{}, param string) bool {
+	var s string
+	switch t := v.(type) {
+	case string:
+		if t == "" {
+			return true
+		}
+		s = t
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		s = fmt.Sprint(t)
+	default:
+		return false
+	}
+	for _, option := range strings.Split(param, "|") {
+		if option == s {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordRule 密码强度，至少包含param种字符（小写字母、大写字母、数字、符号），param默认为3
+func passwordRule(v interface{}, param string) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	if s == "" {
+		return true
+	}
+	min := 3
+	if param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return false
+		}
+		min = n
+	}
+
+	var lower, upper, digit, symbol int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower+upper+digit+symbol >= min
+}
+
+// fieldRules 是跨字段的规则，参数以同一struct中的字段名开头，比如 eqfield=Password、required_if=Active true
+// v和other都已解引用，校验通过时返回true
+var fieldRules = map[string]func(v, other reflect.Value, param string) bool{
+	"eqfield": func(v, other reflect.Value, param string) bool {
+		return equalValue(v, other)
+	},
+	"nefield": func(v, other reflect.Value, param string) bool {
+		return !equalValue(v, other)
+	},
+	"gtfield": func(v, other reflect.Value, param string) bool {
+		n, ok := compareValue(v, other)
+		return ok && n > 0
+	},
+	"gtefield": func(v, other reflect.Value, param string) bool {
+		n, ok := compareValue(v, other)
+		return ok && n >= 0
+	},
+	"ltfield": func(v, other reflect.Value, param string) bool {
+		n, ok := compareValue(v, other)
+		return ok && n < 0
+	},
+	"ltefield": func(v, other reflect.Value, param string) bool {
+		n, ok := compareValue(v, other)
+		return ok && n <= 0
+	},
+	// required_if=Field value 当Field的值为value时不能为空
+	"required_if": func(v, other reflect.Value, param string) bool {
+		parts := strings.SplitN(param, " ", 2)
+		want := ""
+		if len(parts) > 1 {
+			want = strings.TrimSpace(parts[1])
+		}
+		if !other.IsValid() || fmt.Sprint(other.Interface()) != want {
+			return true
+		}
+		return v.IsValid() && !v.IsZero()
+	},
+}
+
+// indirect 解引用非nil的指针和接口
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+func equalValue(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if n, ok := compareValue(a, b); ok {
+		return n == 0
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// compareValue 比较数字、字符串和时间，类型不能比较时ok为false
+func compareValue(a, b reflect.Value) (n int, ok bool) {
+	if !a.IsValid() || !b.IsValid() {
+		return 0, false
+	}
+	if ta, isTime := a.Interface().(time.Time); isTime {
+		tb, isTime := b.Interface().(time.Time)
+		if !isTime {
+			return 0, false
+		}
+		switch {
+		case ta.Before(tb):
+			return -1, true
+		case ta.After(tb):
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		fa, okA := toFloat(a)
+		fb, okB := toFloat(b)
+		if !okA || !okB {
+			return 0, false
+		}
+		switch {
+		case fa < fb:
+			return -1, true
+		case fa > fb:
+			return 1, true
+		}
+		return 0, true
+	case reflect.String:
+		if b.Kind() != reflect.String {
+			return 0, false
+		}
+		return strings.Compare(a.String(), b.String()), true
+	}
+	return 0, false
+}
+
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
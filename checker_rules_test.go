@@ -0,0 +1,72 @@
+package quick
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinRules(t *testing.T) {
+	cases := []struct {
+		rule  string
+		param string
+		v     interface{}
+		ok    bool
+	}{
+		{"mobile", "", "13800138000", true},
+		{"mobile", "", "12800138000", false},
+		{"mobile", "", "1380013800", false},
+		{"mobile", "", "", true},
+		{"mobile", "", 13800138000, false},
+		{"idcard", "", "11010519491231002X", true},
+		{"idcard", "", "11010519491231002x", true},
+		{"idcard", "", "110105194912310021", false},
+		{"idcard", "", "11010519491331002X", false},
+		{"uscc", "", "91350100M000100Y43", true},
+		{"uscc", "", "91350100M000100Y44", false},
+		{"uscc", "", "91350100I000100Y43", false},
+		{"email", "", "a.b+c@example.com", true},
+		{"email", "", "a@b", false},
+		{"url", "", "https://example.com/a?b=1", true},
+		{"url", "", "ftp://example.com", false},
+		{"url", "", "example.com", false},
+		{"enum", "male|female", "male", true},
+		{"enum", "male|female", "other", false},
+		{"enum", "1|2|3", 2, true},
+		{"enum", "1|2|3", uint8(4), false},
+		{"password", "", "abcDEF12", true},
+		{"password", "", "abcdef12", false},
+		{"password", "2", "abcdef12", true},
+		{"password", "4", "abcDEF1!", true},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.ok, builtinRules[tc.rule](tc.v, tc.param), "%s=%s %v", tc.rule, tc.param, tc.v)
+	}
+}
+
+type forTestRules struct {
+	Mobile string  `json:"mobile" validate:"nonzero,mobile" title:"手机号"`
+	Gender string  `json:"gender" validate:"enum=male|female" title:"性别"`
+	Email  *string `json:"email" validate:"email" title:"邮箱"`
+	Nick   string  `json:"nick" validate:"nick" title:"昵称"`
+}
+
+func TestCheckerRegisterRule(t *testing.T) {
+	c := NewChecker("validate", "title", map[string]string{"mobile": "手机号格式错误"})
+	assert.Nil(t, c.RegisterRule("nick", func(v interface{}, param string) bool {
+		return !strings.Contains(v.(string), "admin")
+	}, "不能包含admin"))
+
+	email := "bad"
+	err := c.Check(forTestRules{Mobile: "123", Gender: "x", Email: &email, Nick: "admin1"})
+	assert.Equal(t, ErrorArray{
+		{Field: "mobile", Title: "手机号", Errors: []string{"手机号格式错误"}},
+		{Field: "gender", Title: "性别", Errors: []string{"不在可选范围内"}},
+		{Field: "email", Title: "邮箱", Errors: []string{"不是有效的邮箱"}},
+		{Field: "nick", Title: "昵称", Errors: []string{"不能包含admin"}},
+	}, err)
+
+	assert.Nil(t, c.Check(forTestRules{Mobile: "13800138000", Gender: "male", Nick: "n"}))
+	assert.NotNil(t, c.RegisterRule("", nil, ""))
+}
@@ -2,6 +2,7 @@ package quick
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -23,6 +24,157 @@ func TestExtractTitleMap(t *testing.T) {
 	assert.Equal(t, 3, len(errArr))
 	assert.Equal(t, ErrorData{Field: "ID", Title: "编号", Errors: []string{"不能为空"}}, errArr[0])
 	assert.Equal(t, ErrorData{Field: "Name", Title: "姓名", Errors: []string{"不能为空"}}, errArr[1])
-	assert.Equal(t, ErrorData{Field: "Password", Title: "密码", Errors: []string{"不能为空", "太小了"}}, errArr[2])
-	assert.Equal(t, "编号: 不能为空, 姓名: 不能为空, 密码: 不能为空, 太小了", errArr.Error())
+	assert.Equal(t, ErrorData{Field: "Password", Title: "密码", Errors: []string{"不能为空", "长度不能小于6"}}, errArr[2])
+	assert.Equal(t, "编号: 不能为空, 姓名: 不能为空, 密码: 不能为空, 长度不能小于6", errArr.Error())
+}
+
+type forTestRole struct {
+	Name string `json:"name" validate:"nonzero" title:"角色名称"`
+}
+
+type forTestProfile struct {
+	Mobile string `json:"mobile" validate:"len=11" title:"手机号"`
+}
+
+type forTestBase struct {
+	Code string `json:"code" validate:"nonzero" title:"编码"`
+}
+
+type forTestNested struct {
+	forTestBase
+	Name    string                 `json:"name,omitempty" validate:"nonzero" title:"姓名"`
+	Profile *forTestProfile        `json:"profile"`
+	Roles   []forTestRole          `json:"roles" validate:"min=1" title:"角色"`
+	Extra   map[string]forTestRole `json:"extra"`
+	Skip    forTestRole            `json:"skip" validate:"-"`
+	Plain   forTestRole
+}
+
+func TestCheckNested(t *testing.T) {
+	v := &forTestNested{
+		Name:    "a",
+		Profile: &forTestProfile{Mobile: "123"},
+		Roles:   []forTestRole{{Name: "r"}, {}, {}},
+		Extra:   map[string]forTestRole{"b": {}, "a": {Name: "x"}},
+	}
+	err := Check(v)
+	errArr, ok := err.(ErrorArray)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, ErrorArray{
+		{Field: "code", Title: "编码", Errors: []string{"不能为空"}},
+		{Field: "profile.mobile", Title: "手机号", Errors: []string{"长度必须为11"}},
+		{Field: "roles[1].name", Title: "角色名称", Errors: []string{"不能为空"}},
+		{Field: "roles[2].name", Title: "角色名称", Errors: []string{"不能为空"}},
+		{Field: "extra[b].name", Title: "角色名称", Errors: []string{"不能为空"}},
+		{Field: "Plain.name", Title: "角色名称", Errors: []string{"不能为空"}},
+	}, errArr)
+
+	assert.Equal(t, ErrUnsupported, Check("string"))
+	assert.Nil(t, Check(&forTestNested{
+		forTestBase: forTestBase{Code: "c"},
+		Name:        "a",
+		Roles:       []forTestRole{{Name: "r"}},
+		Plain:       forTestRole{Name: "p"},
+	}))
+}
+
+type forTestMsg struct {
+	Age      int      `json:"age" validate:"min=18,max=60" title:"年龄"`
+	Password string   `json:"password" validate:"min=6" title:"密码"`
+	Code     string   `json:"code" validate:"regexp=^[a-z]{2\\,4}$" title:"编码"`
+	Tags     []string `json:"tags" validate:"max=2" title:"标签"`
+	Gender   string   `json:"gender" validate:"enum=male|female" title:"性别"`
+}
+
+func TestCheckMessages(t *testing.T) {
+	c := NewChecker("validate", "title", map[string]string{"min": "{title}不能小于{min}"})
+	c.SetMessages("zh-TW", map[string]string{"regexp": "格式不正確"})
+	v := forTestMsg{Age: 70, Password: "123", Code: "A", Tags: []string{"a", "b", "c"}, Gender: "x"}
+
+	assert.Equal(t, ErrorArray{
+		{Field: "age", Title: "年龄", Errors: []string{"不能大于60"}},
+		{Field: "password", Title: "密码", Errors: []string{"密码不能小于6"}},
+		{Field: "code", Title: "编码", Errors: []string{"不符合规则"}},
+		{Field: "tags", Title: "标签", Errors: []string{"长度不能大于2"}},
+		{Field: "gender", Title: "性别", Errors: []string{"不在可选范围内"}},
+	}, c.Check(v))
+
+	v.Age = 10
+	assert.Equal(t, ErrorData{Field: "age", Title: "年龄", Errors: []string{"年龄不能小于18"}}, c.Check(v).(ErrorArray)[0])
+
+	errArr := c.CheckLang(v, "en-US,en;q=0.9").(ErrorArray)
+	assert.Equal(t, []string{"must be at least 18"}, errArr[0].Errors)
+	assert.Equal(t, []string{"must be at least 6 characters"}, errArr[1].Errors)
+	assert.Equal(t, []string{"must be one of male|female"}, errArr[4].Errors)
+
+	errArr = c.CheckLang(v, "zh-TW").(ErrorArray)
+	assert.Equal(t, []string{"格式不正確"}, errArr[2].Errors)
+	assert.Equal(t, []string{"年龄不能小于18"}, errArr[0].Errors)
+
+	// 只设置min时也用于长度
+	c.SetMessages("en", map[string]string{"min": "at least {min}"})
+	errArr = c.CheckLang(v, "en").(ErrorArray)
+	assert.Equal(t, []string{"at least 6"}, errArr[1].Errors)
+	assert.Equal(t, []string{"must be at most 2 characters"}, errArr[3].Errors)
+
+	// 自定义提示不影响其他Checker
+	assert.Equal(t, []string{"不能小于18"}, Check(v).(ErrorArray)[0].Errors)
+	assert.Equal(t, []string{"长度不能小于6"}, Check(v).(ErrorArray)[1].Errors)
+	assert.Equal(t, "不能小于{min}", defaultsErrorMsgMap["min"])
+}
+
+type forTestPassword struct {
+	Origin   string `json:"origin" validate:"nonzero" title:"原密码"`
+	Password string `json:"password" validate:"nonzero,nefield=Origin" title:"新密码"`
+	Confirm  string `json:"confirm" validate:"eqfield=Password" title:"确认密码"`
+}
+
+type forTestPeriod struct {
+	Active  bool      `json:"active"`
+	Reason  string    `json:"reason" validate:"required_if=Active true" title:"原因"`
+	StartAt time.Time `json:"startAt" title:"开始时间"`
+	EndAt   time.Time `json:"endAt" validate:"gtfield=StartAt" title:"结束时间"`
+	Min     int       `json:"min" title:"最小值"`
+	Max     *int      `json:"max" validate:"gtefield=Min" title:"最大值"`
+	Bad     string    `json:"bad" validate:"eqfield=Missing"`
+}
+
+// CheckStruct 实现StructChecker
+func (p *forTestPeriod) CheckStruct() ErrorArray {
+	if p.EndAt.Sub(p.StartAt) > 24*time.Hour {
+		return ErrorArray{{Field: "endAt", Errors: []string{"不能超过一天"}}, {Errors: []string{"时间段错误"}}}
+	}
+	return nil
+}
+
+func TestCheckCrossField(t *testing.T) {
+	err := Check(forTestPassword{Origin: "a", Password: "a", Confirm: "b"})
+	assert.Equal(t, ErrorArray{
+		{Field: "password", Title: "新密码", Errors: []string{"不能与原密码相同"}},
+		{Field: "confirm", Title: "确认密码", Errors: []string{"必须与新密码相同"}},
+	}, err)
+	assert.Nil(t, Check(forTestPassword{Origin: "a", Password: "b", Confirm: "b"}))
+
+	now := time.Now()
+	max := 1
+	v := struct {
+		Period forTestPeriod `json:"period"`
+	}{forTestPeriod{Active: true, StartAt: now, EndAt: now.Add(48 * time.Hour), Min: 2, Max: &max}}
+	err = CheckLang(&v, "en")
+	assert.Equal(t, ErrorArray{
+		{Field: "period.reason", Title: "原因", Errors: []string{"is required"}},
+		{Field: "period.max", Title: "最大值", Errors: []string{"must not be less than 最小值"}},
+		{Field: "period.bad", Title: "period.bad", Errors: []string{"has an invalid type"}},
+		{Field: "period.endAt", Title: "结束时间", Errors: []string{"不能超过一天"}},
+		{Field: "period", Title: "", Errors: []string{"时间段错误"}},
+	}, err)
+
+	v.Period = forTestPeriod{StartAt: now, EndAt: now, Min: 1, Max: &max, Bad: ""}
+	errArr := Check(&v).(ErrorArray)
+	assert.Equal(t, ErrorArray{
+		{Field: "period.endAt", Title: "结束时间", Errors: []string{"必须大于开始时间"}},
+		{Field: "period.bad", Title: "period.bad", Errors: []string{"类型错误"}},
+	}, errArr)
 }
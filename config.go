@@ -5,11 +5,38 @@ import "github.com/BurntSushi/toml"
 type (
 	// Config 配置
 	Config struct {
-		APIAddr     string `toml:"api_addr"`
-		MysqlDSN    string `toml:"mysql_dsn"`
-		EnableDBLog bool   `toml:"enable_db_log"`
-		Log         Log    `toml:"log"`
-		Redis       Redis  `toml:"redis"`
+		APIAddr     string        `toml:"api_addr"`
+		MysqlDSN    string        `toml:"mysql_dsn"`
+		EnableDBLog bool          `toml:"enable_db_log"`
+		Log         Log           `toml:"log"`
+		Redis       Redis         `toml:"redis"`
+		TaskQueue   TaskQueue     `toml:"task_queue"`
+		PubSub      PubSubConfig  `toml:"pubsub"`
+		Outbox      Outbox        `toml:"outbox"`
+		Error       ErrorConfig   `toml:"error"`
+		Alarm       AlarmConfig   `toml:"alarm"`
+		OpenAPI     OpenAPIConfig `toml:"openapi"`
+	}
+
+	// OpenAPIConfig OpenAPI文档配置，文档由通过Context.Route注册的路由生成
+	OpenAPIConfig struct {
+		Enable  bool   `toml:"enable"`
+		Path    string `toml:"path"`    // 文档路径，默认/pub/openapi.json
+		UIPath  string `toml:"ui_path"` // 文档页面路径，比如/pub/docs，页面从CDN加载swagger-ui，为空时不提供页面
+		Title   string `toml:"title"`   // 文档标题，默认API
+		Version string `toml:"version"` // 文档版本，默认1.0.0
+	}
+
+	// AlarmConfig 警报配置，5xx错误、panic和定时任务失败会上报到警报器
+	AlarmConfig struct {
+		Backend  string `toml:"backend"`  // 警报器：空（默认，不上报）、redis、mysql（需要创建alarm_log表），通过WithAlarm设置时忽略
+		Interval int    `toml:"interval"` // 相同错误的最短上报间隔，单位秒，默认60
+	}
+
+	// ErrorConfig 错误响应配置
+	ErrorConfig struct {
+		Format   string `toml:"format"`    // 响应格式：legacy（默认）、problem（RFC 7807 application/problem+json）
+		TypeBase string `toml:"type_base"` // problem格式中type的前缀，比如https://example.com/errors/，type为前缀加错误码
 	}
 
 	// Redis redis配置
@@ -19,6 +46,35 @@ type (
 		DB       int    `toml:"db"`
 	}
 
+	// TaskQueue 任务队列配置
+	TaskQueue struct {
+		Backend           string `toml:"backend"`            // 存储后端：mem（默认）、redis、db
+		Prefix            string `toml:"prefix"`             // redis后端的键前缀
+		Concurrency       int    `toml:"concurrency"`        // 同时执行任务的worker数，默认4
+		PollInterval      int    `toml:"poll_interval"`      // 没有任务时的轮询间隔，单位毫秒，默认1000
+		VisibilityTimeout int    `toml:"visibility_timeout"` // 任务取出后多久未确认会重新投递，单位秒，默认300
+		MaxRetry          int    `toml:"max_retry"`          // 默认最多重试次数，默认3，小于0表示不重试
+	}
+
+	// PubSubConfig 事件系统配置
+	PubSubConfig struct {
+		Backend    string `toml:"backend"`     // 实现：mem（默认）、redis
+		Mode       string `toml:"mode"`        // redis实现的模式：broadcast（默认，所有实例都收到事件）、stream（同一订阅在实例间竞争消费）
+		Prefix     string `toml:"prefix"`      // redis键和频道的前缀
+		Group      string `toml:"group"`       // stream模式的消费者组名前缀，同一服务的实例需要相同，默认quick，组名为前缀:订阅者名
+		Consumer   string `toml:"consumer"`    // stream模式的消费者名，默认为主机名-进程号
+		MaxLen     int64  `toml:"max_len"`     // stream模式每个主题保留的事件数量上限，默认10000
+		ClaimIdle  int    `toml:"claim_idle"`  // stream模式下其他消费者超过该时间未确认的事件会被认领，单位秒，默认60
+		DeadLetter string `toml:"dead_letter"` // 死信存储：mem（默认，保留最近1000条）、db（需要通过MigrateDeadLetter创建表）
+	}
+
+	// Outbox 发件箱配置，数据库中存在outbox表时才会启动投递
+	Outbox struct {
+		PollInterval int `toml:"poll_interval"` // 轮询间隔，单位毫秒，默认1000
+		BatchSize    int `toml:"batch_size"`    // 每次投递的数量，默认100
+		Retention    int `toml:"retention"`     // 已投递事件的保留天数，默认7
+	}
+
 	// Log 日志配置
 	Log struct {
 		Level      string `toml:"level"`       // 日志级别
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"runtime/debug"
 	"sync"
 	"time"
 
@@ -27,14 +28,37 @@ type (
 		GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc)
 		// POST 注册HTTP POST路由
 		POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc)
+		// Route 注册HTTP路由并记录文档，开启openapi配置时会出现在生成的OpenAPI文档中
+		Route(method, path string, h echo.HandlerFunc, doc RouteDoc, m ...echo.MiddlewareFunc)
+		// OpenAPI 根据通过Route注册的路由生成OpenAPI文档
+		OpenAPI() *OpenAPIDoc
 		// 注册中间件
 		Use(middlewares ...echo.MiddlewareFunc)
 		// Schedule 注册定时任务
 		Schedule(expr string, job Job)
-		// Publish 发布事件
-		Publish(topic string, payload string)
-		// Subscribe 订阅事件
-		Subscribe(topic string, cb func(string))
+		// Publish 发布事件，事件系统关闭后返回ErrPubSubClosed
+		Publish(topic string, payload string) error
+		// PublishTx 在事务tx中发布事件，事务提交后事件才会被投递
+		// 需要通过MigrateOutbox创建发件箱表
+		PublishTx(tx *gorm.DB, topic string, payload string) error
+		// Subscribe 订阅事件，topic可以包含通配符*和>，opts可设置缓冲区大小、溢出策略、并发数和重试
+		// 返回的Subscription可以用来取消订阅，回调panic时事件写入死信
+		Subscribe(topic string, cb func(string), opts ...SubscribeOption) (Subscription, error)
+		// SubscribeE 和Subscribe相同，cb返回error时按WithRetry重试，重试用尽后事件写入死信
+		SubscribeE(topic string, cb func(string) error, opts ...SubscribeOption) (Subscription, error)
+		// SubscribeTopicE 和SubscribeE相同，cb同时收到事件的实际主题，用于通配符订阅
+		SubscribeTopicE(topic string, cb func(topic, payload string) error, opts ...SubscribeOption) (Subscription, error)
+		// DeadLetters 返回死信存储，可用于查询死信
+		DeadLetters() DeadLetterStore
+		// ReplayDeadLetter 重新处理死信，订阅者仍存在时直接交给它处理，否则重新发布到原主题
+		ReplayDeadLetter(ctx context.Context, id uint) error
+		// PubSubStats 返回各订阅的统计信息
+		PubSubStats() []SubscriptionStats
+		// RegisterTask 注册任务处理方法，name和Enqueue的name对应
+		RegisterTask(name string, h TaskHandler)
+		// Enqueue 投递任务，返回任务ID，opts可设置延迟、优先级、唯一键等
+		// 任务会持久化到配置的存储后端，重启后仍会执行
+		Enqueue(ctx context.Context, name string, payload string, opts ...TaskOption) (string, error)
 		// GetDB 获取数据库连接实例
 		GetDB() *gorm.DB
 		// GetRedis 获取Redis连接实例
@@ -45,8 +69,11 @@ type (
 		Provide(id string, obj interface{})
 		// Take 获取资源，即通过Provide提供的资源
 		Take(id string) interface{}
+		// Done 返回的channel在服务开始停止时关闭
+		// SSE、WebSocket等长连接需要监听它并及时结束，否则HTTP服务要等到超时才能停止
+		Done() <-chan struct{}
 		// RegisterShutdown 注册停止服务前调用的方法
-		// 当服务停止时，会先停止HTTP服务、定时任务、事件系统，当这3者停止后，
+		// 当服务停止时，会先停止HTTP服务、定时任务、事件系统、任务队列，当这4者停止后，
 		// 调用通过ReigsterShutdown注册的方法
 		RegisterShutdown(hook OnShutdown)
 	}
@@ -65,6 +92,26 @@ type quickContext struct {
 	modules       []Module
 	shutdownHooks []OnShutdown
 	pubsub        PubSub
+	tasks         *taskQueue
+	outbox        *outboxRelay
+	deadLetters   DeadLetterStore
+	alarm         *alarmReporter
+	routes        openAPIRegistry
+	done          chan struct{}
+	subHandlers   map[string]*retryHandler // 订阅者名 => 回调，用于重新处理死信
+	subCounts     map[string]int           // 主题 => 订阅次数，用于生成默认订阅者名
+}
+
+// contextSubscription 在取消订阅时移除订阅者的回调
+type contextSubscription struct {
+	Subscription
+	detach func()
+}
+
+// Unsubscribe 实现Subscription
+func (s *contextSubscription) Unsubscribe() {
+	s.detach()
+	s.Subscription.Unsubscribe()
 }
 
 // GET 注册HTTP GET路由
@@ -77,6 +124,12 @@ func (a *quickContext) POST(path string, h echo.HandlerFunc, m ...echo.Middlewar
 	a.e.POST(path, h, m...)
 }
 
+// Route 注册HTTP路由并记录文档
+func (a *quickContext) Route(method, path string, h echo.HandlerFunc, doc RouteDoc, m ...echo.MiddlewareFunc) {
+	a.e.Add(method, path, h, m...)
+	a.routes.add(method, path, doc)
+}
+
 // Use 注册HTTP中间件
 // 详细说明参考echo的文档 https://echo.labstack.com/middleware/#root-level-after-router
 func (a *quickContext) Use(middlewares ...echo.MiddlewareFunc) {
@@ -86,10 +139,7 @@ func (a *quickContext) Use(middlewares ...echo.MiddlewareFunc) {
 // Schedule 注册定时任务
 func (a *quickContext) Schedule(expr string, job Job) {
 	fn := func() {
-		ctx := context.Background()
-		if err := job(ctx); err != nil {
-			a.Logf("[ERROR] Cron Job Execute Failed: %s", err.Error())
-		}
+		a.runJob(expr, job)
 	}
 	job0 := cron.NewChain(cron.DelayIfStillRunning(cron.PrintfLogger(a.logger))).Then((cron.FuncJob(fn)))
 
@@ -101,14 +151,123 @@ func (a *quickContext) Schedule(expr string, job Job) {
 	}
 }
 
+// runJob 执行定时任务，失败或panic时记录日志并上报警报
+func (a *quickContext) runJob(expr string, job Job) {
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicErr{Value: r, Stack: string(debug.Stack())}
+			}
+		}()
+		return job(context.Background())
+	}()
+	if err != nil {
+		a.Logf("[ERROR] Cron Job Execute Failed: %s", err.Error())
+		a.alarm.reportJob(expr, err)
+	}
+}
+
 // Publish 发布事件
-func (a *quickContext) Publish(topic string, payload string) {
-	a.pubsub.Publish(topic, payload)
+func (a *quickContext) Publish(topic string, payload string) error {
+	return a.pubsub.Publish(topic, payload)
+}
+
+// PublishTx 在事务tx中发布事件
+func (a *quickContext) PublishTx(tx *gorm.DB, topic string, payload string) error {
+	return PublishTx(tx, topic, payload)
 }
 
 // Subscribe 订阅事件
-func (a *quickContext) Subscribe(topic string, cb func(string)) {
-	a.pubsub.Subscribe(topic, cb)
+func (a *quickContext) Subscribe(topic string, cb func(string), opts ...SubscribeOption) (Subscription, error) {
+	return a.SubscribeE(topic, func(payload string) error {
+		cb(payload)
+		return nil
+	}, opts...)
+}
+
+// SubscribeE 订阅事件，cb失败时重试，重试用尽后写入死信
+func (a *quickContext) SubscribeE(topic string, cb func(string) error, opts ...SubscribeOption) (Subscription, error) {
+	return a.SubscribeTopicE(topic, func(_, payload string) error {
+		return cb(payload)
+	}, opts...)
+}
+
+// SubscribeTopicE 订阅事件，cb收到事件的实际主题，失败时重试，重试用尽后写入死信
+func (a *quickContext) SubscribeTopicE(topic string, cb func(topic, payload string) error, opts ...SubscribeOption) (Subscription, error) {
+	o := newSubscribeOptions(opts)
+
+	a.mu.Lock()
+	name := o.name
+	if name == "" {
+		name = fmt.Sprintf("%s#%d", topic, a.subCounts[topic])
+		a.subCounts[topic]++
+	}
+	h := &retryHandler{
+		name:    name,
+		cb:      cb,
+		retry:   o.retry,
+		backoff: o.backoff,
+		store:   a.deadLetters,
+		logf:    a.Logf,
+	}
+	a.subHandlers[name] = h
+	a.mu.Unlock()
+
+	sub, err := a.pubsub.SubscribeTopic(topic, h.handle, opts...)
+	if err != nil {
+		a.removeSubHandler(name, h)
+		return nil, err
+	}
+	return &contextSubscription{Subscription: sub, detach: func() { a.removeSubHandler(name, h) }}, nil
+}
+
+func (a *quickContext) removeSubHandler(name string, h *retryHandler) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.subHandlers[name] == h {
+		delete(a.subHandlers, name)
+	}
+}
+
+// DeadLetters 返回死信存储
+func (a *quickContext) DeadLetters() DeadLetterStore {
+	return a.deadLetters
+}
+
+// ReplayDeadLetter 重新处理死信
+func (a *quickContext) ReplayDeadLetter(ctx context.Context, id uint) error {
+	dl, err := a.deadLetters.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	a.mu.RLock()
+	h, ok := a.subHandlers[dl.Subscriber]
+	a.mu.RUnlock()
+	if ok {
+		err = h.call(dl.Topic, dl.Payload)
+	} else {
+		err = a.pubsub.Publish(dl.Topic, dl.Payload)
+	}
+	if err != nil {
+		return err
+	}
+	return a.deadLetters.MarkReplayed(ctx, id)
+}
+
+// PubSubStats 返回各订阅的统计信息
+func (a *quickContext) PubSubStats() []SubscriptionStats {
+	return a.pubsub.Stats()
+}
+
+// RegisterTask 注册任务处理方法
+func (a *quickContext) RegisterTask(name string, h TaskHandler) {
+	a.tasks.register(name, h)
+}
+
+// Enqueue 投递任务
+func (a *quickContext) Enqueue(ctx context.Context, name string, payload string, opts ...TaskOption) (string, error) {
+	return a.tasks.enqueue(ctx, name, payload, opts...)
 }
 
 // GetDB 获取数据库连接实例
@@ -136,7 +295,7 @@ func (a *quickContext) Take(id string) interface{} {
 }
 
 // RegisterShutdown 注册停止服务前调用的方法
-// 当服务停止时，会先停止HTTP服务、定时任务、事件系统，当这3者停止后，
+// 当服务停止时，会先停止HTTP服务、定时任务、事件系统、任务队列，当这4者停止后，
 // 调用通过ReigsterShutdown注册的方法
 func (a *quickContext) RegisterShutdown(hook OnShutdown) {
 	a.mu.Lock()
@@ -166,10 +325,20 @@ func (a *quickContext) migrate(migrators ...Migrator) {
 	}
 }
 
+// Done 返回服务开始停止时关闭的channel
+func (a *quickContext) Done() <-chan struct{} {
+	return a.done
+}
+
 // start 启动服务，并返回停止服务的方法
 // 内部会根据配置启动HTTP服务、定时任务服务
 func (a *quickContext) start() func() {
 	a.c.Start()
+	a.tasks.start()
+	if a.db != nil && a.db.Migrator().HasTable(OutboxMessage{}) {
+		a.outbox = newOutboxRelay(a.db, a.pubsub, a.config.Outbox, a.Logf)
+		a.outbox.start()
+	}
 	go func() {
 		if err := a.e.Start(a.config.APIAddr); err != nil && err != http.ErrServerClosed {
 			a.Logf("[ERROR] Echo Start Failed: %s", err.Error())
@@ -178,6 +347,7 @@ func (a *quickContext) start() func() {
 	}()
 
 	return func() {
+		close(a.done)
 		var wg sync.WaitGroup
 
 		wg.Add(1)
@@ -202,10 +372,20 @@ func (a *quickContext) start() func() {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			if a.outbox != nil {
+				a.outbox.close()
+			}
 			a.pubsub.Close()
 			a.Logf("[INFO] PubSub Stopped")
 		}()
 
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.tasks.close()
+			a.Logf("[INFO] TaskQueue Stopped")
+		}()
+
 		wg.Wait()
 		for _, hook := range a.shutdownHooks {
 			func() {
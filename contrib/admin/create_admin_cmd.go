@@ -4,12 +4,12 @@ import "github.com/rs/xid"
 
 // CreateAdminCmd 是添加管理员的命令
 type CreateAdminCmd struct {
-	Account    string `json:"account" validate:"nonzero"`
-	Password   string `json:"password" validate:"nonzero"`
-	Name       string `json:"name" validate:"nonzero"`
-	Mobile     string `json:"mobile" validate:"nonzero"`
-	Active     bool   `json:"active"`
-	RoleIDList []uint `json:"roleIdList"`
+	Account    string `json:"account" validate:"nonzero" title:"帐号"`
+	Password   string `json:"password" validate:"nonzero" title:"密码"`
+	Name       string `json:"name" validate:"nonzero" title:"姓名"`
+	Mobile     string `json:"mobile" validate:"nonzero" title:"手机号"`
+	Active     bool   `json:"active" title:"是否启用"`
+	RoleIDList []uint `json:"roleIdList" title:"角色ID列表"`
 }
 
 func (cmd CreateAdminCmd) toModel() (Admin, []AdminRole, error) {
@@ -0,0 +1,16 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/hiwjd/quick"
+)
+
+var (
+	// ErrAccountOrPassword 是登录时帐号或密码错误
+	ErrAccountOrPassword = quick.RegisterErrCode("admin.account_or_password", http.StatusBadRequest, "帐号或密码错误", map[string]string{"en": "Incorrect account or password"})
+	// ErrOriginPassword 是修改密码时原密码错误
+	ErrOriginPassword = quick.RegisterErrCode("admin.origin_password", http.StatusBadRequest, "原密码错误", map[string]string{"en": "Incorrect original password"})
+	// ErrPassword 是密码错误
+	ErrPassword = quick.RegisterErrCode("admin.password", http.StatusBadRequest, "密码错误", map[string]string{"en": "Incorrect password"})
+)
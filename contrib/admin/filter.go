@@ -13,6 +13,9 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 )
 
+// HeaderSessionToken 是换发的会话key的响应头，客户端收到后需要替换原key
+const HeaderSessionToken = "X-Session-Token"
+
 // FnCanAccessAPI 检查adminID是否有有个接口的访问权限
 type FnCanAccessAPI func(ctx context.Context, adminID uint, method, path string) bool
 
@@ -33,12 +36,12 @@ func AdminSessionCheck(storage session.Storage, fnCanAccessAPI FnCanAccessAPI, l
 			return true, nil
 		}
 
-		var session Session
-		if err := storage.Get(key, &session); err != nil {
+		var sess Session
+		if err := storage.Get(key, &sess); err != nil {
 			return false, echo.NewHTTPError(http.StatusUnauthorized).SetInternal(err)
 		}
 
-		if !fnCanAccessAPI(req.Context(), session.ID, method, uri) {
+		if !fnCanAccessAPI(req.Context(), sess.ID, method, uri) {
 			return false, echo.NewHTTPError(http.StatusUnauthorized)
 		}
 
@@ -46,12 +49,26 @@ func AdminSessionCheck(storage session.Storage, fnCanAccessAPI FnCanAccessAPI, l
 		// ctx := dataperm.SetAppliers(req.Context(), appliers)
 		// c.SetRequest(req.WithContext(ctx))
 
-		if !session.Remember {
+		if !sess.Remember {
 			if er := storage.RefreshTTL(key, 30*time.Minute); er != nil {
 				logf("[ERROR] 刷新会话存活时间失败: %s", er.Error())
 			}
+			// key中包含过期时间的会话通过换发延长，新key在响应头中返回
+			if r, ok := storage.(session.Reissuer); ok {
+				if newKey, er := r.Reissue(key, 30*time.Minute); er != nil {
+					logf("[ERROR] 换发会话失败: %s", er.Error())
+				} else if newKey != key {
+					c.Response().Header().Set(HeaderSessionToken, newKey)
+				}
+			}
+		}
+		if us, ok := storage.(session.UserStorage); ok {
+			if er := us.Touch(key); er != nil {
+				logf("[ERROR] 记录会话活跃时间失败: %s", er.Error())
+			}
 		}
-		c.Set(AdminSessionID, session)
+		c.Set(AdminSessionID, sess)
+		c.Set(AdminSessionKey, key)
 
 		return true, nil
 	}
@@ -1,6 +1,9 @@
 package admin
 
-import "gorm.io/gorm"
+import (
+	"github.com/hiwjd/quick"
+	"gorm.io/gorm"
+)
 
 func Migrate(db *gorm.DB) error {
 	return db.AutoMigrate(
@@ -11,5 +14,6 @@ func Migrate(db *gorm.DB) error {
 		Menu{},
 		API{},
 		WxAdmin{},
+		quick.OutboxMessage{},
 	)
 }
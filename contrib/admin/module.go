@@ -1,17 +1,22 @@
 package admin
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/hiwjd/quick"
+	"github.com/hiwjd/quick/support"
 	"github.com/hiwjd/quick/support/session"
 	"github.com/hiwjd/quick/util"
 	"github.com/labstack/echo/v4"
 )
 
-const AdminSessionID = "AdminSessionID"
+const (
+	AdminSessionID  = "AdminSessionID"  // 会话信息Session在echo.Context中的键
+	AdminSessionKey = "AdminSessionKey" // 会话key在echo.Context中的键
+)
 
 // Session 是登录信息
 type Session struct {
@@ -31,32 +36,104 @@ func AdminModule(ac quick.Context) {
 	}
 
 	ct := &ctrl{
+		ac:                  ac,
 		adminService:        adminService,
 		adminSessionStorage: adminSessionStorage,
 	}
 	ac.Use(AdminSessionCheck(adminSessionStorage, adminService.CanAccessAPI, ac.Logf))
-	ac.POST("/pub/admin/login", ct.adminLogin)                        // 后台 - 登录
-	ac.POST("/ana/admin/logout", ct.adminLogout)                      // 后台 - 登出
-	ac.POST("/ana/admin/update-my-pass", ct.adminUpdateMyPassword)    // 后台 - 修改自己的密码
-	ac.GET("/ana/admin/menu", ct.queryAdminMenu)                      // 后台 - 当前登录管理员的菜单
-	ac.GET("/ana/admin/query-admin-page", ct.queryAdminPage)          // 后台 - 管理员分页列表
-	ac.GET("/ana/admin/get-by-id", ct.getAdminByID)                   // 后台 - 根据ID查询管理员
-	ac.GET("/ana/admin/get-by-account", ct.getAdminByAccount)         // 后台 - 根据帐号查询管理员
-	ac.POST("/ana/admin/create", ct.createAdmin)                      // 后台 - 创建管理员
-	ac.POST("/ana/admin/update", ct.updateAdmin)                      // 后台 - 更新管理员
-	ac.POST("/ana/admin/update-password", ct.updateAdminPassword)     // 后台 - 更新管理员密码
-	ac.GET("/ana/admin/query-role-list", ct.queryRoleList)            // 后台 - 角色列表
-	ac.GET("/ana/admin/query-admin-role-list", ct.queryAdminRoleList) // 后台 - 管理员的角色列表
+	tags := []string{"admin"}
+	ac.Route(http.MethodPost, "/pub/admin/login", ct.adminLogin, quick.RouteDoc{Summary: "登录", Tags: tags, Request: AdminLoginReq{}, Response: AdminLoginResp{}})
+	ac.Route(http.MethodPost, "/pub/admin/refresh-token", ct.refreshToken, quick.RouteDoc{Summary: "用刷新令牌换发会话令牌", Description: "会话存储支持刷新令牌时可用，比如配置了Refresh的session.JWTStorage", Tags: tags, Request: RefreshTokenReq{}, Response: AdminLoginResp{}})
+	if j, ok := adminSessionStorage.(interface{ JWKSHandler() http.Handler }); ok {
+		ac.Route(http.MethodGet, "/pub/admin/jwks.json", echo.WrapHandler(j.JWKSHandler()), quick.RouteDoc{Summary: "验证会话令牌的公钥", Tags: tags, Response: session.JWKSet{}})
+	}
+	ac.Route(http.MethodPost, "/ana/admin/logout", ct.adminLogout, quick.RouteDoc{Summary: "登出", Tags: tags})
+	ac.Route(http.MethodPost, "/ana/admin/update-my-pass", ct.adminUpdateMyPassword, quick.RouteDoc{Summary: "修改自己的密码", Tags: tags, Request: UpdateAdminPasswordCmd{}, Response: MessageResp{}})
+	ac.Route(http.MethodGet, "/ana/admin/menu", ct.queryAdminMenu, quick.RouteDoc{Summary: "当前登录管理员的菜单", Tags: tags, Response: AdminMenuResp{}})
+	ac.Route(http.MethodGet, "/ana/admin/query-admin-page", ct.queryAdminPage, quick.RouteDoc{Summary: "管理员分页列表", Tags: tags, Request: QueryAdminPageCmd{}, Response: AdminPageResp{}})
+	ac.Route(http.MethodGet, "/ana/admin/get-by-id", ct.getAdminByID, quick.RouteDoc{Summary: "根据ID查询管理员", Tags: tags, Request: AdminIDQuery{}, Response: Admin{}})
+	ac.Route(http.MethodGet, "/ana/admin/get-by-account", ct.getAdminByAccount, quick.RouteDoc{Summary: "根据帐号查询管理员", Tags: tags, Request: AdminAccountQuery{}, Response: Admin{}})
+	ac.Route(http.MethodPost, "/ana/admin/create", ct.createAdmin, quick.RouteDoc{Summary: "创建管理员", Tags: tags, Request: CreateAdminCmd{}, Response: Admin{}})
+	ac.Route(http.MethodPost, "/ana/admin/update", ct.updateAdmin, quick.RouteDoc{Summary: "更新管理员", Tags: tags, Request: UpdateAdminCmd{}, Response: MessageResp{}})
+	ac.Route(http.MethodPost, "/ana/admin/update-password", ct.updateAdminPassword, quick.RouteDoc{Summary: "更新管理员密码", Tags: tags, Request: UpdateAdminPasswordCmd{}, Response: MessageResp{}})
+	ac.Route(http.MethodGet, "/ana/admin/query-role-list", ct.queryRoleList, quick.RouteDoc{Summary: "角色列表", Tags: tags, Request: QueryRoleListCmd{}, Response: []Role{}})
+	ac.Route(http.MethodGet, "/ana/admin/query-admin-role-list", ct.queryAdminRoleList, quick.RouteDoc{Summary: "管理员的角色ID列表", Tags: tags, Request: AdminIDQuery{}, Response: []uint{}})
+	ac.Route(http.MethodGet, "/ana/admin/query-dead-letter-page", ct.queryDeadLetterPage, quick.RouteDoc{Summary: "事件死信分页列表", Tags: tags, Request: quick.DeadLetterQuery{}, Response: DeadLetterPageResp{}})
+	ac.Route(http.MethodGet, "/ana/admin/query-session-list", ct.querySessionList, quick.RouteDoc{Summary: "管理员的会话列表", Tags: tags, Request: AdminIDQuery{}, Response: []session.Info{}})
+	ac.Route(http.MethodPost, "/ana/admin/revoke-session", ct.revokeSession, quick.RouteDoc{Summary: "踢下线管理员的会话", Tags: tags, Request: RevokeSessionReq{}, Response: MessageResp{}})
+	ac.Route(http.MethodPost, "/ana/admin/replay-dead-letter", ct.replayDeadLetter, quick.RouteDoc{Summary: "重新处理事件死信", Tags: tags, Request: ReplayDeadLetterReq{}, Response: MessageResp{}})
 }
 
 // AdminLoginReq 是管理员登录请求
 type AdminLoginReq struct {
-	Account  string `json:"account" validate:"nonzero"`
-	Password string `json:"password" validate:"nonzero"`
-	Remember bool   `json:"remember"`
+	Account  string `json:"account" validate:"nonzero" title:"帐号"`
+	Password string `json:"password" validate:"nonzero" title:"密码"`
+	Remember bool   `json:"remember" title:"记住登录，为true时会话不过期"`
+}
+
+// AdminLoginResp 是管理员登录响应
+type AdminLoginResp struct {
+	Token        string      `json:"token" title:"会话令牌，通过Authorization: Bearer传递"`
+	RefreshToken string      `json:"refreshToken,omitempty" title:"刷新令牌，会话存储支持时才有"`
+	Data         interface{} `json:"data"`
+}
+
+// RefreshTokenReq 是换发会话令牌的请求
+type RefreshTokenReq struct {
+	RefreshToken string `json:"refreshToken" validate:"nonzero" title:"刷新令牌"`
+}
+
+// refresher 是支持刷新令牌的会话存储，比如session.JWTStorage
+type refresher interface {
+	SetWithRefresh(session interface{}, ttl time.Duration) (key, refreshToken string, err error)
+	Refresh(refreshToken string, session interface{}, ttl func() time.Duration) (key, newRefreshToken string, err error)
+}
+
+// ReplayDeadLetterReq 是重新处理死信请求
+type ReplayDeadLetterReq struct {
+	ID uint `json:"id" validate:"nonzero" title:"死信ID"`
+}
+
+// RevokeSessionReq 是踢下线管理员会话的请求
+type RevokeSessionReq struct {
+	ID        uint   `json:"id" validate:"nonzero" title:"管理员ID"`
+	SessionID string `json:"sessionId" title:"会话ID，即会话列表中的id，为空时踢下线所有会话"`
+}
+
+// AdminIDQuery 是按管理员ID查询的参数
+type AdminIDQuery struct {
+	ID uint `query:"id" validate:"nonzero" title:"管理员ID"`
+}
+
+// AdminAccountQuery 是按帐号查询的参数
+type AdminAccountQuery struct {
+	Account string `query:"account" validate:"nonzero" title:"帐号"`
+}
+
+// MessageResp 是只有提示的响应
+type MessageResp struct {
+	Message string `json:"message"`
+}
+
+// AdminMenuResp 是菜单响应
+type AdminMenuResp struct {
+	Menu MenuNode `json:"menu"`
+}
+
+// AdminPageResp 是管理员分页列表响应
+type AdminPageResp struct {
+	Data []Admin      `json:"data"`
+	Pg   support.Page `json:"pg"`
+}
+
+// DeadLetterPageResp 是事件死信分页列表响应
+type DeadLetterPageResp struct {
+	Data []quick.DeadLetter `json:"data"`
+	Pg   support.Page       `json:"pg"`
 }
 
 type ctrl struct {
+	ac                  quick.Context
 	adminService        Service
 	adminSessionStorage session.Storage
 }
@@ -64,43 +141,84 @@ type ctrl struct {
 func (ct *ctrl) adminLogin(c echo.Context) (err error) {
 	ctx := c.Request().Context()
 	var req AdminLoginReq
-	if err = c.Bind(&req); err != nil {
-		return
-	}
-	if err = c.Validate(&req); err != nil {
+	if err = quick.BindAndValidate(c, &req); err != nil {
 		return
 	}
 
 	var admin Admin
 	if admin, err = ct.adminService.GetAdminByAccount(ctx, req.Account); err != nil {
-		err = echo.NewHTTPError(http.StatusBadRequest, "帐号或密码错误").SetInternal(err)
+		err = ErrAccountOrPassword.Wrap(err)
 		return
 	}
 
 	if !admin.CheckPassword(req.Password) {
-		err = echo.NewHTTPError(http.StatusBadRequest, "帐号或密码错误")
+		err = ErrAccountOrPassword
 		return
 	}
 
-	ttl := 30 * time.Minute
-	if req.Remember {
-		ttl = 0
-	}
-
-	session := Session{
+	sess := Session{
 		ID:       admin.ID,
 		Name:     admin.Name,
 		Mobile:   admin.Mobile,
 		Remember: req.Remember,
 	}
 
-	token, err := ct.adminSessionStorage.Set(&session, ttl)
-	if err != nil {
+	resp := AdminLoginResp{}
+	if resp.Token, resp.RefreshToken, err = ct.issueSession(c, &sess, sessionTTL(sess.Remember)); err != nil {
 		err = echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
 		return
 	}
 
-	return c.JSON(http.StatusOK, util.Map{"token": token, "data": nil})
+	return c.JSON(http.StatusOK, resp)
+}
+
+// sessionTTL 返回会话的有效期，记住登录时会话不过期
+func sessionTTL(remember bool) time.Duration {
+	if remember {
+		return 0
+	}
+	return 30 * time.Minute
+}
+
+// issueSession 存储会话，会话存储支持时同时签发刷新令牌或者按管理员索引会话
+func (ct *ctrl) issueSession(c echo.Context, sess *Session, ttl time.Duration) (token, refreshToken string, err error) {
+	if r, ok := ct.adminSessionStorage.(refresher); ok {
+		token, refreshToken, err = r.SetWithRefresh(sess, ttl)
+		if !errors.Is(err, session.ErrRefreshDisabled) {
+			return
+		}
+	}
+	if us, ok := ct.adminSessionStorage.(session.UserStorage); ok {
+		client := session.Client{Device: c.Request().UserAgent(), IP: c.RealIP()}
+		token, err = us.SetFor(adminSubject(sess.ID), sess, ttl, client)
+		return
+	}
+	token, err = ct.adminSessionStorage.Set(sess, ttl)
+	return
+}
+
+func (ct *ctrl) refreshToken(c echo.Context) (err error) {
+	var req RefreshTokenReq
+	if err = quick.BindAndValidate(c, &req); err != nil {
+		return
+	}
+
+	r, ok := ct.adminSessionStorage.(refresher)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "会话存储不支持刷新令牌")
+	}
+
+	var sess Session
+	ttl := func() time.Duration { return sessionTTL(sess.Remember) }
+	resp := AdminLoginResp{}
+	if resp.Token, resp.RefreshToken, err = r.Refresh(req.RefreshToken, &sess, ttl); err != nil {
+		if errors.Is(err, session.ErrRefreshDisabled) {
+			return echo.NewHTTPError(http.StatusNotImplemented, "会话存储不支持刷新令牌").SetInternal(err)
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized).SetInternal(err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
 }
 
 func (ct *ctrl) queryAdminMenu(c echo.Context) (err error) {
@@ -165,10 +283,7 @@ func (ct *ctrl) getAdminByAccount(c echo.Context) (err error) {
 func (ct *ctrl) createAdmin(c echo.Context) (err error) {
 	ctx := c.Request().Context()
 	var cmd CreateAdminCmd
-	if err = c.Bind(&cmd); err != nil {
-		return
-	}
-	if err = c.Validate(&cmd); err != nil {
+	if err = quick.BindAndValidate(c, &cmd); err != nil {
 		return
 	}
 
@@ -190,6 +305,7 @@ func (ct *ctrl) updateAdmin(c echo.Context) (err error) {
 	if err = ct.adminService.UpdateAdmin(ctx, cmd); err != nil {
 		return
 	}
+	ct.updateAdminSessions(cmd.ID, cmd.Name, cmd.Mobile)
 
 	return c.JSON(http.StatusOK, util.Map{"message": "更新成功"})
 }
@@ -225,16 +341,14 @@ func (ct *ctrl) queryAdminRoleList(c echo.Context) (err error) {
 func (ct *ctrl) updateAdminPassword(c echo.Context) (err error) {
 	ctx := c.Request().Context()
 	var cmd UpdateAdminPasswordCmd
-	if err = c.Bind(&cmd); err != nil {
-		return
-	}
-	if err = c.Validate(&cmd); err != nil {
+	if err = quick.BindAndValidate(c, &cmd); err != nil {
 		return
 	}
 
 	if err = ct.adminService.UpdateAdminPassword(ctx, cmd); err != nil {
 		return
 	}
+	ct.revokeAdminSessions(c, cmd.ID)
 
 	return c.JSON(http.StatusOK, util.Map{"message": "修改成功"})
 }
@@ -265,6 +379,139 @@ func (ct *ctrl) adminUpdateMyPassword(c echo.Context) (err error) {
 	if err = ct.adminService.UpdateAdminPassword(ctx, cmd); err != nil {
 		return
 	}
+	// 保留当前会话
+	ct.revokeAdminSessions(c, cmd.ID, c.Get(AdminSessionKey).(string))
 
 	return c.JSON(http.StatusOK, util.Map{"message": "修改成功"})
 }
+
+func (ct *ctrl) queryDeadLetterPage(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	q := quick.DeadLetterQuery{
+		Page: 1,
+		Size: 20,
+	}
+	if err = c.Bind(&q); err != nil {
+		return
+	}
+
+	rows, count, err := ct.ac.DeadLetters().Query(ctx, q)
+	if err != nil {
+		return
+	}
+
+	pg := support.Page{Page: q.Page, Size: q.Size, Count: count}
+	return c.JSON(http.StatusOK, util.Map{"data": rows, "pg": pg})
+}
+
+func (ct *ctrl) replayDeadLetter(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var req ReplayDeadLetterReq
+	if err = quick.BindAndValidate(c, &req); err != nil {
+		return
+	}
+
+	if err = ct.ac.ReplayDeadLetter(ctx, req.ID); err != nil {
+		if errors.Is(err, quick.ErrDeadLetterNotFound) {
+			err = echo.NewHTTPError(http.StatusNotFound, "死信不存在").SetInternal(err)
+		}
+		return
+	}
+
+	return c.JSON(http.StatusOK, util.Map{"message": "处理成功"})
+}
+
+// adminSubject 是管理员在UserStorage中的subject
+func adminSubject(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// userStorage 返回支持按管理员管理会话的存储，不支持时返回501
+func (ct *ctrl) userStorage() (session.UserStorage, error) {
+	us, ok := ct.adminSessionStorage.(session.UserStorage)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusNotImplemented, "会话存储不支持按管理员管理")
+	}
+	return us, nil
+}
+
+// revokeAdminSessions 在修改密码后踢下线管理员except外的会话，失败只记录日志
+func (ct *ctrl) revokeAdminSessions(c echo.Context, adminID uint, except ...string) {
+	us, ok := ct.adminSessionStorage.(session.UserStorage)
+	if !ok {
+		return
+	}
+	if err := us.RevokeAll(adminSubject(adminID), except...); err != nil {
+		ct.ac.Logf("[ERROR] 踢下线管理员会话失败: %s, adminID=%d", err.Error(), adminID)
+	}
+}
+
+// updateAdminSessions 在修改管理员后更新其会话中的姓名和手机号，会话存储不支持时忽略，失败只记录日志
+func (ct *ctrl) updateAdminSessions(adminID uint, name, mobile string) {
+	us, ok := ct.adminSessionStorage.(session.UserStorage)
+	if !ok {
+		return
+	}
+	updater, ok := ct.adminSessionStorage.(session.Updater)
+	if !ok {
+		return
+	}
+
+	infos, err := us.List(adminSubject(adminID))
+	if err != nil {
+		ct.ac.Logf("[ERROR] 查询管理员会话失败: %s, adminID=%d", err.Error(), adminID)
+		return
+	}
+	for _, info := range infos {
+		var sess Session
+		if err := us.Get(info.Key, &sess); err != nil {
+			continue
+		}
+		sess.Name, sess.Mobile = name, mobile
+		if err := updater.Update(info.Key, &sess); err != nil {
+			ct.ac.Logf("[ERROR] 更新管理员会话失败: %s, adminID=%d", err.Error(), adminID)
+		}
+	}
+}
+
+func (ct *ctrl) querySessionList(c echo.Context) (err error) {
+	var q AdminIDQuery
+	if err = quick.BindAndValidate(c, &q); err != nil {
+		return
+	}
+
+	us, err := ct.userStorage()
+	if err != nil {
+		return
+	}
+
+	infos, err := us.List(adminSubject(q.ID))
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, infos)
+}
+
+func (ct *ctrl) revokeSession(c echo.Context) (err error) {
+	var req RevokeSessionReq
+	if err = quick.BindAndValidate(c, &req); err != nil {
+		return
+	}
+
+	us, err := ct.userStorage()
+	if err != nil {
+		return
+	}
+
+	if req.SessionID == "" {
+		err = us.RevokeAll(adminSubject(req.ID))
+	} else if err = us.Revoke(adminSubject(req.ID), req.SessionID); errors.Is(err, session.ErrSessionNotFound) {
+		err = echo.NewHTTPError(http.StatusNotFound, "会话不存在").SetInternal(err)
+	}
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, util.Map{"message": "操作成功"})
+}
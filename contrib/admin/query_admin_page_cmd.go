@@ -4,12 +4,12 @@ import "gorm.io/gorm"
 
 // QueryAdminPageCmd 是查询管理员分页列表的命令
 type QueryAdminPageCmd struct {
-	Page    int    `query:"page"`
-	Size    int    `query:"size"`
-	Account string `query:"account"`
-	Name    string `query:"name"`
-	Mobile  string `query:"mobile"`
-	Active  *bool  `query:"active"`
+	Page    int    `query:"page" title:"页码，默认1"`
+	Size    int    `query:"size" title:"每页条数，默认20"`
+	Account string `query:"account" title:"帐号"`
+	Name    string `query:"name" title:"姓名"`
+	Mobile  string `query:"mobile" title:"手机号"`
+	Active  *bool  `query:"active" title:"是否启用"`
 }
 
 func (cmd QueryAdminPageCmd) offset() int {
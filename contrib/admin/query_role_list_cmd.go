@@ -4,8 +4,8 @@ import "gorm.io/gorm"
 
 // QueryRoleListCmd 查询角色列表的命令
 type QueryRoleListCmd struct {
-	Group string `query:"group"`
-	Key   string `query:"key"`
+	Group string `query:"group" title:"所属组"`
+	Key   string `query:"key" title:"角色特性"`
 }
 
 func (cmd QueryRoleListCmd) applyCondition(db *gorm.DB) *gorm.DB {
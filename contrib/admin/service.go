@@ -2,13 +2,15 @@ package admin
 
 import (
 	"context"
-	"net/http"
 
 	"github.com/hiwjd/quick"
 	"github.com/hiwjd/quick/support"
 	"gorm.io/gorm"
 )
 
+// TopicAdminCreated 是管理员创建成功的事件，数据是Admin
+const TopicAdminCreated = "admin.created"
+
 // Service 是管理员服务
 type Service interface {
 	QueryAdminPage(context.Context, QueryAdminPageCmd) ([]Admin, support.Page, error)                                 // 查询管理员分页列表
@@ -90,7 +92,7 @@ func (s *service) CreateAdmin(ctx context.Context, cmd CreateAdminCmd) (admin Ad
 			}
 		}
 
-		return
+		return quick.PublishEventTx(ctx, tx, TopicAdminCreated, admin, quick.EventSource("admin"))
 	})
 
 	return
@@ -189,7 +191,7 @@ func (s *service) UpdateAdminPassword(ctx context.Context, cmd UpdateAdminPasswo
 	}
 
 	if !adm.CheckPassword(cmd.Origin) {
-		err = quick.NewFineErr(http.StatusBadRequest, "原密码错误")
+		err = ErrOriginPassword
 		return
 	}
 
@@ -214,7 +216,7 @@ func (s *service) BindMiniapp(ctx context.Context, code, openid, password string
 	}
 
 	if !admin.CheckPassword(password) {
-		err = quick.NewFineErr(http.StatusBadRequest, "密码错误")
+		err = ErrPassword
 		return
 	}
 
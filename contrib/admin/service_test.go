@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/hiwjd/quick"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -13,7 +14,7 @@ func TestAdmin(t *testing.T) {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	assert.Nil(t, err)
 
-	db.AutoMigrate(Admin{}, Role{}, AdminRole{}, Menu{}, API{})
+	db.AutoMigrate(Admin{}, Role{}, AdminRole{}, Menu{}, API{}, quick.OutboxMessage{})
 
 	service := NewService(db)
 	ctx := context.Background()
@@ -36,4 +37,16 @@ func TestAdmin(t *testing.T) {
 	roleIDList, err := service.QueryRoleIDListByAdminID(ctx, admin.ID)
 	assert.Nil(t, err)
 	assert.Equal(t, cmd.RoleIDList, roleIDList)
+
+	var msgs []quick.OutboxMessage
+	assert.Nil(t, db.Find(&msgs).Error)
+	if assert.Equal(t, 1, len(msgs)) {
+		assert.Equal(t, TopicAdminCreated, msgs[0].Topic)
+		env := quick.ParseEnvelope(msgs[0].Topic, msgs[0].Payload)
+		assert.Equal(t, "admin", env.Source)
+		var created Admin
+		assert.Nil(t, env.Decode(&created))
+		assert.Equal(t, admin.ID, created.ID)
+		assert.Equal(t, cmd.Account, created.Account)
+	}
 }
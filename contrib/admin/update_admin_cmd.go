@@ -2,12 +2,12 @@ package admin
 
 // UpdateAdminCmd 是修改管理员的命令
 type UpdateAdminCmd struct {
-	ID         uint   `json:"id" validate:"nonzero"`
-	Account    string `json:"account" validate:"nonzero"`
-	Name       string `json:"name" validate:"nonzero"`
-	Mobile     string `json:"mobile" validate:"nonzero"`
-	Active     bool   `json:"active"`
-	RoleIDList []uint `json:"roleIdList"`
+	ID         uint   `json:"id" validate:"nonzero" title:"管理员ID"`
+	Account    string `json:"account" validate:"nonzero" title:"帐号"`
+	Name       string `json:"name" validate:"nonzero" title:"姓名"`
+	Mobile     string `json:"mobile" validate:"nonzero" title:"手机号"`
+	Active     bool   `json:"active" title:"是否启用"`
+	RoleIDList []uint `json:"roleIdList" title:"角色ID列表"`
 }
 
 func (cmd *UpdateAdminCmd) toModel() (Admin, []AdminRole, error) {
@@ -2,7 +2,7 @@ package admin
 
 // UpdateAdminPasswordCmd 是修改管理员密码的命令
 type UpdateAdminPasswordCmd struct {
-	ID       uint   `json:"id" validate:"nonzero"`
-	Origin   string `json:"origin" vaidate:"nonzero"`
-	Password string `json:"password" validate:"nonzero"`
+	ID       uint   `json:"id" validate:"nonzero" title:"管理员ID"`
+	Origin   string `json:"origin" validate:"nonzero" title:"原密码"`
+	Password string `json:"password" validate:"nonzero,nefield=Origin" title:"新密码"`
 }
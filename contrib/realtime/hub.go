@@ -0,0 +1,203 @@
+package realtime
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hiwjd/quick"
+	"github.com/rs/xid"
+)
+
+// IdleTimeout 是主题最后一个客户端断开后保持订阅的时间，期间重连的客户端仍可以补发事件
+const IdleTimeout = time.Minute
+
+// Event 是推送给客户端的事件
+type Event struct {
+	ID    uint64 `json:"id"`    // 进程内递增的序号，重连时通过Last-Event-ID补发之后的事件
+	Topic string `json:"topic"` // 主题，通过PublishEvent发布的事件是实际主题，否则是订阅的主题
+	Data  string `json:"data"`  // 事件的payload
+}
+
+type subscribeFunc func(topic string, cb func(string), opts ...quick.SubscribeOption) (quick.Subscription, error)
+
+// hub 按主题订阅PubSub，把事件分发给连接的客户端，并保留每个主题最近的事件用于重连补发
+// 订阅者名包含实例ID，PubSub使用stream模式时每个实例也都能收到所有事件，
+// 同一实例重新订阅时使用同一个消费者组，不会不断产生新的消费者组
+type hub struct {
+	mu        sync.Mutex
+	subscribe subscribeFunc
+	instance  string        // 实例ID
+	size      int           // 每个主题保留的事件数
+	idle      time.Duration // 没有客户端后保持订阅的时间
+	seq       uint64
+	topics    map[string]*topicState
+	releasing map[string]chan struct{} // 正在取消订阅的主题，取消完成后关闭
+}
+
+type topicState struct {
+	sub     quick.Subscription
+	events  []Event // 环形缓冲区
+	next    int
+	clients map[*client]struct{}
+	idle    *time.Timer // 没有客户端时到期取消订阅
+}
+
+// client 是一个连接，缓冲区满时被踢掉，由客户端重连后补发
+type client struct {
+	ch       chan Event
+	kicked   chan struct{}
+	kickOnce sync.Once
+}
+
+func newHub(subscribe subscribeFunc, size int) *hub {
+	return &hub{
+		subscribe: subscribe,
+		instance:  xid.New().String(),
+		size:      size,
+		idle:      IdleTimeout,
+		topics:    make(map[string]*topicState),
+		releasing: make(map[string]chan struct{}),
+	}
+}
+
+// attach 注册客户端，返回ID大于lastID的缓存事件
+// 在同一把锁内完成补发和注册，因此补发和实时推送之间不会丢失事件
+// 主题正在取消订阅时先等待取消完成，同名的订阅才能重新创建
+func (h *hub) attach(topics []string, lastID uint64, buffer int) (*client, []Event, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for done := h.releasingOf(topics); done != nil; done = h.releasingOf(topics) {
+		h.mu.Unlock()
+		<-done
+		h.mu.Lock()
+	}
+
+	c := &client{
+		ch:     make(chan Event, buffer),
+		kicked: make(chan struct{}),
+	}
+	var replay []Event
+	for _, topic := range topics {
+		ts, err := h.topic(topic)
+		if err != nil {
+			h.detachLocked(c)
+			return nil, nil, err
+		}
+		ts.clients[c] = struct{}{}
+		if ts.idle != nil {
+			ts.idle.Stop()
+			ts.idle = nil
+		}
+		if lastID == 0 || lastID > h.seq {
+			continue
+		}
+		for _, ev := range ts.events {
+			if ev.ID > lastID {
+				replay = append(replay, ev)
+			}
+		}
+	}
+	sort.Slice(replay, func(i, j int) bool { return replay[i].ID < replay[j].ID })
+	return c, replay, nil
+}
+
+// releasingOf 返回topics中正在取消订阅的主题的完成通知，调用时需要持有锁
+func (h *hub) releasingOf(topics []string) chan struct{} {
+	for _, topic := range topics {
+		if done, ok := h.releasing[topic]; ok {
+			return done
+		}
+	}
+	return nil
+}
+
+// topic 返回主题的状态，第一次使用时订阅PubSub，最后一个客户端断开IdleTimeout后取消订阅
+func (h *hub) topic(topic string) (*topicState, error) {
+	if ts, ok := h.topics[topic]; ok {
+		return ts, nil
+	}
+	ts := &topicState{clients: make(map[*client]struct{})}
+	sub, err := h.subscribe(topic, func(payload string) {
+		h.publish(topic, payload)
+	}, quick.WithName("realtime:"+topic+"#"+h.instance), quick.WithOverflow(quick.OverflowDropOldest), quick.WithBuffer(h.size))
+	if err != nil {
+		return nil, err
+	}
+	ts.sub = sub
+	h.topics[topic] = ts
+	return ts, nil
+}
+
+func (h *hub) publish(pattern string, payload string) {
+	topic := quick.ParseEnvelope(pattern, payload).Topic
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ts, ok := h.topics[pattern]
+	if !ok {
+		return
+	}
+	h.seq++
+	ev := Event{ID: h.seq, Topic: topic, Data: payload}
+	if len(ts.events) < h.size {
+		ts.events = append(ts.events, ev)
+	} else {
+		ts.events[ts.next] = ev
+		ts.next = (ts.next + 1) % h.size
+	}
+	for c := range ts.clients {
+		select {
+		case c.ch <- ev:
+		default:
+			c.kick()
+		}
+	}
+}
+
+func (h *hub) detach(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.detachLocked(c)
+}
+
+func (h *hub) detachLocked(c *client) {
+	for topic, ts := range h.topics {
+		if _, ok := ts.clients[c]; !ok {
+			continue
+		}
+		delete(ts.clients, c)
+		if len(ts.clients) == 0 && ts.idle == nil {
+			topic, ts := topic, ts
+			ts.idle = time.AfterFunc(h.idle, func() { h.release(topic, ts) })
+		}
+	}
+}
+
+// release 取消没有客户端的主题的订阅
+// 取消订阅会等待回调结束，而回调需要获取锁，因此在锁外取消订阅
+func (h *hub) release(topic string, ts *topicState) {
+	h.mu.Lock()
+	if h.topics[topic] != ts || len(ts.clients) > 0 {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.topics, topic)
+	done := make(chan struct{})
+	h.releasing[topic] = done
+	h.mu.Unlock()
+
+	ts.sub.Unsubscribe()
+
+	h.mu.Lock()
+	delete(h.releasing, topic)
+	h.mu.Unlock()
+	close(done)
+}
+
+func (c *client) kick() {
+	c.kickOnce.Do(func() {
+		close(c.kicked)
+	})
+}
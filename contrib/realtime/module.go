@@ -0,0 +1,275 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hiwjd/quick"
+	"github.com/hiwjd/quick/contrib/admin"
+	"github.com/hiwjd/quick/support/session"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// MethodSubscribe 是订阅权限的接口方式，管理员需要拥有Method为SUBSCRIBE、Path为主题的接口权限
+	MethodSubscribe = "SUBSCRIBE"
+	// BufferSize 是每个主题保留的用于重连补发的事件数
+	BufferSize = 100
+	// Heartbeat 是心跳间隔
+	Heartbeat = 15 * time.Second
+)
+
+// RealtimeModule 提供SSE和WebSocket接口，把PubSub的事件实时推送给管理后台
+// 依赖admin模块提供的adminSessionStorage和adminService
+func RealtimeModule(ac quick.Context) {
+	storage, ok := ac.Take("adminSessionStorage").(session.Storage)
+	if !ok {
+		panic("Missing Dependency session.Storage#adminSessionStorage")
+	}
+	adminService, ok := ac.Take("adminService").(admin.Service)
+	if !ok {
+		panic("Missing Dependency admin.Service#adminService")
+	}
+
+	ct := newCtrl(ac, storage, adminService.CanAccessAPI)
+	if client := ac.GetRedis(); client != nil {
+		ct.tickets = &redisTicketStore{client: client}
+	}
+	ac.POST("/ana/realtime/ticket", ct.ticket) // 实时推送 - 签发连接票据
+	ac.GET("/ana/realtime/sse", ct.sse)        // 实时推送 - SSE
+	ac.GET("/ana/realtime/ws", ct.ws)          // 实时推送 - WebSocket
+}
+
+type ctrl struct {
+	hub       *hub
+	storage   session.Storage
+	tickets   ticketStore
+	canAccess admin.FnCanAccessAPI
+	heartbeat time.Duration
+	done      <-chan struct{}
+	logf      quick.Logf
+}
+
+func newCtrl(ac quick.Context, storage session.Storage, canAccess admin.FnCanAccessAPI) *ctrl {
+	return &ctrl{
+		hub:       newHub(ac.Subscribe, BufferSize),
+		storage:   storage,
+		tickets:   newMemoryTicketStore(),
+		canAccess: canAccess,
+		heartbeat: Heartbeat,
+		done:      ac.Done(),
+		logf:      ac.Logf,
+	}
+}
+
+// authenticate 校验会话token，返回管理员ID
+func (ct *ctrl) authenticate(token string) (uint, error) {
+	if token == "" {
+		return 0, echo.NewHTTPError(http.StatusUnauthorized)
+	}
+
+	var sess admin.Session
+	if err := ct.storage.Get(token, &sess); err != nil {
+		return 0, echo.NewHTTPError(http.StatusUnauthorized).SetInternal(err)
+	}
+	return sess.ID, nil
+}
+
+// headerToken 返回Authorization请求头中的会话token
+func headerToken(c echo.Context) string {
+	return strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+}
+
+// ticket 为当前会话签发连接票据
+func (ct *ctrl) ticket(c echo.Context) error {
+	token := headerToken(c)
+	if _, err := ct.authenticate(token); err != nil {
+		return err
+	}
+
+	ticket, err := ct.tickets.Issue(token, TicketTTL)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, TicketResp{Ticket: ticket, ExpiresIn: int(TicketTTL / time.Second)})
+}
+
+// TicketResp 是签发连接票据的响应
+type TicketResp struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expiresIn"` // 有效期，单位秒
+}
+
+// authorize 校验会话和每个主题的订阅权限，返回会话token和主题
+// 浏览器的EventSource和WebSocket无法设置请求头，因此通过ticket查询参数传递一次性的连接票据，
+// 会话token不会出现在URL中，也就不会被记录到访问日志
+func (ct *ctrl) authorize(c echo.Context) (string, []string, error) {
+	token := headerToken(c)
+	if ticket := c.QueryParam("ticket"); ticket != "" {
+		t, ok, err := ct.tickets.Redeem(ticket)
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
+			return "", nil, echo.NewHTTPError(http.StatusUnauthorized, "票据无效或已过期")
+		}
+		token = t
+	}
+
+	topics := c.QueryParams()["topic"]
+	if len(topics) == 0 {
+		return "", nil, echo.NewHTTPError(http.StatusBadRequest, "缺少topic参数")
+	}
+	for _, topic := range topics {
+		if err := quick.ValidateTopic(topic); err != nil {
+			return "", nil, echo.NewHTTPError(http.StatusBadRequest, "topic参数错误").SetInternal(err)
+		}
+	}
+	if err := ct.check(c.Request().Context(), token, topics); err != nil {
+		return "", nil, err
+	}
+	return token, topics, nil
+}
+
+// check 校验会话仍然有效并且管理员拥有每个主题的订阅权限
+// 连接时和每次心跳时调用，登出、会话被撤销或者权限被收回后连接会被断开
+func (ct *ctrl) check(ctx context.Context, token string, topics []string) error {
+	adminID, err := ct.authenticate(token)
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if !ct.canAccess(ctx, adminID, MethodSubscribe, topic) {
+			return echo.NewHTTPError(http.StatusForbidden, "没有订阅权限: "+topic)
+		}
+	}
+	return nil
+}
+
+// lastEventID 从Last-Event-ID请求头或lastEventId查询参数中获取
+func lastEventID(c echo.Context) uint64 {
+	s := c.Request().Header.Get("Last-Event-ID")
+	if s == "" {
+		s = c.QueryParam("lastEventId")
+	}
+	id, _ := strconv.ParseUint(s, 10, 64)
+	return id
+}
+
+// stream 补发并持续推送事件，直到连接断开、被踢掉、服务停止或者心跳时会话和权限校验失败
+func (ct *ctrl) stream(ctx context.Context, token string, topics []string, lastID uint64, send func(ev *Event) error) error {
+	c, replay, err := ct.hub.attach(topics, lastID, BufferSize)
+	if err != nil {
+		return err
+	}
+	defer ct.hub.detach(c)
+
+	for i := range replay {
+		if err := send(&replay[i]); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(ct.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-c.ch:
+			if err := send(&ev); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := ct.check(ctx, token, topics); err != nil {
+				return nil
+			}
+			if err := send(nil); err != nil {
+				return err
+			}
+		case <-c.kicked:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-ct.done:
+			return nil
+		}
+	}
+}
+
+func (ct *ctrl) sse(c echo.Context) error {
+	token, topics, err := ct.authorize(c)
+	if err != nil {
+		return err
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprintf(res, "retry: 3000\n\n")
+	res.Flush()
+
+	return ct.stream(c.Request().Context(), token, topics, lastEventID(c), func(ev *Event) error {
+		var err error
+		if ev == nil {
+			_, err = fmt.Fprint(res, ": ping\n\n")
+		} else {
+			_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Topic, strings.ReplaceAll(ev.Data, "\n", "\ndata: "))
+		}
+		if err == nil {
+			res.Flush()
+		}
+		return err
+	})
+}
+
+// wsMessage 是WebSocket推送的消息，心跳的Type是ping
+type wsMessage struct {
+	Type string `json:"type"`
+	*Event
+}
+
+func (ct *ctrl) ws(c echo.Context) error {
+	token, topics, err := ct.authorize(c)
+	if err != nil {
+		return err
+	}
+	lastID := lastEventID(c)
+
+	websocket.Handler(func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		// 客户端不会发送消息，读取只用于发现连接关闭
+		ctx, cancel := context.WithCancel(c.Request().Context())
+		defer cancel()
+		go func() {
+			defer cancel()
+			var msg string
+			for websocket.Message.Receive(conn, &msg) == nil {
+			}
+		}()
+
+		err := ct.stream(ctx, token, topics, lastID, func(ev *Event) error {
+			msg := wsMessage{Type: "event", Event: ev}
+			if ev == nil {
+				msg.Type = "ping"
+			}
+			bs, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			return websocket.Message.Send(conn, string(bs))
+		})
+		if err != nil {
+			ct.logf("[ERROR] Realtime WebSocket Failed: %s", err.Error())
+		}
+	}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
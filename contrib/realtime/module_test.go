@@ -0,0 +1,279 @@
+package realtime
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hiwjd/quick"
+	"github.com/hiwjd/quick/contrib/admin"
+	"github.com/hiwjd/quick/support/session"
+	"github.com/hiwjd/quick/util"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func newTestServer(t *testing.T) (quick.Context, string, string) {
+	app := quick.New(quick.Config{})
+	ac := app.Context()
+
+	saes, err := util.NewSimpleAES([]byte("0123456789abcdef"))
+	assert.Nil(t, err)
+	storage := session.NewAesStorage(saes)
+	token, err := storage.Set(&admin.Session{ID: 1}, 0)
+	assert.Nil(t, err)
+
+	ct := newCtrl(ac, storage, func(ctx context.Context, adminID uint, method, path string) bool {
+		return adminID == 1 && method == MethodSubscribe && strings.HasPrefix(path, "order.")
+	})
+	ct.heartbeat = 50 * time.Millisecond
+
+	e := echo.New()
+	e.POST("/ana/realtime/ticket", ct.ticket)
+	e.GET("/ana/realtime/sse", ct.sse)
+	e.GET("/ana/realtime/ws", ct.ws)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return ac, srv.URL, token
+}
+
+// issueTicket 用会话token签发连接票据
+func issueTicket(t *testing.T, url, token string) string {
+	req, _ := http.NewRequest(http.MethodPost, url+"/ana/realtime/ticket", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	defer res.Body.Close()
+	var resp TicketResp
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&resp))
+	assert.Equal(t, 30, resp.ExpiresIn)
+	return resp.Ticket
+}
+
+func TestSSE(t *testing.T) {
+	ac, url, token := newTestServer(t)
+
+	res, err := http.Get(url + "/ana/realtime/sse?topic=order.paid")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	res, err = http.Get(url + "/ana/realtime/sse?topic=order.paid&ticket=unknown")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	res, err = http.Get(url + "/ana/realtime/sse?topic=admin.created&ticket=" + issueTicket(t, url, token))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	ticket := issueTicket(t, url, token)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url+"/ana/realtime/sse?topic=order.paid&ticket="+ticket, nil)
+	res, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "text/event-stream", res.Header.Get(echo.HeaderContentType))
+
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	next := func(prefix string) string {
+		for {
+			select {
+			case line := <-lines:
+				if strings.HasPrefix(line, prefix) {
+					return line
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timeout waiting for %q", prefix)
+			}
+		}
+	}
+
+	next("retry:")
+	assert.Nil(t, ac.Publish("order.paid", "o1"))
+	assert.Equal(t, "id: 1", next("id:"))
+	assert.Equal(t, "event: order.paid", next("event:"))
+	assert.Equal(t, "data: o1", next("data:"))
+	next(": ping")
+	cancel()
+
+	// 票据只能使用一次
+	res, err = http.Get(url + "/ana/realtime/sse?topic=order.paid&ticket=" + ticket)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// 重连后补发断开期间的事件
+	assert.Nil(t, ac.Publish("order.paid", "o2"))
+	assert.Nil(t, ac.Publish("order.paid", "o3"))
+	time.Sleep(20 * time.Millisecond)
+	req, _ = http.NewRequest(http.MethodGet, url+"/ana/realtime/sse?topic=order.paid", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	req.Header.Set("Last-Event-ID", "2")
+	res, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	scanner := bufio.NewScanner(res.Body)
+	var got []string
+	for scanner.Scan() && len(got) < 1 {
+		if line := scanner.Text(); strings.HasPrefix(line, "data:") {
+			got = append(got, line)
+		}
+	}
+	assert.Equal(t, []string{"data: o3"}, got)
+}
+
+func TestStreamRecheck(t *testing.T) {
+	app := quick.New(quick.Config{})
+	ac := app.Context()
+	storage := session.NewMemoryStorage()
+	var denied int32
+	ct := newCtrl(ac, storage, func(ctx context.Context, adminID uint, method, path string) bool {
+		return atomic.LoadInt32(&denied) == 0
+	})
+	ct.heartbeat = 20 * time.Millisecond
+	e := echo.New()
+	e.GET("/ana/realtime/sse", ct.sse)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	// 连接期间会话被撤销或者订阅权限被收回时断开连接
+	for _, revoke := range []func(token string){
+		func(token string) { assert.Nil(t, storage.Del(token)) },
+		func(token string) { atomic.StoreInt32(&denied, 1) },
+	} {
+		atomic.StoreInt32(&denied, 0)
+		token, err := storage.Set(&admin.Session{ID: 1}, 0)
+		assert.Nil(t, err)
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ana/realtime/sse?topic=order.paid", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		closed := make(chan struct{})
+		go func() {
+			io.Copy(ioutil.Discard, res.Body)
+			close(closed)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		revoke(token)
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("stream not closed after the session or permission is revoked")
+		}
+		res.Body.Close()
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	ac, url, token := newTestServer(t)
+	wsURL := "ws" + strings.TrimPrefix(url, "http") + "/ana/realtime/ws?topic=order.*&ticket=" + issueTicket(t, url, token)
+
+	conn, err := websocket.Dial(wsURL, "", url)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Nil(t, ac.Publish("order.paid", "o1"))
+	for {
+		var raw string
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		assert.Nil(t, websocket.Message.Receive(conn, &raw))
+		var msg struct {
+			Type  string `json:"type"`
+			ID    uint64 `json:"id"`
+			Topic string `json:"topic"`
+			Data  string `json:"data"`
+		}
+		assert.Nil(t, json.Unmarshal([]byte(raw), &msg))
+		if msg.Type == "ping" {
+			continue
+		}
+		assert.Equal(t, "event", msg.Type)
+		assert.Equal(t, uint64(1), msg.ID)
+		assert.Equal(t, "order.*", msg.Topic)
+		assert.Equal(t, "o1", msg.Data)
+		break
+	}
+}
+
+func TestHubRelease(t *testing.T) {
+	app := quick.New(quick.Config{})
+	ac := app.Context()
+	h := newHub(ac.Subscribe, 10)
+	h.idle = 30 * time.Millisecond
+
+	c, _, err := h.attach([]string{"order.paid"}, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, ac.PubSubStats(), 1)
+
+	// 空闲期间重新连接时保持订阅
+	h.detach(c)
+	time.Sleep(10 * time.Millisecond)
+	c, _, err = h.attach([]string{"order.paid"}, 0, 10)
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, ac.PubSubStats(), 1)
+
+	// 最后一个客户端断开超过空闲时间后取消订阅
+	h.detach(c)
+	assert.Eventually(t, func() bool {
+		return len(ac.PubSubStats()) == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+// exclusiveSub 模拟redis stream模式，同名订阅取消之前不能再次订阅
+type exclusiveSub struct {
+	quick.Subscription
+	active *int32
+}
+
+func (s exclusiveSub) Unsubscribe() {
+	time.Sleep(10 * time.Millisecond)
+	s.Subscription.Unsubscribe()
+	atomic.StoreInt32(s.active, 0)
+}
+
+func TestHubResubscribeDuringRelease(t *testing.T) {
+	app := quick.New(quick.Config{})
+	ac := app.Context()
+	var active int32
+	h := newHub(func(topic string, cb func(string), opts ...quick.SubscribeOption) (quick.Subscription, error) {
+		if !atomic.CompareAndSwapInt32(&active, 0, 1) {
+			return nil, errors.New("duplicate subscriber name")
+		}
+		sub, err := ac.Subscribe(topic, cb, opts...)
+		return exclusiveSub{Subscription: sub, active: &active}, err
+	}, 10)
+	h.idle = time.Millisecond
+
+	// 取消订阅期间重新连接时等待取消完成后再订阅
+	for i := 0; i < 5; i++ {
+		c, _, err := h.attach([]string{"order.paid"}, 0, 10)
+		assert.Nil(t, err)
+		h.detach(c)
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Eventually(t, func() bool {
+		return len(ac.PubSubStats()) == 0
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,118 @@
+package realtime
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// TicketTTL 是连接票据的有效期
+const TicketTTL = 30 * time.Second
+
+// ticketStore 保存连接票据，票据只能使用一次
+// 浏览器的EventSource和WebSocket不能设置请求头，通过票据连接可以避免会话token出现在URL和访问日志中
+// 票据对应会话token，连接期间用它重新校验会话
+type ticketStore interface {
+	// Issue 为会话token签发有效期为ttl的票据
+	Issue(token string, ttl time.Duration) (string, error)
+	// Redeem 使用票据返回会话token，票据不存在、已过期或者已使用时ok为false
+	Redeem(ticket string) (token string, ok bool, err error)
+}
+
+func newTicket() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
+
+// memoryTicketStore 是进程内的票据存储，只适用于单实例部署
+type memoryTicketStore struct {
+	mu      sync.Mutex
+	tickets map[string]memoryTicket
+}
+
+type memoryTicket struct {
+	token    string
+	expireAt time.Time
+}
+
+func newMemoryTicketStore() *memoryTicketStore {
+	return &memoryTicketStore{tickets: make(map[string]memoryTicket)}
+}
+
+func (s *memoryTicketStore) Issue(token string, ttl time.Duration) (string, error) {
+	ticket, err := newTicket()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, t := range s.tickets {
+		if !now.Before(t.expireAt) {
+			delete(s.tickets, k)
+		}
+	}
+	s.tickets[ticket] = memoryTicket{token: token, expireAt: now.Add(ttl)}
+	return ticket, nil
+}
+
+func (s *memoryTicketStore) Redeem(ticket string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tickets[ticket]
+	if !ok {
+		return "", false, nil
+	}
+	delete(s.tickets, ticket)
+	if !time.Now().Before(t.expireAt) {
+		return "", false, nil
+	}
+	return t.token, true, nil
+}
+
+// redisTicketScript 原子地读取并删除票据，并发使用同一张票据时只有一个能成功
+var redisTicketScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if v then
+  redis.call('DEL', KEYS[1])
+end
+return v
+`)
+
+// redisTicketStore 是redis中的票据存储，多实例部署时票据可以在任意实例使用
+type redisTicketStore struct {
+	client *redis.Client
+}
+
+func (s *redisTicketStore) key(ticket string) string {
+	return "realtime:ticket:" + ticket
+}
+
+func (s *redisTicketStore) Issue(token string, ttl time.Duration) (string, error) {
+	ticket, err := newTicket()
+	if err != nil {
+		return "", err
+	}
+	if err = s.client.Set(s.key(ticket), token, ttl).Err(); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+func (s *redisTicketStore) Redeem(ticket string) (string, bool, error) {
+	token, err := redisTicketScript.Run(s.client, []string{s.key(ticket)}).Text()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return token, true, nil
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/hiwjd/quick"
+	"gorm.io/gorm"
+)
+
+// CreateEndpointCmd 是添加接收地址的命令
+type CreateEndpointCmd struct {
+	Topic       string `json:"topic" validate:"nonzero"`
+	URL         string `json:"url" validate:"nonzero"`
+	Secret      string `json:"secret"` // 为空时自动生成
+	Description string `json:"description"`
+	Active      bool   `json:"active"`
+}
+
+func (cmd CreateEndpointCmd) toModel() (Endpoint, error) {
+	if err := quick.ValidateTopic(cmd.Topic); err != nil {
+		return Endpoint{}, err
+	}
+
+	secret := cmd.Secret
+	if secret == "" {
+		bs := make([]byte, 24)
+		if _, err := rand.Read(bs); err != nil {
+			return Endpoint{}, err
+		}
+		secret = hex.EncodeToString(bs)
+	}
+
+	return Endpoint{
+		Topic:       cmd.Topic,
+		URL:         cmd.URL,
+		Secret:      secret,
+		Description: cmd.Description,
+		Active:      cmd.Active,
+	}, nil
+}
+
+// UpdateEndpointCmd 是修改接收地址的命令，Secret为空时不修改
+type UpdateEndpointCmd struct {
+	ID          uint   `json:"id" validate:"nonzero"`
+	Topic       string `json:"topic" validate:"nonzero"`
+	URL         string `json:"url" validate:"nonzero"`
+	Secret      string `json:"secret"`
+	Description string `json:"description"`
+	Active      bool   `json:"active"`
+}
+
+func (cmd UpdateEndpointCmd) toUpdates() (map[string]interface{}, error) {
+	if err := quick.ValidateTopic(cmd.Topic); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"topic":       cmd.Topic,
+		"url":         cmd.URL,
+		"description": cmd.Description,
+		"active":      cmd.Active,
+	}
+	if cmd.Secret != "" {
+		updates["secret"] = cmd.Secret
+	}
+	return updates, nil
+}
+
+// QueryDeliveryPageCmd 是查询投递分页列表的命令
+type QueryDeliveryPageCmd struct {
+	Page       int    `query:"page"`
+	Size       int    `query:"size"`
+	EndpointID uint   `query:"endpointId"`
+	Topic      string `query:"topic"`
+	Status     string `query:"status"`
+}
+
+func (cmd QueryDeliveryPageCmd) offset() int {
+	return (cmd.Page - 1) * cmd.Size
+}
+
+func (cmd QueryDeliveryPageCmd) applyCondition(db *gorm.DB) *gorm.DB {
+	if cmd.EndpointID != 0 {
+		db = db.Where("endpoint_id = ?", cmd.EndpointID)
+	}
+	if cmd.Topic != "" {
+		db = db.Where("topic = ?", cmd.Topic)
+	}
+	if cmd.Status != "" {
+		db = db.Where("status = ?", cmd.Status)
+	}
+	return db
+}
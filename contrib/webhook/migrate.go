@@ -0,0 +1,11 @@
+package webhook
+
+import "gorm.io/gorm"
+
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(
+		Endpoint{},
+		Delivery{},
+		Attempt{},
+	)
+}
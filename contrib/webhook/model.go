@@ -0,0 +1,61 @@
+package webhook
+
+import "time"
+
+// 投递状态
+const (
+	StatusPending = "pending" // 等待投递
+	StatusSuccess = "success" // 投递成功
+	StatusFailed  = "failed"  // 最近一次投递失败，未超过重试次数时会继续重试
+)
+
+// Endpoint 是接收事件的地址
+type Endpoint struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Topic       string    `gorm:"type:varchar(100);not null;index" json:"topic"` // 订阅的主题，可以包含通配符*和>
+	URL         string    `gorm:"type:varchar(500);not null" json:"url"`
+	Secret      string    `gorm:"type:varchar(100);not null" json:"-"` // 签名密钥，只在添加时返回
+	Description string    `gorm:"type:varchar(200)" json:"description"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// TableName 指定表名
+func (Endpoint) TableName() string {
+	return "webhook_endpoint"
+}
+
+// Delivery 是一个事件到一个地址的投递
+type Delivery struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	EndpointID uint      `gorm:"index;not null;uniqueIndex:uk_webhook_delivery_event,priority:2" json:"endpointId"`
+	EventID    string    `gorm:"type:varchar(50);not null;uniqueIndex:uk_webhook_delivery_event,priority:1" json:"eventId"` // 同一事件到同一地址只有一个投递
+	Topic      string    `gorm:"type:varchar(100);not null" json:"topic"`
+	Body       string    `gorm:"type:text" json:"body"` // 发送的请求体
+	Status     string    `gorm:"type:varchar(20);not null;index" json:"status"`
+	Attempts   int       `gorm:"not null;default:0" json:"attempts"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// TableName 指定表名
+func (Delivery) TableName() string {
+	return "webhook_delivery"
+}
+
+// Attempt 是一次投递请求的记录
+type Attempt struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	DeliveryID   uint      `gorm:"index;not null" json:"deliveryId"`
+	StatusCode   int       `json:"statusCode"`                             // 响应状态码，请求失败时为0
+	ResponseBody string    `gorm:"type:varchar(1000)" json:"responseBody"` // 响应内容，超过1000字节时截断
+	Error        string    `gorm:"type:varchar(500)" json:"error"`
+	Duration     int64     `json:"duration"` // 耗时，单位毫秒
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// TableName 指定表名
+func (Attempt) TableName() string {
+	return "webhook_attempt"
+}
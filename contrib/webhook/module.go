@@ -0,0 +1,232 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/hiwjd/quick"
+	"github.com/hiwjd/quick/support/session"
+	"github.com/hiwjd/quick/util"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	// TaskDispatch 是分发任务名，任务参数是json编码的事件信封
+	TaskDispatch = "webhook.dispatch"
+	// TaskDeliver 是投递任务名，任务参数是投递ID
+	TaskDeliver = "webhook.deliver"
+	// MaxRetry 是投递失败后的最多重试次数，重试间隔由任务队列按指数退避计算
+	MaxRetry = 8
+)
+
+// WebhookModule 订阅所有主题，把事件投递到匹配的接收地址
+// 投递通过任务队列执行，任务队列使用db或redis存储时重启后会继续投递
+// 依赖admin模块，后台接口由admin模块的AdminSessionCheck校验会话和权限
+func WebhookModule(ac quick.Context) {
+	if _, ok := ac.Take("adminSessionStorage").(session.Storage); !ok {
+		panic("Missing Dependency session.Storage#adminSessionStorage")
+	}
+
+	webhookService := NewService(ac.GetDB(), nil)
+	ac.Provide("webhookService", webhookService)
+
+	ct := &ctrl{
+		ac:             ac,
+		webhookService: webhookService,
+	}
+
+	ac.RegisterTask(TaskDispatch, ct.dispatchTask)
+	ac.RegisterTask(TaskDeliver, func(ctx context.Context, payload string) error {
+		id, err := strconv.ParseUint(payload, 10, 32)
+		if err != nil {
+			return err
+		}
+		err = webhookService.Deliver(ctx, uint(id))
+		if errors.Is(err, ErrEndpointInactive) || errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	})
+
+	if err := ct.subscribe(); err != nil {
+		panic("Webhook Subscribe Failed: " + err.Error())
+	}
+
+	ac.GET("/ana/admin/webhook/query-endpoint-list", ct.queryEndpointList) // 后台 - webhook接收地址列表
+	ac.POST("/ana/admin/webhook/create-endpoint", ct.createEndpoint)       // 后台 - 添加webhook接收地址
+	ac.POST("/ana/admin/webhook/update-endpoint", ct.updateEndpoint)       // 后台 - 修改webhook接收地址
+	ac.POST("/ana/admin/webhook/delete-endpoint", ct.deleteEndpoint)       // 后台 - 删除webhook接收地址
+	ac.GET("/ana/admin/webhook/query-delivery-page", ct.queryDeliveryPage) // 后台 - webhook投递分页列表
+	ac.GET("/ana/admin/webhook/query-attempt-list", ct.queryAttemptList)   // 后台 - webhook投递的请求记录
+	ac.POST("/ana/admin/webhook/redeliver", ct.redeliver)                  // 后台 - 重新投递
+}
+
+// IDReq 是只包含ID的请求
+type IDReq struct {
+	ID uint `json:"id" validate:"nonzero"`
+}
+
+type ctrl struct {
+	ac             quick.Context
+	webhookService Service
+}
+
+// CreateEndpointResp 是添加接收地址的响应，签名密钥只在添加时返回
+type CreateEndpointResp struct {
+	Endpoint
+	Secret string `json:"secret"`
+}
+
+// subscribe 订阅所有主题
+func (ct *ctrl) subscribe() error {
+	_, err := ct.ac.SubscribeTopicE(">", ct.dispatch, quick.WithName("webhook"), quick.WithRetry(3, 0))
+	return err
+}
+
+// dispatch 把事件包装成信封放入分发任务
+// 事件ID在这里确定，分发任务重试时不会为同一个事件和接收地址重复创建投递
+func (ct *ctrl) dispatch(topic, payload string) error {
+	eventID, body, err := buildBody(topic, payload)
+	if err != nil {
+		return err
+	}
+	_, err = ct.ac.Enqueue(context.Background(), TaskDispatch, body, quick.WithMaxRetry(MaxRetry), quick.WithUniqueKey(TaskDispatch+":"+eventID))
+	if errors.Is(err, quick.ErrTaskDuplicated) {
+		return nil
+	}
+	return err
+}
+
+// dispatchTask 为事件创建投递并放入任务队列，可以安全重试
+func (ct *ctrl) dispatchTask(ctx context.Context, body string) error {
+	env := quick.ParseEnvelope("", body)
+	deliveries, err := ct.webhookService.Dispatch(ctx, env.Topic, body)
+	if err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		if err = ct.enqueue(ctx, d.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ct *ctrl) enqueue(ctx context.Context, id uint) error {
+	payload := strconv.FormatUint(uint64(id), 10)
+	_, err := ct.ac.Enqueue(ctx, TaskDeliver, payload, quick.WithMaxRetry(MaxRetry), quick.WithUniqueKey(TaskDeliver+":"+payload))
+	if errors.Is(err, quick.ErrTaskDuplicated) {
+		return nil
+	}
+	return err
+}
+
+func (ct *ctrl) queryEndpointList(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	list, err := ct.webhookService.QueryEndpointList(ctx)
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func (ct *ctrl) createEndpoint(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var cmd CreateEndpointCmd
+	if err = quick.BindAndValidate(c, &cmd); err != nil {
+		return
+	}
+
+	ep, err := ct.webhookService.CreateEndpoint(ctx, cmd)
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, CreateEndpointResp{Endpoint: ep, Secret: ep.Secret})
+}
+
+func (ct *ctrl) updateEndpoint(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var cmd UpdateEndpointCmd
+	if err = quick.BindAndValidate(c, &cmd); err != nil {
+		return
+	}
+
+	if err = ct.webhookService.UpdateEndpoint(ctx, cmd); err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, util.Map{"message": "更新成功"})
+}
+
+func (ct *ctrl) deleteEndpoint(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var req IDReq
+	if err = quick.BindAndValidate(c, &req); err != nil {
+		return
+	}
+
+	if err = ct.webhookService.DeleteEndpoint(ctx, req.ID); err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, util.Map{"message": "删除成功"})
+}
+
+func (ct *ctrl) queryDeliveryPage(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	cmd := QueryDeliveryPageCmd{
+		Page: 1,
+		Size: 20,
+	}
+	if err = c.Bind(&cmd); err != nil {
+		return
+	}
+
+	rows, pg, err := ct.webhookService.QueryDeliveryPage(ctx, cmd)
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, util.Map{"data": rows, "pg": pg})
+}
+
+func (ct *ctrl) queryAttemptList(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	s := c.QueryParam("deliveryId")
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "deliveryId错误").SetInternal(err)
+	}
+
+	list, err := ct.webhookService.QueryAttemptList(ctx, uint(id))
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func (ct *ctrl) redeliver(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var req IDReq
+	if err = quick.BindAndValidate(c, &req); err != nil {
+		return
+	}
+
+	if _, err = ct.webhookService.GetDelivery(ctx, req.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = echo.NewHTTPError(http.StatusNotFound, "投递不存在").SetInternal(err)
+		}
+		return
+	}
+	if err = ct.enqueue(ctx, req.ID); err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, util.Map{"message": "已加入投递队列"})
+}
@@ -0,0 +1,255 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hiwjd/quick"
+	"github.com/hiwjd/quick/support"
+	"github.com/rs/xid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrEndpointInactive 表示接收地址已停用或已删除，此时不再重试
+	ErrEndpointInactive = errors.New("webhook endpoint inactive")
+)
+
+// Service 是webhook服务
+type Service interface {
+	CreateEndpoint(ctx context.Context, cmd CreateEndpointCmd) (Endpoint, error)                       // 添加接收地址
+	UpdateEndpoint(ctx context.Context, cmd UpdateEndpointCmd) error                                   // 修改接收地址
+	DeleteEndpoint(ctx context.Context, id uint) error                                                 // 删除接收地址
+	QueryEndpointList(ctx context.Context) ([]Endpoint, error)                                         // 查询所有接收地址
+	QueryDeliveryPage(ctx context.Context, cmd QueryDeliveryPageCmd) ([]Delivery, support.Page, error) // 查询投递分页列表
+	GetDelivery(ctx context.Context, id uint) (Delivery, error)                                        // 根据ID查询投递
+	QueryAttemptList(ctx context.Context, deliveryID uint) ([]Attempt, error)                          // 查询投递的请求记录
+	Dispatch(ctx context.Context, topic string, payload string) ([]Delivery, error)                    // 为匹配主题的接收地址创建投递，同一事件重复分发时返回已有的投递
+	Deliver(ctx context.Context, deliveryID uint) error                                                // 发送一次投递请求，失败时返回error
+}
+
+// NewService 构造webhook服务，client为nil时使用超时10秒的http.Client
+func NewService(db *gorm.DB, client *http.Client) Service {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &service{
+		db:     db,
+		client: client,
+	}
+}
+
+type service struct {
+	db     *gorm.DB
+	client *http.Client
+}
+
+func (s *service) CreateEndpoint(ctx context.Context, cmd CreateEndpointCmd) (ep Endpoint, err error) {
+	if ep, err = cmd.toModel(); err != nil {
+		return
+	}
+	err = s.db.WithContext(ctx).Create(&ep).Error
+	return
+}
+
+func (s *service) UpdateEndpoint(ctx context.Context, cmd UpdateEndpointCmd) error {
+	updates, err := cmd.toUpdates()
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Model(Endpoint{}).Where("id = ?", cmd.ID).Updates(updates).Error
+}
+
+func (s *service) DeleteEndpoint(ctx context.Context, id uint) error {
+	return s.db.WithContext(ctx).Where("id = ?", id).Delete(Endpoint{}).Error
+}
+
+func (s *service) QueryEndpointList(ctx context.Context) (data []Endpoint, err error) {
+	err = s.db.WithContext(ctx).Order("id").Find(&data).Error
+	return
+}
+
+func (s *service) QueryDeliveryPage(ctx context.Context, cmd QueryDeliveryPageCmd) (data []Delivery, pg support.Page, err error) {
+	db := s.db.WithContext(ctx)
+
+	pg = support.Page{}
+	pg.Page = cmd.Page
+	pg.Size = cmd.Size
+	db = cmd.applyCondition(db.Model(Delivery{}))
+	err = db.Count(&pg.Count).Order("id desc").Limit(cmd.Size).Offset(cmd.offset()).Find(&data).Error
+
+	return
+}
+
+func (s *service) GetDelivery(ctx context.Context, id uint) (d Delivery, err error) {
+	err = s.db.WithContext(ctx).Where("id = ?", id).Take(&d).Error
+	return
+}
+
+func (s *service) QueryAttemptList(ctx context.Context, deliveryID uint) (data []Attempt, err error) {
+	err = s.db.WithContext(ctx).Where("delivery_id = ?", deliveryID).Order("id").Find(&data).Error
+	return
+}
+
+func (s *service) Dispatch(ctx context.Context, topic string, payload string) ([]Delivery, error) {
+	db := s.db.WithContext(ctx)
+
+	// 只查询主题相同的和包含通配符的接收地址，再逐个匹配通配符
+	var endpoints []Endpoint
+	if err := db.Where("active = ? AND (topic = ? OR topic LIKE ? OR topic LIKE ?)", true, topic, "%*%", "%>%").Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+
+	var deliveries []Delivery
+	var endpointIDs []uint
+	var eventID, body string
+	for _, ep := range endpoints {
+		if !quick.MatchTopic(ep.Topic, topic) {
+			continue
+		}
+		if body == "" {
+			var err error
+			if eventID, body, err = buildBody(topic, payload); err != nil {
+				return nil, err
+			}
+		}
+		endpointIDs = append(endpointIDs, ep.ID)
+		deliveries = append(deliveries, Delivery{
+			EndpointID: ep.ID,
+			EventID:    eventID,
+			Topic:      topic,
+			Body:       body,
+			Status:     StatusPending,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+
+	// 同一事件重复分发时已经存在的投递不再创建，返回全部投递以便重新放入任务队列
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	deliveries = nil
+	err := db.Where("event_id = ? AND endpoint_id IN ?", eventID, endpointIDs).Order("id").Find(&deliveries).Error
+	return deliveries, err
+}
+
+// buildBody 把事件包装成json编码的quick.Envelope
+// 通过Publish发布的字符串事件，是json时直接作为data，否则作为json字符串
+func buildBody(topic string, payload string) (string, string, error) {
+	env := quick.ParseEnvelope(topic, payload)
+	if env.ContentType == quick.ContentTypeText {
+		env.ID = xid.New().String()
+		env.Time = time.Now()
+		env.ContentType = quick.ContentTypeJSON
+		if !json.Valid(env.Data) {
+			data, err := json.Marshal(payload)
+			if err != nil {
+				return "", "", err
+			}
+			env.Data = data
+		}
+	}
+	body, err := env.Encode()
+	return env.ID, body, err
+}
+
+func (s *service) Deliver(ctx context.Context, deliveryID uint) error {
+	d, err := s.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		return err
+	}
+
+	var ep Endpoint
+	if err = s.db.WithContext(ctx).Where("id = ? AND active = ?", d.EndpointID, true).Take(&ep).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = ErrEndpointInactive
+		}
+		if rerr := s.record(ctx, d, Attempt{Error: err.Error()}); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+
+	attempt, err := s.post(ctx, ep, d)
+	if rerr := s.record(ctx, d, attempt); rerr != nil && err == nil {
+		// 请求成功但记录失败时返回错误，任务重试会再次投递，接收方需要按投递ID去重
+		return rerr
+	}
+	return err
+}
+
+func (s *service) post(ctx context.Context, ep Endpoint, d Delivery) (Attempt, error) {
+	attempt := Attempt{}
+	ts := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewBufferString(d.Body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set(HeaderTopic, d.Topic)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(ep.Secret, ts, []byte(d.Body)))
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	attempt.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	defer resp.Body.Close()
+
+	bs, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1000))
+	attempt.StatusCode = resp.StatusCode
+	attempt.ResponseBody = string(bs)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = fmt.Errorf("webhook endpoint responded %d", resp.StatusCode)
+		attempt.Error = err.Error()
+	}
+	return attempt, err
+}
+
+// record 保存请求记录并更新投递状态
+func (s *service) record(ctx context.Context, d Delivery, attempt Attempt) error {
+	status := StatusSuccess
+	if attempt.Error != "" {
+		status = StatusFailed
+	}
+	attempt.Error = truncate(attempt.Error, 500)
+	attempt.DeliveryID = d.ID
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+		return tx.Model(Delivery{}).Where("id = ?", d.ID).Updates(map[string]interface{}{
+			"status":   status,
+			"attempts": gorm.Expr("attempts + 1"),
+		}).Error
+	})
+}
+
+// truncate 把s截断到不超过n字节，不会截断多字节字符
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,206 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hiwjd/quick"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestWebhook(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, Migrate(db))
+
+	var (
+		status   = http.StatusInternalServerError
+		received []string
+		verifyOK bool
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		verifyOK = Verify("s3cret", r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body, time.Minute) == nil
+		received = append(received, r.Header.Get(HeaderTopic))
+		w.WriteHeader(status)
+		w.Write([]byte("done"))
+	}))
+	defer receiver.Close()
+
+	service := NewService(db, receiver.Client())
+	ctx := context.Background()
+
+	_, err = service.CreateEndpoint(ctx, CreateEndpointCmd{Topic: "admin.>", URL: receiver.URL, Secret: "s3cret", Active: true})
+	assert.Nil(t, err)
+	inactive, err := service.CreateEndpoint(ctx, CreateEndpointCmd{Topic: ">", URL: receiver.URL})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, inactive.Secret)
+	_, err = service.CreateEndpoint(ctx, CreateEndpointCmd{Topic: "admin.>.x", URL: receiver.URL})
+	assert.Equal(t, quick.ErrInvalidTopic, err)
+
+	deliveries, err := service.Dispatch(ctx, "order.paid", `{"id":1}`)
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 0)
+
+	payload, err := quick.NewEnvelope(ctx, "admin.created", map[string]int{"id": 1})
+	assert.Nil(t, err)
+	encoded, err := payload.Encode()
+	assert.Nil(t, err)
+	deliveries, err = service.Dispatch(ctx, "admin.created", encoded)
+	assert.Nil(t, err)
+	if !assert.Len(t, deliveries, 1) {
+		return
+	}
+	id := deliveries[0].ID
+	assert.Equal(t, payload.ID, deliveries[0].EventID)
+
+	// 同一事件重复分发时返回已有的投递
+	again, err := service.Dispatch(ctx, "admin.created", encoded)
+	assert.Nil(t, err)
+	if assert.Len(t, again, 1) {
+		assert.Equal(t, id, again[0].ID)
+	}
+
+	// 接收方返回500时记录失败
+	assert.NotNil(t, service.Deliver(ctx, id))
+	d, err := service.GetDelivery(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusFailed, d.Status)
+	assert.Equal(t, 1, d.Attempts)
+
+	status = http.StatusOK
+	assert.Nil(t, service.Deliver(ctx, id))
+	assert.True(t, verifyOK)
+	assert.Equal(t, []string{"admin.created", "admin.created"}, received)
+
+	d, err = service.GetDelivery(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusSuccess, d.Status)
+	assert.Equal(t, 2, d.Attempts)
+	env := quick.ParseEnvelope("", d.Body)
+	assert.Equal(t, "admin.created", env.Topic)
+
+	attempts, err := service.QueryAttemptList(ctx, id)
+	assert.Nil(t, err)
+	if assert.Len(t, attempts, 2) {
+		assert.Equal(t, http.StatusInternalServerError, attempts[0].StatusCode)
+		assert.NotEmpty(t, attempts[0].Error)
+		assert.Equal(t, http.StatusOK, attempts[1].StatusCode)
+		assert.Equal(t, "done", attempts[1].ResponseBody)
+	}
+
+	rows, pg, err := service.QueryDeliveryPage(ctx, QueryDeliveryPageCmd{Page: 1, Size: 10, Status: StatusSuccess})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), pg.Count)
+	assert.Equal(t, id, rows[0].ID)
+
+	// 接收地址删除后不再投递
+	assert.Nil(t, service.DeleteEndpoint(ctx, deliveries[0].EndpointID))
+	assert.Equal(t, ErrEndpointInactive, service.Deliver(ctx, id))
+	assert.Len(t, received, 2)
+}
+
+func TestSign(t *testing.T) {
+	ts := time.Now().Unix()
+	sig := Sign("k", ts, []byte("body"))
+	tsStr := strconv.FormatInt(ts, 10)
+	assert.Nil(t, Verify("k", tsStr, sig, []byte("body"), time.Minute))
+	assert.Equal(t, ErrSignatureMismatch, Verify("k", tsStr, sig, []byte("changed"), time.Minute))
+	assert.Equal(t, ErrSignatureMismatch, Verify("other", tsStr, sig, []byte("body"), time.Minute))
+
+	old := time.Now().Add(-time.Hour).Unix()
+	assert.Equal(t, ErrTimestampExpired, Verify("k", strconv.FormatInt(old, 10), Sign("k", old, []byte("body")), []byte("body"), time.Minute))
+}
+
+// taskRecorder 记录放入任务队列的任务，fail不为nil时返回它模拟任务队列故障
+type taskRecorder struct {
+	quick.Context
+	mu    sync.Mutex
+	tasks map[string][]string
+	fail  error
+}
+
+func (r *taskRecorder) Enqueue(ctx context.Context, name string, payload string, opts ...quick.TaskOption) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail != nil {
+		return "", r.fail
+	}
+	r.tasks[name] = append(r.tasks[name], payload)
+	return "", nil
+}
+
+func (r *taskRecorder) take(name string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tasks := r.tasks[name]
+	delete(r.tasks, name)
+	return tasks
+}
+
+func TestDispatchPlainPublish(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, Migrate(db))
+
+	app := quick.New(quick.Config{})
+	ac := &taskRecorder{Context: app.Context(), tasks: make(map[string][]string)}
+	service := NewService(db, nil)
+	ct := &ctrl{ac: ac, webhookService: service}
+	assert.Nil(t, ct.subscribe())
+
+	ctx := context.Background()
+	ep, err := service.CreateEndpoint(ctx, CreateEndpointCmd{Topic: "order.*", URL: "http://localhost", Active: true})
+	assert.Nil(t, err)
+
+	// 通过Publish发布的字符串事件也按实际主题投递
+	assert.Nil(t, ac.Publish("order.paid", `{"id":1}`))
+	var bodies []string
+	assert.Eventually(t, func() bool {
+		bodies = append(bodies, ac.take(TaskDispatch)...)
+		return len(bodies) == 1
+	}, time.Second, 10*time.Millisecond)
+	if !assert.Len(t, bodies, 1) {
+		return
+	}
+
+	// 放入投递任务失败时分发任务重试，不会重复创建投递
+	ac.fail = errors.New("broker down")
+	assert.NotNil(t, ct.dispatchTask(ctx, bodies[0]))
+	ac.fail = nil
+	assert.Nil(t, ct.dispatchTask(ctx, bodies[0]))
+	assert.Len(t, ac.take(TaskDeliver), 1)
+
+	rows, _, err := service.QueryDeliveryPage(ctx, QueryDeliveryPageCmd{Page: 1, Size: 10, EndpointID: ep.ID})
+	assert.Nil(t, err)
+	if assert.Len(t, rows, 1) {
+		assert.Equal(t, "order.paid", rows[0].Topic)
+		env := quick.ParseEnvelope("", rows[0].Body)
+		assert.Equal(t, "order.paid", env.Topic)
+		assert.JSONEq(t, `{"id":1}`, string(env.Data))
+	}
+
+	// 列表中不返回签名密钥
+	bs, err := json.Marshal(ep)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(bs), ep.Secret)
+	bs, err = json.Marshal(CreateEndpointResp{Endpoint: ep, Secret: ep.Secret})
+	assert.Nil(t, err)
+	assert.Contains(t, string(bs), ep.Secret)
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "ab", truncate("ab", 2))
+	assert.Equal(t, "a", truncate("a中", 3))
+	assert.Equal(t, "a中", truncate("a中b", 4))
+}
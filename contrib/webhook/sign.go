@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 请求头
+const (
+	HeaderID        = "X-Webhook-Id"        // 投递ID，重试时不变，接收方可据此去重
+	HeaderTopic     = "X-Webhook-Topic"     // 事件主题
+	HeaderTimestamp = "X-Webhook-Timestamp" // 发送时间，unix秒
+	HeaderSignature = "X-Webhook-Signature" // 签名，格式为sha256=十六进制HMAC
+)
+
+var (
+	// ErrSignatureMismatch 表示签名不匹配
+	ErrSignatureMismatch = errors.New("webhook signature mismatch")
+	// ErrTimestampExpired 表示时间戳超出允许的误差
+	ErrTimestampExpired = errors.New("webhook timestamp expired")
+)
+
+// Sign 计算签名：HMAC-SHA256(secret, timestamp + "." + body)
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 供接收方校验签名，tolerance为允许的时间误差，为0时不检查时间
+func Verify(secret string, timestamp string, signature string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureMismatch
+	}
+	if tolerance > 0 {
+		d := time.Since(time.Unix(ts, 0))
+		if d > tolerance || d < -tolerance {
+			return ErrTimestampExpired
+		}
+	}
+	expected := Sign(secret, ts, body)
+	if !strings.HasPrefix(signature, "sha256=") || !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
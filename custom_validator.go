@@ -14,12 +14,31 @@ func NewCustomValidator() echo.Validator {
 	return &customValidator{}
 }
 
-// Validate 实现echo.Validator
+// Validate 实现echo.Validator，校验失败时返回ErrorArray，由HTTPErrorHandler响应每个字段的错误
 func (cv *customValidator) Validate(i interface{}) error {
-	if err := Check(i); err != nil {
-		// if err := validator.Validate(i); err != nil {
-		// err 是 validator.ErrorMap 可以做更好的错误信息包装
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	return validateErr(Check(i))
+}
+
+// BindAndValidate 绑定请求参数到i并校验，校验的提示使用请求的Accept-Language协商出的语言
+//
+//	var cmd CreateAdminCmd
+//	if err := quick.BindAndValidate(c, &cmd); err != nil {
+//		return err
+//	}
+func BindAndValidate(c echo.Context, i interface{}) error {
+	if err := c.Bind(i); err != nil {
+		return err
+	}
+	return validateErr(CheckLang(i, c.Request().Header.Get("Accept-Language")))
+}
+
+// validateErr ErrorArray原样返回，其他错误比如ErrUnsupported转成400
+func validateErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(ErrorArray); ok {
+		return err
 	}
-	return nil
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
 }
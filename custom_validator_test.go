@@ -1,8 +1,12 @@
 package quick
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,3 +30,50 @@ func TestCustomValidator(t *testing.T) {
 	errs = v.Validate(nur)
 	assert.Nil(t, errs)
 }
+
+type forTestBind struct {
+	Name string `json:"name" validate:"nonzero" title:"姓名"`
+	Age  int    `json:"age" validate:"min=18" title:"年龄"`
+}
+
+func TestBindAndValidate(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = NewCustomHTTPErrorHandler(e, func(format string, args ...interface{}) {})
+	e.POST("/users", func(c echo.Context) error {
+		var req forTestBind
+		if err := BindAndValidate(c, &req); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, req)
+	})
+
+	cases := []struct {
+		body   string
+		accept string
+		code   int
+		want   string
+	}{
+		{`{"age":10}`, "", 400, `{"code":"http.bad_request","message":"姓名: 不能为空, 年龄: 不能小于18","errors":[{"field":"name","title":"姓名","errors":["不能为空"]},{"field":"age","title":"年龄","errors":["不能小于18"]}]}`},
+		{`{"name":"a","age":10}`, "en", 400, `{"code":"http.bad_request","message":"年龄: must be at least 18","errors":[{"field":"age","title":"年龄","errors":["must be at least 18"]}]}`},
+		{`{"name":"a","age":20}`, "", 200, `{"name":"a","age":20}`},
+		{`{"name":`, "", 400, ``},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tc.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Accept-Language", tc.accept)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, tc.code, rec.Code, tc.body)
+		if tc.want != "" {
+			assert.JSONEq(t, tc.want, rec.Body.String(), tc.body)
+		}
+	}
+
+	err := NewCustomValidator().Validate(forTestBind{Age: 20})
+	assert.Equal(t, ErrorArray{{Field: "name", Title: "姓名", Errors: []string{"不能为空"}}}, err)
+	he, ok := NewCustomValidator().Validate("string").(*echo.HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, he.Code)
+}
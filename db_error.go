@@ -0,0 +1,322 @@
+package quick
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// DBErrKind 是数据库错误的类别
+type DBErrKind int
+
+const (
+	DBErrNotFound    DBErrKind = iota + 1 // 记录不存在
+	DBErrDuplicate                        // 唯一键冲突
+	DBErrForeignKey                       // 外键约束
+	DBErrDeadlock                         // 死锁
+	DBErrLockTimeout                      // 等待锁超时
+	DBErrConnection                       // 连接断开
+	DBErrDataTooLong                      // 数据超过字段长度
+)
+
+var dbErrKindNames = map[DBErrKind]string{
+	DBErrNotFound:    "not found",
+	DBErrDuplicate:   "duplicate",
+	DBErrForeignKey:  "foreign key",
+	DBErrDeadlock:    "deadlock",
+	DBErrLockTimeout: "lock timeout",
+	DBErrConnection:  "connection",
+	DBErrDataTooLong: "data too long",
+}
+
+func (k DBErrKind) String() string {
+	return dbErrKindNames[k]
+}
+
+// 数据库错误对应的错误码，记录不存在没有专门的错误码，使用通用错误码ErrHTTPNotFound
+var (
+	ErrDBDuplicate = RegisterErrCode("db.duplicate", http.StatusConflict, "数据已存在", map[string]string{
+		"en": "Data already exists",
+	})
+	ErrDBForeignKey = RegisterErrCode("db.foreign_key", http.StatusConflict, "数据存在关联，无法操作", map[string]string{
+		"en": "Data is referenced by or references other data",
+	})
+	ErrDBDeadlock = RegisterErrCode("db.deadlock", http.StatusServiceUnavailable, "系统繁忙，请稍后重试", map[string]string{
+		"en": "Server is busy, please try again later",
+	})
+	ErrDBLockTimeout = RegisterErrCode("db.lock_timeout", http.StatusServiceUnavailable, "系统繁忙，请稍后重试", map[string]string{
+		"en": "Server is busy, please try again later",
+	})
+	ErrDBUnavailable = RegisterErrCode("db.unavailable", http.StatusServiceUnavailable, "服务暂时不可用", map[string]string{
+		"en": "Service temporarily unavailable",
+	})
+	ErrDBDataTooLong = RegisterErrCode("db.data_too_long", http.StatusBadRequest, "数据过长", map[string]string{
+		"en": "Data too long",
+	})
+)
+
+var dbErrCodes = map[DBErrKind]*ErrCode{
+	DBErrDuplicate:   ErrDBDuplicate,
+	DBErrForeignKey:  ErrDBForeignKey,
+	DBErrDeadlock:    ErrDBDeadlock,
+	DBErrLockTimeout: ErrDBLockTimeout,
+	DBErrConnection:  ErrDBUnavailable,
+	DBErrDataTooLong: ErrDBDataTooLong,
+}
+
+// DBErr 是翻译后的数据库错误，与驱动无关
+// Table、Index、Field 只在能从驱动错误中得到时才有，Field 由列名或索引名推断
+type DBErr struct {
+	Kind  DBErrKind
+	Table string
+	Index string
+	Field string
+	cause error
+}
+
+// NewDBErr 构造DBErr，用于自定义的DBErrorTranslator
+func NewDBErr(kind DBErrKind, cause error) *DBErr {
+	return &DBErr{Kind: kind, cause: cause}
+}
+
+func (de *DBErr) Error() string {
+	if de.cause == nil {
+		return "db " + de.Kind.String()
+	}
+	return "db " + de.Kind.String() + ": " + de.cause.Error()
+}
+
+// Unwrap 返回驱动错误
+func (de *DBErr) Unwrap() error {
+	return de.cause
+}
+
+// Is 使errors.Is(err, ErrDBDuplicate)等成立
+func (de *DBErr) Is(target error) bool {
+	ec, ok := dbErrCodes[de.Kind]
+	return ok && target == ec
+}
+
+// ErrCode 返回对应的错误码，记录不存在时返回nil
+func (de *DBErr) ErrCode() *ErrCode {
+	return dbErrCodes[de.Kind]
+}
+
+// Status 返回HTTP状态码
+func (de *DBErr) Status() int {
+	if ec := de.ErrCode(); ec != nil {
+		return ec.Status
+	}
+	if de.Kind == DBErrNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// DBErrorTranslator 把驱动错误翻译成DBErr，不能翻译时返回nil
+type DBErrorTranslator func(err error) *DBErr
+
+var (
+	dbErrorTranslatorsMu sync.RWMutex
+	dbErrorTranslators   = []DBErrorTranslator{translateMysqlError, translatePostgresError, translateSqliteError}
+)
+
+// RegisterDBErrorTranslator 注册DBErrorTranslator，后注册的优先，内置了MySQL、PostgreSQL和sqlite的翻译
+func RegisterDBErrorTranslator(t DBErrorTranslator) {
+	dbErrorTranslatorsMu.Lock()
+	defer dbErrorTranslatorsMu.Unlock()
+	dbErrorTranslators = append([]DBErrorTranslator{t}, dbErrorTranslators...)
+}
+
+// TranslateDBError 把err翻译成DBErr，err不是数据库错误时返回nil
+func TranslateDBError(err error) *DBErr {
+	if err == nil {
+		return nil
+	}
+	var de *DBErr
+	if errors.As(err, &de) {
+		return de
+	}
+
+	dbErrorTranslatorsMu.RLock()
+	translators := dbErrorTranslators
+	dbErrorTranslatorsMu.RUnlock()
+	for _, t := range translators {
+		if de := t(err); de != nil {
+			return de
+		}
+	}
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return NewDBErr(DBErrNotFound, err)
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn):
+		return NewDBErr(DBErrConnection, err)
+	}
+	return nil
+}
+
+var (
+	mysqlDuplicateRe  = regexp.MustCompile("for key '([^']+)'")
+	mysqlForeignKeyRe = regexp.MustCompile("CONSTRAINT `([^`]+)` FOREIGN KEY \\(`([^`]+)`")
+	mysqlTableRe      = regexp.MustCompile("\\(`[^`]+`\\.`([^`]+)`")
+	mysqlColumnRe     = regexp.MustCompile("column '([^']+)'")
+)
+
+func translateMysqlError(err error) *DBErr {
+	var me *mysql.MySQLError
+	if !errors.As(err, &me) {
+		return nil
+	}
+
+	de := &DBErr{cause: err}
+	switch me.Number {
+	case 1062, 1586:
+		de.Kind = DBErrDuplicate
+		// MySQL 8 中索引名带表名，比如 'admin.uk_name'
+		if m := mysqlDuplicateRe.FindStringSubmatch(me.Message); m != nil {
+			de.Index = m[1]
+			if i := strings.LastIndexByte(de.Index, '.'); i >= 0 {
+				de.Table, de.Index = de.Index[:i], de.Index[i+1:]
+			}
+		}
+		de.Field = indexField(de.Table, de.Index)
+	case 1451, 1452, 1216, 1217:
+		de.Kind = DBErrForeignKey
+		if m := mysqlForeignKeyRe.FindStringSubmatch(me.Message); m != nil {
+			de.Index, de.Field = m[1], m[2]
+		}
+		if m := mysqlTableRe.FindStringSubmatch(me.Message); m != nil {
+			de.Table = m[1]
+		}
+	case 1213:
+		de.Kind = DBErrDeadlock
+	case 1205:
+		de.Kind = DBErrLockTimeout
+	case 1406:
+		de.Kind = DBErrDataTooLong
+		if m := mysqlColumnRe.FindStringSubmatch(me.Message); m != nil {
+			de.Field = m[1]
+		}
+	default:
+		return nil
+	}
+	return de
+}
+
+// translatePostgresError 翻译lib/pq和pgx的错误，二者都实现了SQLState
+func translatePostgresError(err error) *DBErr {
+	var se interface{ SQLState() string }
+	if !errors.As(err, &se) {
+		return nil
+	}
+
+	de := &DBErr{cause: err}
+	state := se.SQLState()
+	switch {
+	case state == "23505":
+		de.Kind = DBErrDuplicate
+	case state == "23503":
+		de.Kind = DBErrForeignKey
+	case state == "40P01":
+		de.Kind = DBErrDeadlock
+	case state == "55P03":
+		de.Kind = DBErrLockTimeout
+	case state == "22001":
+		de.Kind = DBErrDataTooLong
+	case strings.HasPrefix(state, "08"), state == "57P01", state == "57P02", state == "57P03":
+		de.Kind = DBErrConnection
+	default:
+		return nil
+	}
+
+	// pgx是TableName、ConstraintName、ColumnName，lib/pq是Table、Constraint、Column
+	de.Table = stringField(se, "TableName", "Table")
+	de.Index = stringField(se, "ConstraintName", "Constraint")
+	de.Field = stringField(se, "ColumnName", "Column")
+	if de.Field == "" {
+		de.Field = indexField(de.Table, de.Index)
+	}
+	return de
+}
+
+// translateSqliteError 按消息翻译sqlite的错误，驱动不同时错误类型也不同
+func translateSqliteError(err error) *DBErr {
+	e := err
+	for next := errors.Unwrap(e); next != nil; next = errors.Unwrap(e) {
+		e = next
+	}
+	msg := e.Error()
+
+	de := &DBErr{cause: err}
+	switch {
+	case strings.HasPrefix(msg, "UNIQUE constraint failed: "), strings.HasPrefix(msg, "PRIMARY KEY constraint failed: "):
+		de.Kind = DBErrDuplicate
+		// UNIQUE constraint failed: admin.name, admin.tenant_id
+		column := msg[strings.Index(msg, ": ")+2:]
+		if i := strings.Index(column, ", "); i >= 0 {
+			column = column[:i]
+		}
+		if i := strings.IndexByte(column, '.'); i >= 0 {
+			de.Table, de.Field = column[:i], column[i+1:]
+		}
+	case strings.HasPrefix(msg, "FOREIGN KEY constraint failed"):
+		de.Kind = DBErrForeignKey
+	case strings.HasPrefix(msg, "database is locked"), strings.HasPrefix(msg, "database table is locked"):
+		de.Kind = DBErrLockTimeout
+	default:
+		return nil
+	}
+	return de
+}
+
+var indexPrefixes = []string{"uk_", "uni_", "uniq_", "unique_", "uix_", "ux_", "idx_", "fk_"}
+var indexSuffixes = []string{"_key", "_fkey", "_pkey", "_idx", "_unique"}
+
+// indexField 从索引名推断字段名，比如 uk_name、idx_admin_name、admin_name_key 都推断为 name
+func indexField(table, index string) string {
+	field := index
+	for _, p := range indexPrefixes {
+		if strings.HasPrefix(field, p) {
+			field = field[len(p):]
+			break
+		}
+	}
+	for _, s := range indexSuffixes {
+		if strings.HasSuffix(field, s) {
+			field = field[:len(field)-len(s)]
+			break
+		}
+	}
+	if table != "" && strings.HasPrefix(field, table+"_") {
+		field = field[len(table)+1:]
+	}
+	if field == "PRIMARY" {
+		return ""
+	}
+	return field
+}
+
+// stringField 返回结构体指针err中第一个存在的字符串字段的值
+func stringField(err interface{}, names ...string) string {
+	v := reflect.ValueOf(err)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	for _, name := range names {
+		if f := v.FieldByName(name); f.IsValid() && f.Kind() == reflect.String {
+			return f.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package quick
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// testPgError 模拟pgx的*pgconn.PgError
+type testPgError struct {
+	Code           string
+	TableName      string
+	ConstraintName string
+	ColumnName     string
+}
+
+func (e *testPgError) Error() string    { return "pg error " + e.Code }
+func (e *testPgError) SQLState() string { return e.Code }
+
+type testDBUser struct {
+	ID   uint
+	Name string `gorm:"uniqueIndex"`
+}
+
+func TestTranslateDBError(t *testing.T) {
+	cases := []struct {
+		err   error
+		kind  DBErrKind
+		table string
+		field string
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'admin.uk_name'"}, DBErrDuplicate, "admin", "name"},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'idx_admin_mobile'"}, DBErrDuplicate, "", "admin_mobile"},
+		{&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`db`.`order`, CONSTRAINT `fk_order_user` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`))"}, DBErrForeignKey, "order", "user_id"},
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, DBErrDeadlock, "", ""},
+		{&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, DBErrLockTimeout, "", ""},
+		{&mysql.MySQLError{Number: 1406, Message: "Data too long for column 'name' at row 1"}, DBErrDataTooLong, "", "name"},
+		{fmt.Errorf("query: %w", mysql.ErrInvalidConn), DBErrConnection, "", ""},
+		{driver.ErrBadConn, DBErrConnection, "", ""},
+		{&testPgError{Code: "23505", TableName: "admin", ConstraintName: "admin_name_key"}, DBErrDuplicate, "admin", "name"},
+		{&testPgError{Code: "23503", TableName: "order", ConstraintName: "order_user_id_fkey"}, DBErrForeignKey, "order", "user_id"},
+		{&testPgError{Code: "22001", ColumnName: "name"}, DBErrDataTooLong, "", "name"},
+		{&testPgError{Code: "08006"}, DBErrConnection, "", ""},
+		{&testPgError{Code: "40P01"}, DBErrDeadlock, "", ""},
+		{errors.New("database is locked"), DBErrLockTimeout, "", ""},
+		{Wrap(gorm.ErrRecordNotFound, "find"), DBErrNotFound, "", ""},
+	}
+	for _, tc := range cases {
+		de := TranslateDBError(tc.err)
+		if !assert.NotNil(t, de, tc.err.Error()) {
+			continue
+		}
+		assert.Equal(t, tc.kind, de.Kind, tc.err.Error())
+		assert.Equal(t, tc.table, de.Table, tc.err.Error())
+		assert.Equal(t, tc.field, de.Field, tc.err.Error())
+		assert.True(t, errors.Is(de, tc.err), tc.err.Error())
+	}
+
+	assert.Nil(t, TranslateDBError(errors.New("normal")))
+	assert.Nil(t, TranslateDBError(&mysql.MySQLError{Number: 1146}))
+	assert.Nil(t, TranslateDBError(&testPgError{Code: "42P01"}))
+}
+
+func TestTranslateSqliteError(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&testDBUser{}))
+	assert.Nil(t, db.Create(&testDBUser{Name: "a"}).Error)
+
+	err = db.Create(&testDBUser{Name: "a"}).Error
+	de := TranslateDBError(err)
+	if assert.NotNil(t, de) {
+		assert.Equal(t, DBErrDuplicate, de.Kind)
+		assert.Equal(t, "test_db_users", de.Table)
+		assert.Equal(t, "name", de.Field)
+		assert.True(t, errors.Is(de, ErrDBDuplicate))
+	}
+
+	err = db.First(&testDBUser{}, 100).Error
+	assert.Equal(t, http.StatusNotFound, TranslateDBError(err).Status())
+}
+
+func TestDBErrorHandler(t *testing.T) {
+	RegisterDBErrorTranslator(func(err error) *DBErr {
+		if err.Error() == "custom busy" {
+			return NewDBErr(DBErrDeadlock, err)
+		}
+		return nil
+	})
+
+	e := echo.New()
+	handle := NewCustomHTTPErrorHandler(e, func(format string, args ...interface{}) {}, WithErrorFormat(ErrorFormatProblem))
+
+	cases := []struct {
+		err    error
+		accept string
+		want   string
+		code   int
+	}{
+		{
+			Wrap(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'admin.uk_name'"}, "create"), "",
+			`{"type":"about:blank","title":"Conflict","status":409,"detail":"数据已存在","instance":"/","code":"db.duplicate","errors":[{"field":"name","title":"","errors":["数据已存在"]}]}`,
+			409,
+		},
+		{
+			errors.New("custom busy"), "en",
+			`{"type":"about:blank","title":"Service Unavailable","status":503,"detail":"Server is busy, please try again later","instance":"/","code":"db.deadlock"}`,
+			503,
+		},
+		{
+			gorm.ErrRecordNotFound, "",
+			`{"type":"about:blank","title":"Not Found","status":404,"detail":"资源不存在","instance":"/","code":"http.not_found"}`,
+			404,
+		},
+		{
+			// 外层的BizErr优先于数据库错误
+			errTestAccountLocked.Wrap(&mysql.MySQLError{Number: 1213}), "",
+			`{"type":"about:blank","title":"Bad Request","status":400,"detail":"account_locked","instance":"/","code":"http.bad_request"}`,
+			400,
+		},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Accept-Language", tc.accept)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		handle(tc.err, c)
+
+		assert.Equal(t, tc.code, rec.Code)
+		assert.JSONEq(t, tc.want, rec.Body.String())
+	}
+}
@@ -0,0 +1,249 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrDeadLetterNotFound 表示死信不存在
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+)
+
+type (
+	// DeadLetter 是重试后仍处理失败的事件
+	DeadLetter struct {
+		ID         uint       `gorm:"primaryKey" json:"id"`
+		Topic      string     `gorm:"type:varchar(100);index" json:"topic"`
+		Subscriber string     `gorm:"type:varchar(100);index" json:"subscriber"` // 订阅者名，见WithName
+		Payload    string     `gorm:"type:text" json:"payload"`
+		Error      string     `gorm:"type:varchar(500)" json:"error"`
+		Attempts   int        `json:"attempts"`
+		ReplayedAt *time.Time `json:"replayedAt"`
+		CreatedAt  time.Time  `json:"createdAt"`
+	}
+
+	// DeadLetterQuery 是查询死信的条件
+	DeadLetterQuery struct {
+		Page       int    `query:"page" title:"页码，默认1"`
+		Size       int    `query:"size" title:"每页条数，默认20"`
+		Topic      string `query:"topic" title:"主题"`
+		Subscriber string `query:"subscriber" title:"订阅者"`
+		Replayed   *bool  `query:"replayed" title:"是否已重新处理"`
+	}
+
+	// DeadLetterStore 是死信存储
+	DeadLetterStore interface {
+		// Save 保存死信
+		Save(ctx context.Context, dl *DeadLetter) error
+		// Query 按条件分页查询，按ID倒序，返回当页数据和总数
+		Query(ctx context.Context, q DeadLetterQuery) ([]DeadLetter, int64, error)
+		// Get 根据ID查询，不存在时返回ErrDeadLetterNotFound
+		Get(ctx context.Context, id uint) (DeadLetter, error)
+		// MarkReplayed 标记为已重新投递
+		MarkReplayed(ctx context.Context, id uint) error
+	}
+)
+
+// TableName 指定表名
+func (DeadLetter) TableName() string {
+	return "dead_letter"
+}
+
+// MigrateDeadLetter 创建死信表
+func MigrateDeadLetter(db *gorm.DB) error {
+	return db.AutoMigrate(DeadLetter{})
+}
+
+func (q DeadLetterQuery) normalize() DeadLetterQuery {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.Size < 1 {
+		q.Size = 20
+	}
+	return q
+}
+
+// NewDBDeadLetterStore 构造数据库实现的DeadLetterStore
+func NewDBDeadLetterStore(db *gorm.DB) DeadLetterStore {
+	return &dbDeadLetterStore{db: db}
+}
+
+type dbDeadLetterStore struct {
+	db *gorm.DB
+}
+
+func (s *dbDeadLetterStore) Save(ctx context.Context, dl *DeadLetter) error {
+	dl.Error = truncate(dl.Error, 500)
+	return s.db.WithContext(ctx).Create(dl).Error
+}
+
+func (s *dbDeadLetterStore) Query(ctx context.Context, q DeadLetterQuery) (data []DeadLetter, count int64, err error) {
+	q = q.normalize()
+	db := s.db.WithContext(ctx).Model(DeadLetter{})
+	if q.Topic != "" {
+		db = db.Where("topic = ?", q.Topic)
+	}
+	if q.Subscriber != "" {
+		db = db.Where("subscriber = ?", q.Subscriber)
+	}
+	if q.Replayed != nil {
+		if *q.Replayed {
+			db = db.Where("replayed_at IS NOT NULL")
+		} else {
+			db = db.Where("replayed_at IS NULL")
+		}
+	}
+	err = db.Count(&count).Order("id desc").Limit(q.Size).Offset((q.Page - 1) * q.Size).Find(&data).Error
+	return
+}
+
+func (s *dbDeadLetterStore) Get(ctx context.Context, id uint) (dl DeadLetter, err error) {
+	err = s.db.WithContext(ctx).Where("id = ?", id).Take(&dl).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = ErrDeadLetterNotFound
+	}
+	return
+}
+
+func (s *dbDeadLetterStore) MarkReplayed(ctx context.Context, id uint) error {
+	return s.db.WithContext(ctx).Model(DeadLetter{}).Where("id = ?", id).Update("replayed_at", time.Now()).Error
+}
+
+// NewMemDeadLetterStore 构造内存实现的DeadLetterStore，重启后丢失，最多保留最近的limit条
+func NewMemDeadLetterStore(limit int) DeadLetterStore {
+	if limit < 1 {
+		limit = 1000
+	}
+	return &memDeadLetterStore{limit: limit}
+}
+
+type memDeadLetterStore struct {
+	mu     sync.RWMutex
+	limit  int
+	nextID uint
+	items  []DeadLetter
+}
+
+func (s *memDeadLetterStore) Save(ctx context.Context, dl *DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	dl.ID = s.nextID
+	dl.CreatedAt = time.Now()
+	s.items = append(s.items, *dl)
+	if len(s.items) > s.limit {
+		s.items = s.items[len(s.items)-s.limit:]
+	}
+	return nil
+}
+
+func (s *memDeadLetterStore) Query(ctx context.Context, q DeadLetterQuery) ([]DeadLetter, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	q = q.normalize()
+	var matched []DeadLetter
+	for _, dl := range s.items {
+		if (q.Topic != "" && dl.Topic != q.Topic) ||
+			(q.Subscriber != "" && dl.Subscriber != q.Subscriber) ||
+			(q.Replayed != nil && *q.Replayed != (dl.ReplayedAt != nil)) {
+			continue
+		}
+		matched = append(matched, dl)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID > matched[j].ID })
+
+	count := int64(len(matched))
+	from := (q.Page - 1) * q.Size
+	if from >= len(matched) {
+		return []DeadLetter{}, count, nil
+	}
+	to := from + q.Size
+	if to > len(matched) {
+		to = len(matched)
+	}
+	return matched[from:to], count, nil
+}
+
+func (s *memDeadLetterStore) Get(ctx context.Context, id uint) (DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, dl := range s.items {
+		if dl.ID == id {
+			return dl, nil
+		}
+	}
+	return DeadLetter{}, ErrDeadLetterNotFound
+}
+
+func (s *memDeadLetterStore) MarkReplayed(ctx context.Context, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.items {
+		if s.items[i].ID == id {
+			now := time.Now()
+			s.items[i].ReplayedAt = &now
+			return nil
+		}
+	}
+	return ErrDeadLetterNotFound
+}
+
+// retryHandler 包装返回error的回调：失败时按退避重试，重试用尽后写入死信
+type retryHandler struct {
+	name    string
+	cb      func(topic, payload string) error
+	retry   int
+	backoff time.Duration
+	store   DeadLetterStore
+	logf    Logf
+}
+
+// call 执行一次回调，panic转成error
+func (h *retryHandler) call(topic, payload string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panic: %#v", r)
+		}
+	}()
+	return h.cb(topic, payload)
+}
+
+// handle 处理主题为topic的事件，死信记录的是事件的实际主题，而不是通配符订阅的主题
+func (h *retryHandler) handle(topic, payload string) {
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		if err = h.call(topic, payload); err == nil {
+			return
+		}
+		if attempts > h.retry {
+			break
+		}
+		time.Sleep(h.backoff << uint(attempts-1))
+	}
+
+	h.logf("[ERROR] Subscriber Failed: %s, subscriber=%s attempts=%d", err.Error(), h.name, attempts)
+	dl := &DeadLetter{
+		Topic:      topic,
+		Subscriber: h.name,
+		Payload:    payload,
+		Error:      err.Error(),
+		Attempts:   attempts,
+	}
+	if err := h.store.Save(context.Background(), dl); err != nil {
+		h.logf("[ERROR] Dead Letter Save Failed: %s, subscriber=%s payload=%s", err.Error(), h.name, payload)
+	}
+}
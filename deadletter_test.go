@@ -0,0 +1,117 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testDeadLetterStore(t *testing.T, store DeadLetterStore) {
+	ctx := context.Background()
+	for _, topic := range []string{"a", "b", "a"} {
+		assert.Nil(t, store.Save(ctx, &DeadLetter{Topic: topic, Subscriber: topic + "#0", Payload: "p", Error: "e", Attempts: 1}))
+	}
+
+	rows, count, err := store.Query(ctx, DeadLetterQuery{Topic: "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+	assert.Equal(t, uint(3), rows[0].ID)
+
+	rows, count, err = store.Query(ctx, DeadLetterQuery{Page: 2, Size: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+	assert.Len(t, rows, 1)
+
+	assert.Nil(t, store.MarkReplayed(ctx, 1))
+	dl, err := store.Get(ctx, 1)
+	assert.Nil(t, err)
+	assert.NotNil(t, dl.ReplayedAt)
+
+	replayed := false
+	_, count, err = store.Query(ctx, DeadLetterQuery{Replayed: &replayed})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+
+	_, err = store.Get(ctx, 100)
+	assert.Equal(t, ErrDeadLetterNotFound, err)
+}
+
+func TestMemDeadLetterStore(t *testing.T) {
+	testDeadLetterStore(t, NewMemDeadLetterStore(10))
+}
+
+func TestDBDeadLetterStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, MigrateDeadLetter(db))
+	testDeadLetterStore(t, NewDBDeadLetterStore(db))
+}
+
+func TestSubscribeRetryAndDeadLetter(t *testing.T) {
+	app := New(Config{})
+	ac := app.Context()
+	ctx := context.Background()
+
+	var calls int32
+	fail := int32(1)
+	sub, err := ac.SubscribeE("order.paid", func(payload string) error {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			return errors.New("boom")
+		}
+		return nil
+	}, WithRetry(2, time.Millisecond), WithName("notify"))
+	assert.Nil(t, err)
+
+	_, err = ac.Subscribe("order.paid", func(payload string) {
+		panic("oops")
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, ac.Publish("order.paid", "o1"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	rows, count, err := ac.DeadLetters().Query(ctx, DeadLetterQuery{Subscriber: "notify"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, "o1", rows[0].Payload)
+	assert.Equal(t, "boom", rows[0].Error)
+	assert.Equal(t, 3, rows[0].Attempts)
+
+	rows, _, err = ac.DeadLetters().Query(ctx, DeadLetterQuery{Subscriber: "order.paid#0"})
+	assert.Nil(t, err)
+	assert.Len(t, rows, 1)
+	assert.Contains(t, rows[0].Error, "oops")
+
+	// 仍然失败时不标记为已处理
+	assert.NotNil(t, ac.ReplayDeadLetter(ctx, rows[0].ID))
+
+	atomic.StoreInt32(&fail, 0)
+	dl, _, _ := ac.DeadLetters().Query(ctx, DeadLetterQuery{Subscriber: "notify"})
+	assert.Nil(t, ac.ReplayDeadLetter(ctx, dl[0].ID))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+	got, err := ac.DeadLetters().Get(ctx, dl[0].ID)
+	assert.Nil(t, err)
+	assert.NotNil(t, got.ReplayedAt)
+
+	// 订阅者不存在时重新发布到原主题
+	sub.Unsubscribe()
+	var republished int32
+	_, err = ac.Subscribe("order.paid", func(payload string) {
+		atomic.AddInt32(&republished, 1)
+	}, WithName("other"))
+	assert.Nil(t, err)
+	assert.Nil(t, ac.ReplayDeadLetter(ctx, dl[0].ID))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&republished))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	assert.Equal(t, ErrDeadLetterNotFound, ac.ReplayDeadLetter(ctx, 100))
+}
@@ -3,30 +3,54 @@ package quick
 import (
 	"fmt"
 	"net/http"
-
-	"github.com/hiwjd/quick/util"
 )
 
+func init() {
+	// 构造错误的函数不作为错误的构造位置
+	for _, fn := range []string{"NewFineErr", "TraceError", "NewBadArgs", "NewSrvErr", "newBizErr", "BizErr.Wrap", "(*BizErr).Wrap"} {
+		helpers.Store("github.com/hiwjd/quick."+fn, struct{}{})
+	}
+}
+
 // FineErr 是一个可以对用户友好点的错误
 type FineErr struct {
 	err     error
 	Code    int
 	Message string
-	Caller  string
+	Caller  string // 构造位置，格式为 file:line function
+	stack   Stack
 }
 
-// NewFineErr 构造FineErr
+// NewFineErr 构造FineErr，记录构造位置，通过Helper标记的辅助函数会被跳过
 func NewFineErr(code int, message string) *FineErr {
-	file, line, fn := util.Caller(3)
-	caller := fmt.Sprintf("%s:%d %s", file, line, fn)
-	return &FineErr{Code: code, Message: message, Caller: caller}
+	stack := callers()
+	return &FineErr{Code: code, Message: message, Caller: stack.Top().String(), stack: stack}
 }
 
 func (fe *FineErr) Error() string {
 	if fe.err == nil {
 		return fe.Message
 	}
-	return fe.err.Error()
+	if fe.Message == fe.err.Error() {
+		return fe.Message
+	}
+	return fe.Message + ": " + fe.err.Error()
+}
+
+// Unwrap 返回通过SetError设置的err
+func (fe *FineErr) Unwrap() error {
+	return fe.err
+}
+
+// Is 状态码和消息都相同的FineErr视为相同的错误
+func (fe *FineErr) Is(target error) bool {
+	t, ok := target.(*FineErr)
+	return ok && t != nil && t.Code == fe.Code && t.Message == fe.Message
+}
+
+// StackTrace 返回构造时的调用栈，没有打开SetCaptureStack时只有构造位置一帧
+func (fe *FineErr) StackTrace() Stack {
+	return fe.stack
 }
 
 // SetError 设置err
@@ -50,9 +74,7 @@ type BizErr struct {
 	Type  ErrType
 	cause error
 	code  string
-	file  string
-	line  int
-	fn    string
+	stack Stack
 }
 
 func NewBadArgs(code string, cause error) BizErr {
@@ -64,18 +86,24 @@ func NewSrvErr(code string, cause error) BizErr {
 }
 
 func newBizErr(typ ErrType, code string, cause error) BizErr {
-	file, line, fn := util.Caller(4)
 	return BizErr{
 		Type:  typ,
 		cause: cause,
 		code:  code,
-		file:  file,
-		line:  line,
-		fn:    fn,
+		stack: callers(),
 	}
 }
 
+// Error 返回错误码，有原因时附加原因
 func (be BizErr) Error() string {
+	if be.cause == nil {
+		return be.code
+	}
+	return be.code + ": " + be.cause.Error()
+}
+
+// Code 返回错误码
+func (be BizErr) Code() string {
 	return be.code
 }
 
@@ -83,14 +111,67 @@ func (be BizErr) Unwrap() error {
 	return be.cause
 }
 
+// Is 错误码相同的BizErr视为相同的错误，因此可以把BizErr定义成哨兵错误：
+//
+//	var ErrAccountLocked = quick.NewBadArgs("account_locked", nil)
+//	errors.Is(err, ErrAccountLocked)
+//
+// 错误码和注册的ErrCode相同时也成立
 func (be BizErr) Is(err error) bool {
-	switch err.(type) {
+	switch t := err.(type) {
 	case BizErr:
-		return true
+		return t.code == be.code
+	case *BizErr:
+		return t != nil && t.code == be.code
+	case *ErrCode:
+		return t.Code == be.code
 	}
 	return false
 }
 
+// Wrap 返回错误码和类型相同、原因为cause的BizErr，调用位置记录为Wrap的调用方
+func (be BizErr) Wrap(cause error) BizErr {
+	return newBizErr(be.Type, be.code, cause)
+}
+
+// Caller 返回构造位置
 func (be BizErr) Caller() (file string, line int, fn string) {
-	return be.file, be.line, be.fn
+	top := be.stack.Top()
+	return top.File, top.Line, top.Function
+}
+
+// StackTrace 返回构造时的调用栈，没有打开SetCaptureStack时只有构造位置一帧
+func (be BizErr) StackTrace() Stack {
+	return be.stack
+}
+
+// wrapErr 是Wrap添加了上下文的错误
+type wrapErr struct {
+	msg string
+	err error
+}
+
+func (we *wrapErr) Error() string {
+	return we.msg + ": " + we.err.Error()
+}
+
+func (we *wrapErr) Unwrap() error {
+	return we.err
+}
+
+// Wrap 给err添加上下文msg，err为nil时返回nil
+// 包装后errors.Is、errors.As仍然可以匹配err，错误处理也仍然按err的错误码和状态码响应，msg只出现在日志中
+func Wrap(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	return &wrapErr{msg: msg, err: err}
+}
+
+// Wrapf 和Wrap相同，上下文通过format格式化
+func Wrapf(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return &wrapErr{msg: fmt.Sprintf(format, args...), err: err}
 }
@@ -0,0 +1,88 @@
+package quick
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var (
+	errTestAccountLocked = NewBadArgs("account_locked", nil)
+	errTestQuota         = NewSrvErr("quota", nil)
+)
+
+func TestBizErrIs(t *testing.T) {
+	cause := errors.New("locked by admin")
+	err := errTestAccountLocked.Wrap(cause)
+
+	assert.True(t, errors.Is(err, errTestAccountLocked))
+	assert.False(t, errors.Is(err, errTestQuota))
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, "account_locked: locked by admin", err.Error())
+	assert.Equal(t, "account_locked", err.Code())
+
+	wrapped := Wrapf(err, "login %s", "admin")
+	assert.Equal(t, "login admin: account_locked: locked by admin", wrapped.Error())
+	assert.True(t, errors.Is(wrapped, errTestAccountLocked))
+	assert.True(t, errors.Is(fmt.Errorf("x: %w", wrapped), &errTestAccountLocked))
+
+	var be BizErr
+	assert.True(t, errors.As(wrapped, &be))
+	assert.Equal(t, BadArgs, be.Type)
+
+	assert.Nil(t, Wrap(nil, "ignored"))
+}
+
+func TestFineErrIs(t *testing.T) {
+	sentinel := NewFineErr(http.StatusForbidden, "forbidden")
+	cause := errors.New("no role")
+	err := Wrap(NewFineErr(http.StatusForbidden, "forbidden").SetError(cause), "check")
+
+	assert.True(t, errors.Is(err, sentinel))
+	assert.False(t, errors.Is(err, NewFineErr(http.StatusForbidden, "other")))
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, "check: forbidden: no role", err.Error())
+
+	var fe *FineErr
+	assert.True(t, errors.As(err, &fe))
+	assert.Equal(t, http.StatusForbidden, fe.Code)
+
+	traced := TraceError(cause)
+	assert.Equal(t, "no role", traced.Error())
+}
+
+func TestErrorHandlerWrapped(t *testing.T) {
+	e := echo.New()
+	handle := NewCustomHTTPErrorHandler(e, func(format string, args ...interface{}) {})
+
+	cases := []struct {
+		err  error
+		want string
+		code int
+	}{
+		{Wrap(errTestAccountLocked.Wrap(errors.New("cause")), "login"), `{"code":"http.bad_request","message":"account_locked"}`, 400},
+		{fmt.Errorf("query: %w", errTestQuota), `{"code":"http.internal","message":"quota"}`, 500},
+		{Wrap(NewFineErr(http.StatusUnauthorized, "unauth"), "session"), `{"code":"http.unauthorized","message":"unauth"}`, 401},
+		{Wrap(ErrorArray{ErrorData{Field: "name", Title: "姓名", Errors: []string{"不能为空"}}}, "bind"), `{"code":"http.bad_request","message":"姓名: 不能为空","errors":[{"field":"name","title":"姓名","errors":["不能为空"]}]}`, 400},
+		{Wrap(errTestTooLong.New("max", 3), "save"), `{"code":"test.too_long","message":"不能超过3个字"}`, 400},
+		{fmt.Errorf("handler: %w", echo.NewHTTPError(http.StatusConflict, "conflict").SetInternal(errTestQuota)), `{"code":"http.conflict","message":"conflict"}`, 409},
+		{Wrap(errors.New("boom"), "unknown"), `{"code":"http.internal","message":"服务器内部错误"}`, 500},
+		{Wrap(gorm.ErrRecordNotFound, "find"), `{"code":"http.not_found","message":"资源不存在"}`, 404},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		handle(tc.err, c)
+
+		assert.Equal(t, tc.code, rec.Code, tc.err.Error())
+		assert.JSONEq(t, tc.want, rec.Body.String(), tc.err.Error())
+	}
+}
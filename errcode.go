@@ -0,0 +1,185 @@
+package quick
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hiwjd/quick/util"
+)
+
+// ErrCode 是注册的错误码，声明了HTTP状态码、默认消息和各语言的翻译
+// ErrCode实现了error，可以直接作为哨兵错误返回；需要模板参数或原因时通过New、Wrap构造*CodeErr，
+// errors.Is(err, ErrXxx)对二者都成立
+type ErrCode struct {
+	Code         string
+	Status       int
+	Message      string            // 默认消息，可以包含{{name}}形式的模板参数
+	Translations map[string]string // 语言标签 => 消息，比如en、zh-TW
+}
+
+// CodeErr 是带模板参数和原因的错误码实例
+type CodeErr struct {
+	ErrCode *ErrCode
+	Params  util.TplParam
+	cause   error
+}
+
+var (
+	// DefaultLang 是ErrCode默认消息的语言，Accept-Language中它的权重高于其他有翻译的语言时使用默认消息
+	DefaultLang = "zh"
+
+	errCodeMu sync.RWMutex
+	errCodes  = map[string]*ErrCode{}
+)
+
+// RegisterErrCode 注册错误码，code重复时panic
+func RegisterErrCode(code string, status int, message string, translations map[string]string) *ErrCode {
+	errCodeMu.Lock()
+	defer errCodeMu.Unlock()
+
+	if _, ok := errCodes[code]; ok {
+		panic("ErrCode Duplicated: " + code)
+	}
+	ec := &ErrCode{Code: code, Status: status, Message: message, Translations: translations}
+	errCodes[code] = ec
+	return ec
+}
+
+// LookupErrCode 根据code查找注册的错误码
+func LookupErrCode(code string) (*ErrCode, bool) {
+	errCodeMu.RLock()
+	defer errCodeMu.RUnlock()
+	ec, ok := errCodes[code]
+	return ec, ok
+}
+
+// Error 实现error，返回默认消息
+func (ec *ErrCode) Error() string {
+	return ec.Message
+}
+
+// New 构造带模板参数的错误，kv是成对的参数名和参数值，参数值通过fmt.Sprint转成字符串
+// 比如 ErrTooLong.New("max", 20) 渲染 "不能超过{{max}}个字" 为 "不能超过20个字"
+func (ec *ErrCode) New(kv ...interface{}) *CodeErr {
+	return &CodeErr{ErrCode: ec, Params: tplParams(kv)}
+}
+
+// Wrap 构造带原因的错误，原因只记录到日志，不会返回给用户
+func (ec *ErrCode) Wrap(cause error, kv ...interface{}) *CodeErr {
+	return &CodeErr{ErrCode: ec, Params: tplParams(kv), cause: cause}
+}
+
+// Localize 返回lang语言的消息，没有对应翻译时依次尝试基础语言（zh-TW => zh）和默认消息
+func (ec *ErrCode) Localize(lang string, params util.TplParam) string {
+	msg, ok := ec.translation(lang)
+	if !ok {
+		msg = ec.Message
+	}
+	if len(params) == 0 {
+		return msg
+	}
+	return util.Tpl(msg, params)
+}
+
+func (ec *ErrCode) translation(lang string) (string, bool) {
+	if lang == "" {
+		return "", false
+	}
+	if msg, ok := ec.Translations[lang]; ok {
+		return msg, true
+	}
+	if i := strings.IndexByte(lang, '-'); i > 0 {
+		msg, ok := ec.Translations[lang[:i]]
+		return msg, ok
+	}
+	return "", false
+}
+
+func tplParams(kv []interface{}) util.TplParam {
+	if len(kv) == 0 {
+		return nil
+	}
+	params := util.TplParam{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		params[fmt.Sprint(kv[i])] = fmt.Sprint(kv[i+1])
+	}
+	return params
+}
+
+// Error 实现error，返回渲染后的默认消息，有原因时附加原因
+func (ce *CodeErr) Error() string {
+	msg := ce.ErrCode.Localize("", ce.Params)
+	if ce.cause != nil {
+		return msg + ": " + ce.cause.Error()
+	}
+	return msg
+}
+
+// Unwrap 返回原因
+func (ce *CodeErr) Unwrap() error {
+	return ce.cause
+}
+
+// Is 使errors.Is(err, ErrXxx)成立
+func (ce *CodeErr) Is(target error) bool {
+	return target == ce.ErrCode
+}
+
+// Localize 返回lang语言的消息
+func (ce *CodeErr) Localize(lang string) string {
+	return ce.ErrCode.Localize(lang, ce.Params)
+}
+
+// negotiateLang 按Accept-Language的权重选出ec有翻译的语言，都没有时返回空字符串即使用默认消息
+func negotiateLang(acceptLanguage string, ec *ErrCode) string {
+	if len(ec.Translations) == 0 {
+		return ""
+	}
+	return negotiate(acceptLanguage, func(lang string) bool {
+		_, ok := ec.translation(lang)
+		return ok
+	})
+}
+
+// negotiate 按Accept-Language的权重选出has的语言，默认语言DefaultLang排在前面或者都没有时返回空字符串
+func negotiate(acceptLanguage string, has func(lang string) bool) string {
+	if acceptLanguage == "" {
+		return ""
+	}
+
+	type tag struct {
+		lang string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		t := tag{lang: strings.TrimSpace(fields[0]), q: 1}
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if q, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					t.q = q
+				}
+			}
+		}
+		if t.lang != "" && t.lang != "*" && t.q > 0 {
+			tags = append(tags, t)
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if has(t.lang) {
+			return t.lang
+		}
+		// 默认消息的语言排在前面时使用默认消息
+		if t.lang == DefaultLang || strings.HasPrefix(t.lang, DefaultLang+"-") {
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package quick
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestTooLong = RegisterErrCode("test.too_long", http.StatusBadRequest, "不能超过{{max}}个字", map[string]string{
+	"en":    "No more than {{max}} characters",
+	"zh-TW": "不能超過{{max}}個字",
+})
+
+func TestErrCode(t *testing.T) {
+	_, ok := LookupErrCode("test.too_long")
+	assert.True(t, ok)
+	assert.Panics(t, func() { RegisterErrCode("test.too_long", 400, "", nil) })
+
+	err := fmt.Errorf("save: %w", errTestTooLong.Wrap(errors.New("cause"), "max", 20))
+	assert.True(t, errors.Is(err, errTestTooLong))
+	assert.Equal(t, "save: 不能超过20个字: cause", err.Error())
+
+	assert.Contains(t, FormatChain(err), "[2] *errors.errorString: cause")
+
+	var ce *CodeErr
+	assert.True(t, errors.As(err, &ce))
+	assert.Equal(t, "No more than 20 characters", ce.Localize("en-US"))
+	assert.Equal(t, "不能超過20個字", ce.Localize("zh-TW"))
+	assert.Equal(t, "不能超过20个字", ce.Localize("fr"))
+
+	cases := map[string]string{
+		"":                               "",
+		"en":                             "en",
+		"fr-FR,en;q=0.8":                 "en",
+		"zh-CN,zh;q=0.9,en;q=0.8":        "",
+		"en;q=0.5,zh-TW;q=0.9":           "zh-TW",
+		"de, *;q=0.1":                    "",
+		"zh-CN;q=0.1,en-GB;q=0.7,ja":     "en-GB",
+		"en;q=0":                         "",
+		"zh-HK;q=0.9,zh-TW;q=0.8,en;q=1": "en",
+	}
+	for accept, want := range cases {
+		assert.Equal(t, want, negotiateLang(accept, errTestTooLong), accept)
+	}
+}
+
+func TestErrCodeHandler(t *testing.T) {
+	e := echo.New()
+	handle := NewCustomHTTPErrorHandler(e, func(format string, args ...interface{}) {})
+
+	cases := []struct {
+		err    error
+		accept string
+		want   string
+		code   int
+	}{
+		{errTestTooLong, "", `{"code":"test.too_long","message":"不能超过{{max}}个字","requestId":"r1"}`, 400},
+		{errTestTooLong.New("max", 5), "en", `{"code":"test.too_long","message":"No more than 5 characters","requestId":"r1"}`, 400},
+		{NewBadArgs("test.too_long", nil), "zh-TW", `{"code":"test.too_long","message":"不能超過{{max}}個字","requestId":"r1"}`, 400},
+		{NewBadArgs("unregistered", nil), "en", `{"code":"http.bad_request","message":"unregistered","requestId":"r1"}`, 400},
+		// 没有注册错误码的错误使用通用错误码，默认文本换成对应语言的消息
+		{errors.New("boom"), "en", `{"code":"http.internal","message":"Internal Server Error","requestId":"r1"}`, 500},
+		{echo.NewHTTPError(http.StatusForbidden), "", `{"code":"http.forbidden","message":"没有权限","requestId":"r1"}`, 403},
+		{echo.NewHTTPError(http.StatusTeapot), "", `{"code":"http.client_error","message":"请求失败","requestId":"r1"}`, 418},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Accept-Language", tc.accept)
+		rec := httptest.NewRecorder()
+		rec.Header().Set(echo.HeaderXRequestID, "r1")
+		c := e.NewContext(req, rec)
+
+		handle(tc.err, c)
+
+		assert.Equal(t, tc.code, rec.Code)
+		assert.JSONEq(t, tc.want, rec.Body.String())
+	}
+}
@@ -1,49 +1,189 @@
 package quick
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
-	"github.com/go-sql-driver/mysql"
-	"github.com/hiwjd/quick/util"
 	"github.com/labstack/echo/v4"
-	"gorm.io/gorm"
 )
 
+// ErrorFormat 是错误响应的格式
+type ErrorFormat string
+
+const (
+	// ErrorFormatLegacy 是{"code", "message", "requestId"}格式，默认
+	ErrorFormatLegacy ErrorFormat = "legacy"
+	// ErrorFormatProblem 是RFC 7807的application/problem+json格式
+	ErrorFormatProblem ErrorFormat = "problem"
+)
+
+// MIMEApplicationProblemJSON 是problem+json的Content-Type
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// 通用的HTTP错误码，没有注册错误码的错误按状态码使用它们，每个错误响应都有code
+var (
+	ErrHTTPBadRequest = RegisterErrCode("http.bad_request", http.StatusBadRequest, "请求参数错误", map[string]string{
+		"en": "Bad Request",
+	})
+	ErrHTTPUnauthorized = RegisterErrCode("http.unauthorized", http.StatusUnauthorized, "未登录或登录已失效", map[string]string{
+		"en": "Unauthorized",
+	})
+	ErrHTTPForbidden = RegisterErrCode("http.forbidden", http.StatusForbidden, "没有权限", map[string]string{
+		"en": "Forbidden",
+	})
+	ErrHTTPNotFound = RegisterErrCode("http.not_found", http.StatusNotFound, "资源不存在", map[string]string{
+		"en": "Not Found",
+	})
+	ErrHTTPMethodNotAllowed = RegisterErrCode("http.method_not_allowed", http.StatusMethodNotAllowed, "不支持的请求方法", map[string]string{
+		"en": "Method Not Allowed",
+	})
+	ErrHTTPConflict = RegisterErrCode("http.conflict", http.StatusConflict, "数据冲突", map[string]string{
+		"en": "Conflict",
+	})
+	ErrHTTPTooManyRequests = RegisterErrCode("http.too_many_requests", http.StatusTooManyRequests, "请求过于频繁", map[string]string{
+		"en": "Too Many Requests",
+	})
+	ErrHTTPInternal = RegisterErrCode("http.internal", http.StatusInternalServerError, "服务器内部错误", map[string]string{
+		"en": "Internal Server Error",
+	})
+	ErrHTTPServiceUnavailable = RegisterErrCode("http.service_unavailable", http.StatusServiceUnavailable, "服务暂时不可用", map[string]string{
+		"en": "Service Unavailable",
+	})
+	// ErrHTTPClient 是没有对应通用错误码的4xx错误
+	ErrHTTPClient = RegisterErrCode("http.client_error", http.StatusBadRequest, "请求失败", map[string]string{
+		"en": "Request Failed",
+	})
+)
+
+var httpErrCodes = map[int]*ErrCode{
+	http.StatusBadRequest:          ErrHTTPBadRequest,
+	http.StatusUnauthorized:        ErrHTTPUnauthorized,
+	http.StatusForbidden:           ErrHTTPForbidden,
+	http.StatusNotFound:            ErrHTTPNotFound,
+	http.StatusMethodNotAllowed:    ErrHTTPMethodNotAllowed,
+	http.StatusConflict:            ErrHTTPConflict,
+	http.StatusTooManyRequests:     ErrHTTPTooManyRequests,
+	http.StatusInternalServerError: ErrHTTPInternal,
+	http.StatusServiceUnavailable:  ErrHTTPServiceUnavailable,
+}
+
+// statusErrCode 返回状态码对应的通用错误码
+func statusErrCode(status int) *ErrCode {
+	if ec, ok := httpErrCodes[status]; ok {
+		return ec
+	}
+	if status < http.StatusInternalServerError {
+		return ErrHTTPClient
+	}
+	return ErrHTTPInternal
+}
+
+// errorBody 是错误响应体，requestId来自RequestID中间件
+// 没有注册错误码的错误使用状态码对应的通用错误码，消息是状态码的默认文本时换成对应语言的消息
+type errorBody struct {
+	Code      string     `json:"code,omitempty"`
+	Message   string     `json:"message"`
+	RequestID string     `json:"requestId,omitempty"`
+	Errors    ErrorArray `json:"errors,omitempty"` // 字段错误
+}
+
+// Problem 是RFC 7807定义的错误响应，Code、RequestID、Errors是扩展成员
+type Problem struct {
+	Type      string     `json:"type"`
+	Title     string     `json:"title"`
+	Status    int        `json:"status"`
+	Detail    string     `json:"detail,omitempty"`
+	Instance  string     `json:"instance,omitempty"`
+	Code      string     `json:"code,omitempty"`
+	RequestID string     `json:"requestId,omitempty"`
+	Errors    ErrorArray `json:"errors,omitempty"`
+}
+
+// ErrorHandlerOption 是NewCustomHTTPErrorHandler的选项
+type ErrorHandlerOption func(cheh *customHTTPErrorHandler)
+
+// WithErrorFormat 设置错误响应的格式
+func WithErrorFormat(format ErrorFormat) ErrorHandlerOption {
+	return func(cheh *customHTTPErrorHandler) {
+		cheh.format = format
+	}
+}
+
+// WithProblemTypeBase 设置problem格式中type的前缀，type为前缀加错误码，没有错误码时为about:blank
+func WithProblemTypeBase(base string) ErrorHandlerOption {
+	return func(cheh *customHTTPErrorHandler) {
+		cheh.typeBase = base
+	}
+}
+
+// withAlarmReporter 设置警报上报，5xx错误会被上报
+func withAlarmReporter(r *alarmReporter) ErrorHandlerOption {
+	return func(cheh *customHTTPErrorHandler) {
+		cheh.alarm = r
+	}
+}
+
 type customHTTPErrorHandler struct {
-	e    *echo.Echo
-	logf Logf
+	e        *echo.Echo
+	logf     Logf
+	format   ErrorFormat
+	typeBase string
+	alarm    *alarmReporter
 }
 
 // NewCustomHTTPErrorHandler 构造 echo.HTTPErrorHandler
-func NewCustomHTTPErrorHandler(e *echo.Echo, logf Logf) echo.HTTPErrorHandler {
-	cheh := &customHTTPErrorHandler{e: e, logf: logf}
+func NewCustomHTTPErrorHandler(e *echo.Echo, logf Logf, opts ...ErrorHandlerOption) echo.HTTPErrorHandler {
+	cheh := &customHTTPErrorHandler{e: e, logf: logf, format: ErrorFormatLegacy}
+	for _, opt := range opts {
+		opt(cheh)
+	}
 	return cheh.Handle
 }
 
 // Handle 实现 echo.HTTPErrorHandler
 func (cheh *customHTTPErrorHandler) Handle(err error, c echo.Context) {
 	code := http.StatusInternalServerError
-	body := map[string]string{"message": http.StatusText(code)}
-	caller := ""
-
-	switch t := err.(type) {
-	case *mysql.MySQLError:
-		switch t.Number {
-		case 1062:
-			// 键冲突
-			code = http.StatusBadRequest
-			body["message"] = t.Message
-			break
+	body := errorBody{Message: http.StatusText(code)}
+	caller := stackCaller(err)
+	acceptLanguage := c.Request().Header.Get("Accept-Language")
+
+	known := knownError(err)
+	if known == nil {
+		// 驱动错误翻译成DBErr
+		if de := TranslateDBError(err); de != nil {
+			known = de
+		}
+	}
+
+	switch t := known.(type) {
+	case *DBErr:
+		code = t.Status()
+		body.Message = http.StatusText(code)
+		if ec := t.ErrCode(); ec != nil {
+			body.Code = ec.Code
+			body.Message = ec.Localize(negotiateLang(acceptLanguage, ec), nil)
+			if t.Field != "" {
+				body.Errors = ErrorArray{ErrorData{Field: t.Field, Errors: []string{body.Message}}}
+			}
 		}
-		break
 	case ErrorArray:
 		code = http.StatusBadRequest
-		body = map[string]string{"message": t.Error()}
+		body.Message = t.Error()
+		body.Errors = t
 		break
+	case *ErrCode:
+		code = t.Status
+		body.Code = t.Code
+		body.Message = t.Localize(negotiateLang(acceptLanguage, t), nil)
+	case *CodeErr:
+		code = t.ErrCode.Status
+		body.Code = t.ErrCode.Code
+		body.Message = t.Localize(negotiateLang(acceptLanguage, t.ErrCode))
 	case *FineErr:
 		code = t.Code
-		body = map[string]string{"message": t.Message}
-		caller = t.Caller
+		body.Message = t.Message
 		break
 	case BizErr:
 		switch t.Type {
@@ -54,35 +194,86 @@ func (cheh *customHTTPErrorHandler) Handle(err error, c echo.Context) {
 		default:
 			code = http.StatusInternalServerError
 		}
-		body = map[string]string{"message": t.Error()}
-		caller = util.WrapCaller(t.Caller())
-		if t.cause != nil {
-			cheh.logf("%#v", t.cause)
+		body.Message = t.Code()
+		// 注册过的错误码使用声明的状态码和对应语言的消息
+		if ec, ok := LookupErrCode(t.code); ok {
+			code = ec.Status
+			body.Code = ec.Code
+			body.Message = ec.Localize(negotiateLang(acceptLanguage, ec), nil)
 		}
-	default:
-		switch err {
-		case gorm.ErrRecordNotFound:
-			code = http.StatusBadRequest
-			body = map[string]string{"message": http.StatusText(code)}
-			_, _, caller = util.Caller(3)
-			break
-		default:
-			cheh.e.DefaultHTTPErrorHandler(err, c)
-			return
+	case *echo.HTTPError:
+		code = t.Code
+		body.Message = fmt.Sprint(t.Message)
+	}
+	if body.Code == "" {
+		ec := statusErrCode(code)
+		body.Code = ec.Code
+		if body.Message == http.StatusText(code) {
+			body.Message = ec.Localize(negotiateLang(acceptLanguage, ec), nil)
 		}
 	}
+	body.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
 
-	cheh.logf("[ERROR] %s: %d %s\n", caller, code, body["message"])
+	cheh.logf("[ERROR] %s: %d %s\n", caller, code, body.Message)
+	// 有原因或者包装过的错误打印整个错误链
+	if err.Error() != body.Message || errors.Unwrap(err) != nil {
+		cheh.logf("[ERROR] %s\n", FormatChain(err))
+	}
+	if code >= http.StatusInternalServerError {
+		cheh.alarm.reportHTTP(c, err, code, caller)
+	}
 
 	// Send response
 	if !c.Response().Committed {
 		if c.Request().Method == http.MethodHead { // Issue #608
 			err = c.NoContent(code)
 		} else {
-			err = c.JSON(code, body)
+			err = cheh.render(c, code, body)
 		}
 		if err != nil {
 			cheh.logf("[ERROR] %s\n", err.Error())
 		}
 	}
 }
+
+// knownError 从外到内返回err链中第一个能处理的错误，即按外层优先的顺序做errors.As，都不能处理时返回nil
+// 外层优先使得echo.NewHTTPError(...).SetInternal(err)按HTTPError而不是内部的err响应
+func knownError(err error) error {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch t := e.(type) {
+		case *DBErr, ErrorArray, *ErrCode, *CodeErr, *FineErr, BizErr, *echo.HTTPError:
+			return e
+		case *BizErr:
+			if t != nil {
+				return *t
+			}
+		}
+	}
+	return nil
+}
+
+func (cheh *customHTTPErrorHandler) render(c echo.Context, code int, body errorBody) error {
+	if cheh.format != ErrorFormatProblem {
+		return c.JSON(code, body)
+	}
+
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(code),
+		Status:    code,
+		Instance:  c.Request().URL.Path,
+		Code:      body.Code,
+		RequestID: body.RequestID,
+		Errors:    body.Errors,
+	}
+	if body.Message != p.Title {
+		p.Detail = body.Message
+	}
+	if body.Code != "" && cheh.typeBase != "" {
+		p.Type = cheh.typeBase + body.Code
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, MIMEApplicationProblemJSON)
+	c.Response().WriteHeader(code)
+	return json.NewEncoder(c.Response()).Encode(p)
+}
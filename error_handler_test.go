@@ -24,12 +24,12 @@ func TestCustomHttpErrorHandler(t *testing.T) {
 	handle := NewCustomHTTPErrorHandler(e, func(format string, args ...interface{}) {})
 
 	cases := []tcase{
-		{err: errors.New("normal"), wantBody: []byte(`{"message":"Internal Server Error"}`), wantCode: 500},
-		{err: &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key y"}, wantBody: []byte(`{"message":"Duplicate entry 'x' for key y"}`), wantCode: 400},
-		{err: echo.NewHTTPError(404, "not found"), wantBody: []byte(`{"message":"not found"}`), wantCode: 404},
-		{err: ErrorArray{ErrorData{Field: "name", Title: "姓名", Errors: []string{"不能为空"}}}, wantBody: []byte(`{"message":"姓名: 不能为空"}`), wantCode: 400},
-		{err: NewFineErr(401, "unauth"), wantBody: []byte(`{"message":"unauth"}`), wantCode: 401},
-		{err: gorm.ErrRecordNotFound, wantBody: []byte(`{"message":"Not Found"}`), wantCode: 404},
+		{err: errors.New("normal"), wantBody: []byte(`{"code":"http.internal","message":"服务器内部错误"}`), wantCode: 500},
+		{err: &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key y"}, wantBody: []byte(`{"code":"db.duplicate","message":"数据已存在"}`), wantCode: 409},
+		{err: echo.NewHTTPError(404, "not found"), wantBody: []byte(`{"code":"http.not_found","message":"not found"}`), wantCode: 404},
+		{err: ErrorArray{ErrorData{Field: "name", Title: "姓名", Errors: []string{"不能为空"}}}, wantBody: []byte(`{"code":"http.bad_request","message":"姓名: 不能为空","errors":[{"field":"name","title":"姓名","errors":["不能为空"]}]}`), wantCode: 400},
+		{err: NewFineErr(401, "unauth"), wantBody: []byte(`{"code":"http.unauthorized","message":"unauth"}`), wantCode: 401},
+		{err: gorm.ErrRecordNotFound, wantBody: []byte(`{"code":"http.not_found","message":"资源不存在"}`), wantCode: 404},
 	}
 
 	for _, tc := range cases {
@@ -45,3 +45,36 @@ func TestCustomHttpErrorHandler(t *testing.T) {
 	}
 
 }
+
+func TestProblemErrorHandler(t *testing.T) {
+	e := echo.New()
+	handle := NewCustomHTTPErrorHandler(e, func(format string, args ...interface{}) {}, WithErrorFormat(ErrorFormatProblem), WithProblemTypeBase("https://example.com/errors/"))
+
+	cases := []struct {
+		err  error
+		want string
+		code int
+	}{
+		{errors.New("normal"), `{"type":"https://example.com/errors/http.internal","title":"Internal Server Error","status":500,"detail":"服务器内部错误","instance":"/admins","code":"http.internal","requestId":"r1"}`, 500},
+		{echo.NewHTTPError(404, "not found"), `{"type":"https://example.com/errors/http.not_found","title":"Not Found","status":404,"detail":"not found","instance":"/admins","code":"http.not_found","requestId":"r1"}`, 404},
+		{echo.NewHTTPError(401), `{"type":"https://example.com/errors/http.unauthorized","title":"Unauthorized","status":401,"detail":"未登录或登录已失效","instance":"/admins","code":"http.unauthorized","requestId":"r1"}`, 401},
+		{errTestTooLong.New("max", 5), `{"type":"https://example.com/errors/test.too_long","title":"Bad Request","status":400,"detail":"不能超过5个字","instance":"/admins","code":"test.too_long","requestId":"r1"}`, 400},
+		{
+			ErrorArray{ErrorData{Field: "name", Title: "姓名", Errors: []string{"不能为空"}}},
+			`{"type":"https://example.com/errors/http.bad_request","title":"Bad Request","status":400,"detail":"姓名: 不能为空","instance":"/admins","code":"http.bad_request","requestId":"r1","errors":[{"field":"name","title":"姓名","errors":["不能为空"]}]}`,
+			400,
+		},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/admins?page=1", nil)
+		rec := httptest.NewRecorder()
+		rec.Header().Set(echo.HeaderXRequestID, "r1")
+		c := e.NewContext(req, rec)
+
+		handle(tc.err, c)
+
+		assert.Equal(t, tc.code, rec.Code)
+		assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+		assert.JSONEq(t, tc.want, rec.Body.String())
+	}
+}
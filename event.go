@@ -0,0 +1,322 @@
+package quick
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"gorm.io/gorm"
+)
+
+type (
+	// Envelope 是事件信封，在payload之外携带事件的元数据
+	// 通过PublishEvent发布的事件以json编码的Envelope作为payload，
+	// 因此用Subscribe订阅的字符串回调仍然可以收到事件
+	Envelope struct {
+		ID          string    // 事件ID
+		Topic       string    // 主题
+		Time        time.Time // 发生时间
+		Source      string    // 来源模块
+		RequestID   string    // 产生事件的请求ID
+		TraceID     string    // 链路ID
+		Version     int       // 数据结构的版本
+		ContentType string    // Data的编码方式，对应Codec.ContentType
+		Data        []byte    // 编码后的事件数据
+	}
+
+	// Codec 是事件数据的编解码器
+	Codec interface {
+		ContentType() string
+		Marshal(v interface{}) ([]byte, error)
+		Unmarshal(data []byte, v interface{}) error
+	}
+
+	// EventOption 是发布事件时的选项
+	EventOption func(env *Envelope, codec *Codec)
+
+	// Publisher 是可以发布字符串事件的对象，Context和PubSub都实现了它
+	Publisher interface {
+		Publish(topic string, payload string) error
+	}
+
+	// Subscriber 是可以订阅字符串事件的对象，Context和PubSub都实现了它
+	Subscriber interface {
+		Subscribe(topic string, cb func(string), opts ...SubscribeOption) (Subscription, error)
+	}
+
+	// envelopeJSON 是Envelope的传输格式，json编码的数据直接内嵌，其他编码的数据用base64
+	envelopeJSON struct {
+		ID          string          `json:"id"`
+		Topic       string          `json:"topic"`
+		Time        time.Time       `json:"time"`
+		Source      string          `json:"source,omitempty"`
+		RequestID   string          `json:"requestId,omitempty"`
+		TraceID     string          `json:"traceId,omitempty"`
+		Version     int             `json:"version,omitempty"`
+		ContentType string          `json:"contentType"`
+		Data        json.RawMessage `json:"data,omitempty"`
+		DataBase64  []byte          `json:"dataBase64,omitempty"`
+	}
+
+	jsonCodec struct{}
+
+	requestIDKey struct{}
+	traceIDKey   struct{}
+)
+
+const (
+	// ContentTypeJSON 是json编码
+	ContentTypeJSON = "application/json"
+	// ContentTypeText 是未经编码的字符串，通过Publish发布的事件被解析为Envelope时使用
+	ContentTypeText = "text/plain"
+)
+
+var (
+	// JSONCodec 是json编解码器，PublishEvent默认使用
+	JSONCodec Codec = jsonCodec{}
+
+	codecMu sync.RWMutex
+	codecs  = map[string]Codec{ContentTypeJSON: JSONCodec}
+
+	envelopeType = reflect.TypeOf(Envelope{})
+)
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// RegisterCodec 注册编解码器，订阅方根据Envelope.ContentType找到对应的编解码器
+func RegisterCodec(c Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	codecs[c.ContentType()] = c
+}
+
+func lookupCodec(contentType string) (Codec, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	c, ok := codecs[contentType]
+	return c, ok
+}
+
+// WithRequestID 把请求ID放入ctx，HTTP请求的ctx中已由App设置
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom 从ctx中获取请求ID
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithTraceID 把链路ID放入ctx
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// TraceIDFrom 从ctx中获取链路ID
+func TraceIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// EventSource 设置来源模块
+func EventSource(source string) EventOption {
+	return func(env *Envelope, codec *Codec) {
+		env.Source = source
+	}
+}
+
+// EventVersion 设置数据结构版本
+func EventVersion(version int) EventOption {
+	return func(env *Envelope, codec *Codec) {
+		env.Version = version
+	}
+}
+
+// EventCodec 设置编解码器，需要先通过RegisterCodec注册，否则订阅方无法解码
+func EventCodec(c Codec) EventOption {
+	return func(env *Envelope, codec *Codec) {
+		*codec = c
+	}
+}
+
+// NewEnvelope 构造事件信封，请求ID和链路ID从ctx中获取
+func NewEnvelope(ctx context.Context, topic string, v interface{}, opts ...EventOption) (Envelope, error) {
+	env := Envelope{
+		ID:        xid.New().String(),
+		Topic:     topic,
+		Time:      time.Now(),
+		RequestID: RequestIDFrom(ctx),
+		TraceID:   TraceIDFrom(ctx),
+	}
+	codec := JSONCodec
+	for _, opt := range opts {
+		opt(&env, &codec)
+	}
+
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return env, err
+	}
+	env.ContentType = codec.ContentType()
+	env.Data = data
+	return env, nil
+}
+
+// Encode 编码成字符串payload
+func (env Envelope) Encode() (string, error) {
+	ej := envelopeJSON{
+		ID:          env.ID,
+		Topic:       env.Topic,
+		Time:        env.Time,
+		Source:      env.Source,
+		RequestID:   env.RequestID,
+		TraceID:     env.TraceID,
+		Version:     env.Version,
+		ContentType: env.ContentType,
+	}
+	if env.ContentType == ContentTypeJSON {
+		ej.Data = env.Data
+	} else {
+		ej.DataBase64 = env.Data
+	}
+	bs, err := json.Marshal(ej)
+	return string(bs), err
+}
+
+// Decode 把Data解码到v中
+// 对于通过Publish发布的字符串事件，v是*string时直接赋值，否则按json解码
+func (env Envelope) Decode(v interface{}) error {
+	if env.ContentType == ContentTypeText {
+		switch p := v.(type) {
+		case *string:
+			*p = string(env.Data)
+			return nil
+		case *[]byte:
+			*p = append((*p)[:0], env.Data...)
+			return nil
+		}
+		return json.Unmarshal(env.Data, v)
+	}
+
+	codec, ok := lookupCodec(env.ContentType)
+	if !ok {
+		return fmt.Errorf("codec not registered: %s", env.ContentType)
+	}
+	return codec.Unmarshal(env.Data, v)
+}
+
+// ParseEnvelope 解析payload
+// 不是Envelope的payload（即通过Publish发布的字符串事件）会被包装成ContentType为text/plain的Envelope
+func ParseEnvelope(topic string, payload string) Envelope {
+	var ej envelopeJSON
+	if err := json.Unmarshal([]byte(payload), &ej); err == nil && ej.ID != "" && ej.ContentType != "" && !ej.Time.IsZero() {
+		env := Envelope{
+			ID:          ej.ID,
+			Topic:       ej.Topic,
+			Time:        ej.Time,
+			Source:      ej.Source,
+			RequestID:   ej.RequestID,
+			TraceID:     ej.TraceID,
+			Version:     ej.Version,
+			ContentType: ej.ContentType,
+			Data:        ej.DataBase64,
+		}
+		if ej.ContentType == ContentTypeJSON {
+			env.Data = []byte(ej.Data)
+		}
+		return env
+	}
+	return Envelope{
+		Topic:       topic,
+		ContentType: ContentTypeText,
+		Data:        []byte(payload),
+	}
+}
+
+// PublishEvent 把v编码后包装成Envelope发布
+func PublishEvent(ctx context.Context, p Publisher, topic string, v interface{}, opts ...EventOption) error {
+	payload, err := encodeEvent(ctx, topic, v, opts...)
+	if err != nil {
+		return err
+	}
+	return p.Publish(topic, payload)
+}
+
+// PublishEventTx 和PublishEvent相同，但是通过发件箱在事务tx提交后投递
+func PublishEventTx(ctx context.Context, tx *gorm.DB, topic string, v interface{}, opts ...EventOption) error {
+	payload, err := encodeEvent(ctx, topic, v, opts...)
+	if err != nil {
+		return err
+	}
+	return PublishTx(tx, topic, payload)
+}
+
+func encodeEvent(ctx context.Context, topic string, v interface{}, opts ...EventOption) (string, error) {
+	env, err := NewEnvelope(ctx, topic, v, opts...)
+	if err != nil {
+		return "", err
+	}
+	return env.Encode()
+}
+
+// SubscribeEvent 订阅事件并把数据解码成fn参数的类型，fn可以是以下形式：
+//
+//	func(quick.Envelope)
+//	func(T)
+//	func(quick.Envelope, T)
+//
+// T可以是任意能被解码的类型或者其指针，fn不符合要求时panic，解码失败时和回调panic一样被记录到日志
+func SubscribeEvent(s Subscriber, topic string, fn interface{}, opts ...SubscribeOption) (Subscription, error) {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func || ft.NumOut() != 0 || ft.NumIn() < 1 || ft.NumIn() > 2 ||
+		(ft.NumIn() == 2 && ft.In(0) != envelopeType) {
+		panic(fmt.Sprintf("SubscribeEvent: unsupported fn %s", ft))
+	}
+
+	// 只有一个Envelope参数时直接传入信封
+	var dataType reflect.Type
+	if ft.NumIn() == 2 || ft.In(0) != envelopeType {
+		dataType = ft.In(ft.NumIn() - 1)
+	}
+
+	return s.Subscribe(topic, func(payload string) {
+		env := ParseEnvelope(topic, payload)
+		args := []reflect.Value{}
+		if dataType == nil || ft.NumIn() == 2 {
+			args = append(args, reflect.ValueOf(env))
+		}
+		if dataType != nil {
+			ptr := dataType.Kind() == reflect.Ptr
+			var dv reflect.Value
+			if ptr {
+				dv = reflect.New(dataType.Elem())
+			} else {
+				dv = reflect.New(dataType)
+			}
+			if err := env.Decode(dv.Interface()); err != nil {
+				panic(fmt.Errorf("SubscribeEvent decode %s: %w", topic, err))
+			}
+			if !ptr {
+				dv = dv.Elem()
+			}
+			args = append(args, dv)
+		}
+		fv.Call(args)
+	}, opts...)
+}
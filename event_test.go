@@ -0,0 +1,76 @@
+package quick
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type userCreated struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx := WithTraceID(WithRequestID(context.Background(), "req-1"), "trace-1")
+	env, err := NewEnvelope(ctx, "user.created", userCreated{ID: 1, Name: "n"}, EventSource("user"), EventVersion(2))
+	assert.Nil(t, err)
+
+	payload, err := env.Encode()
+	assert.Nil(t, err)
+	assert.Contains(t, payload, `"data":{"id":1,"name":"n"}`)
+
+	parsed := ParseEnvelope("user.created", payload)
+	assert.Equal(t, env.ID, parsed.ID)
+	assert.Equal(t, "user", parsed.Source)
+	assert.Equal(t, "req-1", parsed.RequestID)
+	assert.Equal(t, "trace-1", parsed.TraceID)
+	assert.Equal(t, 2, parsed.Version)
+	assert.True(t, env.Time.Equal(parsed.Time))
+
+	var uc userCreated
+	assert.Nil(t, parsed.Decode(&uc))
+	assert.Equal(t, userCreated{ID: 1, Name: "n"}, uc)
+
+	legacy := ParseEnvelope("t", "plain payload")
+	assert.Equal(t, ContentTypeText, legacy.ContentType)
+	var s string
+	assert.Nil(t, legacy.Decode(&s))
+	assert.Equal(t, "plain payload", s)
+}
+
+func TestSubscribeEvent(t *testing.T) {
+	logf := func(format string, args ...interface{}) {}
+	ps := newMemPubSub(logf)
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	SubscribeEvent(ps, "user.created", func(uc userCreated) {
+		assert.Equal(t, uint(1), uc.ID)
+		wg.Done()
+	})
+	SubscribeEvent(ps, "user.created", func(env Envelope, uc *userCreated) {
+		assert.Equal(t, "user", env.Source)
+		assert.Equal(t, "n", uc.Name)
+		wg.Done()
+	})
+	SubscribeEvent(ps, "user.created", func(env Envelope) {
+		assert.Equal(t, "user.created", env.Topic)
+		wg.Done()
+	})
+	ps.Subscribe("user.created", func(payload string) {
+		assert.Contains(t, payload, `"source":"user"`)
+		wg.Done()
+	})
+	assert.Panics(t, func() {
+		SubscribeEvent(ps, "user.created", func(a, b userCreated) {})
+	})
+
+	err := PublishEvent(context.Background(), ps, "user.created", userCreated{ID: 1, Name: "n"}, EventSource("user"))
+	assert.Nil(t, err)
+
+	wg.Wait()
+	ps.Close()
+}
@@ -0,0 +1,571 @@
+package quick
+
+import (
+	"encoding"
+	"encoding/json"
+	"html"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RouteDoc 是路由的文档，用于生成OpenAPI文档
+type RouteDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Request 是请求类型的值，比如 CreateAdminCmd{}
+	// GET、DELETE请求按query标签（没有时用json名）生成查询参数，其他请求生成JSON请求体，param标签的字段生成路径参数
+	Request interface{}
+	// Response 是成功响应的类型的值，为nil时只描述状态码
+	Response   interface{}
+	Deprecated bool
+}
+
+type (
+	// OpenAPIDoc 是OpenAPI 3文档
+	OpenAPIDoc struct {
+		OpenAPI    string                                  `json:"openapi"`
+		Info       OpenAPIInfo                             `json:"info"`
+		Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+		Components OpenAPIComponents                       `json:"components"`
+	}
+
+	// OpenAPIInfo 是文档的基本信息
+	OpenAPIInfo struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	}
+
+	// OpenAPIComponents 是可复用的定义，struct类型都定义在Schemas中
+	OpenAPIComponents struct {
+		Schemas map[string]*OpenAPISchema `json:"schemas,omitempty"`
+	}
+
+	// OpenAPIOperation 是一个路由
+	OpenAPIOperation struct {
+		Summary     string                      `json:"summary,omitempty"`
+		Description string                      `json:"description,omitempty"`
+		Tags        []string                    `json:"tags,omitempty"`
+		Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+		RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+		Responses   map[string]*OpenAPIResponse `json:"responses"`
+		Deprecated  bool                        `json:"deprecated,omitempty"`
+	}
+
+	// OpenAPIParameter 是路径参数或查询参数
+	OpenAPIParameter struct {
+		Name        string         `json:"name"`
+		In          string         `json:"in"`
+		Description string         `json:"description,omitempty"`
+		Required    bool           `json:"required,omitempty"`
+		Schema      *OpenAPISchema `json:"schema"`
+	}
+
+	// OpenAPIRequestBody 是请求体
+	OpenAPIRequestBody struct {
+		Required bool                         `json:"required"`
+		Content  map[string]*OpenAPIMediaType `json:"content"`
+	}
+
+	// OpenAPIResponse 是响应
+	OpenAPIResponse struct {
+		Description string                       `json:"description"`
+		Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+	}
+
+	// OpenAPIMediaType 是请求体或响应的内容
+	OpenAPIMediaType struct {
+		Schema *OpenAPISchema `json:"schema"`
+	}
+
+	// OpenAPISchema 是数据类型的定义
+	OpenAPISchema struct {
+		Ref                  string                    `json:"$ref,omitempty"`
+		Type                 string                    `json:"type,omitempty"`
+		Format               string                    `json:"format,omitempty"`
+		Description          string                    `json:"description,omitempty"`
+		Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+		Required             []string                  `json:"required,omitempty"`
+		Items                *OpenAPISchema            `json:"items,omitempty"`
+		AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+		AllOf                []*OpenAPISchema          `json:"allOf,omitempty"`
+		Enum                 []interface{}             `json:"enum,omitempty"`
+		Pattern              string                    `json:"pattern,omitempty"`
+		Minimum              *float64                  `json:"minimum,omitempty"`
+		Maximum              *float64                  `json:"maximum,omitempty"`
+		MinLength            *int                      `json:"minLength,omitempty"`
+		MaxLength            *int                      `json:"maxLength,omitempty"`
+		MinItems             *int                      `json:"minItems,omitempty"`
+		MaxItems             *int                      `json:"maxItems,omitempty"`
+		Nullable             bool                      `json:"nullable,omitempty"`
+	}
+)
+
+// routeEntry 是通过Route注册的路由
+type routeEntry struct {
+	method string
+	path   string
+	doc    RouteDoc
+}
+
+// openAPIRegistry 记录通过Route注册的路由，文档在第一次请求时生成
+type openAPIRegistry struct {
+	mu     sync.Mutex
+	routes []routeEntry
+	doc    []byte
+}
+
+func (r *openAPIRegistry) add(method, path string, doc RouteDoc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = append(r.routes, routeEntry{method: method, path: path, doc: doc})
+	r.doc = nil
+}
+
+func (r *openAPIRegistry) entries() []routeEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]routeEntry(nil), r.routes...)
+}
+
+// marshal 返回文档的JSON，路由没有变化时复用上次的结果
+func (r *openAPIRegistry) marshal(build func([]routeEntry) *OpenAPIDoc) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.doc != nil {
+		return r.doc, nil
+	}
+	b, err := json.Marshal(build(append([]routeEntry(nil), r.routes...)))
+	if err != nil {
+		return nil, err
+	}
+	r.doc = b
+	return b, nil
+}
+
+// buildOpenAPI 根据路由生成OpenAPI文档，errorSchema是错误响应的类型，比如errorBody{}或者Problem{}，errorMIME是它的Content-Type
+func buildOpenAPI(info OpenAPIInfo, routes []routeEntry, errorSchema interface{}, errorMIME string) *OpenAPIDoc {
+	b := newSchemaBuilder()
+	doc := &OpenAPIDoc{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   make(map[string]map[string]*OpenAPIOperation),
+	}
+	errRef := b.named(reflect.TypeOf(errorSchema), "Error")
+
+	for _, r := range routes {
+		path, pathParams := openAPIPath(r.path)
+		op := &OpenAPIOperation{
+			Summary:     r.doc.Summary,
+			Description: r.doc.Description,
+			Tags:        r.doc.Tags,
+			Deprecated:  r.doc.Deprecated,
+			Responses:   make(map[string]*OpenAPIResponse),
+		}
+
+		inPath := make(map[string]bool)
+		if r.doc.Request != nil {
+			t := reflect.TypeOf(r.doc.Request)
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			inQuery := r.method == http.MethodGet || r.method == http.MethodDelete || r.method == http.MethodHead
+			op.Parameters = b.parameters(t, inQuery, inPath)
+			if !inQuery {
+				op.RequestBody = &OpenAPIRequestBody{
+					Required: true,
+					Content:  map[string]*OpenAPIMediaType{echo.MIMEApplicationJSON: {Schema: b.schema(t)}},
+				}
+			}
+		}
+		// 请求类型中没有声明的路径参数
+		for _, name := range pathParams {
+			if !inPath[name] {
+				op.Parameters = append(op.Parameters, &OpenAPIParameter{Name: name, In: "path", Required: true, Schema: &OpenAPISchema{Type: "string"}})
+			}
+		}
+
+		ok := &OpenAPIResponse{Description: "OK"}
+		if r.doc.Response != nil {
+			ok.Content = map[string]*OpenAPIMediaType{echo.MIMEApplicationJSON: {Schema: b.schema(reflect.TypeOf(r.doc.Response))}}
+		}
+		op.Responses["200"] = ok
+		op.Responses["default"] = &OpenAPIResponse{
+			Description: "Error",
+			Content:     map[string]*OpenAPIMediaType{errorMIME: {Schema: errRef}},
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*OpenAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(r.method)] = op
+	}
+
+	doc.Components.Schemas = b.schemas
+	return doc
+}
+
+// OpenAPI 实现Context
+func (a *quickContext) OpenAPI() *OpenAPIDoc {
+	return a.buildOpenAPI(a.routes.entries())
+}
+
+func (a *quickContext) buildOpenAPI(routes []routeEntry) *OpenAPIDoc {
+	info := OpenAPIInfo{Title: a.config.OpenAPI.Title, Version: a.config.OpenAPI.Version}
+	if info.Title == "" {
+		info.Title = "API"
+	}
+	if info.Version == "" {
+		info.Version = "1.0.0"
+	}
+	if ErrorFormat(a.config.Error.Format) == ErrorFormatProblem {
+		return buildOpenAPI(info, routes, Problem{}, MIMEApplicationProblemJSON)
+	}
+	return buildOpenAPI(info, routes, errorBody{}, echo.MIMEApplicationJSON)
+}
+
+// serveOpenAPI 注册文档和文档页面的路由
+func (a *quickContext) serveOpenAPI() {
+	cfg := a.config.OpenAPI
+	path := cfg.Path
+	if path == "" {
+		path = "/pub/openapi.json"
+	}
+	a.e.GET(path, func(c echo.Context) error {
+		b, err := a.routes.marshal(a.buildOpenAPI)
+		if err != nil {
+			return err
+		}
+		return c.JSONBlob(http.StatusOK, b)
+	})
+
+	if cfg.UIPath != "" {
+		page := openAPIUI(a.buildOpenAPI(nil).Info.Title, path)
+		a.e.GET(cfg.UIPath, func(c echo.Context) error {
+			return c.HTML(http.StatusOK, page)
+		})
+	}
+}
+
+var echoParamRe = regexp.MustCompile(`:([^/]+)`)
+
+// openAPIPath 把echo的路径参数:id转成{id}，并返回参数名
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	for _, m := range echoParamRe.FindAllStringSubmatch(path, -1) {
+		params = append(params, m[1])
+	}
+	return echoParamRe.ReplaceAllString(path, "{$1}"), params
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	schemaNameRe      = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+)
+
+// schemaBuilder 把Go类型转成OpenAPISchema，具名struct放到components中引用
+type schemaBuilder struct {
+	schemas map[string]*OpenAPISchema
+	names   map[reflect.Type]string
+	types   map[string]reflect.Type
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{
+		schemas: make(map[string]*OpenAPISchema),
+		names:   make(map[reflect.Type]string),
+		types:   make(map[string]reflect.Type),
+	}
+}
+
+func refSchema(name string) *OpenAPISchema {
+	return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+// named 把struct类型t以name为名放到components中
+func (b *schemaBuilder) named(t reflect.Type, name string) *OpenAPISchema {
+	if name, ok := b.names[t]; ok {
+		return refSchema(name)
+	}
+	// 不同包中的同名类型加上包名区分
+	if other, ok := b.types[name]; ok && other != t {
+		pkg := t.PkgPath()
+		if i := strings.LastIndexByte(pkg, '/'); i >= 0 {
+			pkg = pkg[i+1:]
+		}
+		name = pkg + "." + name
+		for i := 2; b.types[name] != nil; i++ {
+			name = pkg + "." + t.Name() + strconv.Itoa(i)
+		}
+	}
+	name = schemaNameRe.ReplaceAllString(name, "_")
+	// 先登记名字再生成属性，递归的类型（比如菜单树）才能引用自己
+	b.names[t] = name
+	b.types[name] = t
+	b.schemas[name] = b.structSchema(t)
+	return refSchema(name)
+}
+
+// schema 返回类型t的定义
+func (b *schemaBuilder) schema(t reflect.Type) *OpenAPISchema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+
+	switch {
+	case t == timeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time", Nullable: nullable}
+	case t == rawMessageType:
+		return &OpenAPISchema{}
+	case t.Kind() != reflect.Struct && (t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType)):
+		// 自定义了序列化的类型无法推断结构
+		return &OpenAPISchema{}
+	case t.Kind() != reflect.Struct && (t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType)):
+		return &OpenAPISchema{Type: "string", Nullable: nullable}
+	}
+
+	var s *OpenAPISchema
+	switch t.Kind() {
+	case reflect.Bool:
+		s = &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		s = &OpenAPISchema{Type: "integer"}
+	case reflect.Int32, reflect.Uint32:
+		s = &OpenAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		s = &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		s = &OpenAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		s = &OpenAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		s = &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte序列化为base64
+			s = &OpenAPISchema{Type: "string", Format: "byte"}
+		} else {
+			s = &OpenAPISchema{Type: "array", Items: b.schema(t.Elem())}
+		}
+	case reflect.Map:
+		s = &OpenAPISchema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			s = b.structSchema(t)
+		} else {
+			// 引用不能有其他属性
+			return b.named(t, t.Name())
+		}
+	default:
+		s = &OpenAPISchema{}
+	}
+	s.Nullable = nullable
+	return s
+}
+
+// structSchema 按json标签生成struct的属性，title标签是属性的说明，validate标签生成约束
+func (b *schemaBuilder) structSchema(t reflect.Type) *OpenAPISchema {
+	s := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	eachField(t, func(sf reflect.StructField) {
+		jsonTag := sf.Tag.Get("json")
+		// 只有param标签的字段来自路径
+		if jsonTag == "-" || jsonTag == "" && sf.Tag.Get("param") != "" {
+			return
+		}
+		name := jsonFieldName(sf)
+		prop, required := b.fieldSchema(sf)
+		s.Properties[name] = prop
+		if required {
+			s.Required = append(s.Required, name)
+		}
+	})
+	return s
+}
+
+// fieldSchema 返回字段的定义以及是否必填
+func (b *schemaBuilder) fieldSchema(sf reflect.StructField) (*OpenAPISchema, bool) {
+	s := b.schema(sf.Type)
+	target := s
+	if s.Ref != "" {
+		// 引用的类型是共享的，只取是否必填
+		target = &OpenAPISchema{}
+	}
+	required := applyRules(target, sf.Tag.Get("validate"))
+	if title := sf.Tag.Get("title"); title != "" {
+		if s.Ref != "" {
+			s = &OpenAPISchema{AllOf: []*OpenAPISchema{s}}
+		}
+		s.Description = title
+	}
+	return s, required
+}
+
+// parameters 生成路径参数，inQuery为true时还生成查询参数，inPath记录生成的路径参数名
+func (b *schemaBuilder) parameters(t reflect.Type, inQuery bool, inPath map[string]bool) []*OpenAPIParameter {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var params []*OpenAPIParameter
+	eachField(t, func(sf reflect.StructField) {
+		p := &OpenAPIParameter{}
+		if name := sf.Tag.Get("param"); name != "" {
+			p.Name, p.In, p.Required = name, "path", true
+			inPath[name] = true
+		} else if !inQuery {
+			return
+		} else if p.Name = sf.Tag.Get("query"); p.Name == "" {
+			if p.Name = strings.Split(sf.Tag.Get("json"), ",")[0]; p.Name == "" {
+				p.Name = sf.Name
+			}
+			if p.Name == "-" {
+				return
+			}
+		}
+		if p.In == "" {
+			p.In = "query"
+		}
+
+		var required bool
+		p.Schema, required = b.fieldSchema(sf)
+		p.Required = p.Required || required
+		// 说明放在参数上
+		p.Description, p.Schema.Description = p.Schema.Description, ""
+		if len(p.Schema.AllOf) == 1 {
+			p.Schema = p.Schema.AllOf[0]
+		}
+		params = append(params, p)
+	})
+	return params
+}
+
+// eachField 按顺序遍历struct的导出字段，和json一样展开没有json标签的嵌入struct
+func eachField(t reflect.Type, fn func(sf reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Tag.Get("json") == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				eachField(ft, fn)
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		fn(sf)
+	}
+}
+
+// applyRules 把validate标签中的规则转成约束，返回是否必填
+func applyRules(s *OpenAPISchema, rule string) bool {
+	if rule == "" || rule == "-" {
+		return false
+	}
+	required := false
+	for _, r := range splitRules(rule) {
+		nameParam := strings.SplitN(r, "=", 2)
+		name, param := strings.TrimSpace(nameParam[0]), ""
+		if len(nameParam) > 1 {
+			param = strings.TrimSpace(strings.Replace(nameParam[1], `\,`, ",", -1))
+		}
+
+		switch name {
+		case "nonzero", "nonnil":
+			required = true
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				continue
+			}
+			applyBound(s, name, n)
+		case "regexp":
+			s.Pattern = param
+		case "enum":
+			for _, option := range strings.Split(param, "|") {
+				if s.Type == "integer" {
+					if n, err := strconv.ParseInt(option, 10, 64); err == nil {
+						s.Enum = append(s.Enum, n)
+						continue
+					}
+				}
+				s.Enum = append(s.Enum, option)
+			}
+		case "email":
+			s.Format = "email"
+		case "url":
+			s.Format = "uri"
+		case "mobile", "idcard", "uscc", "password":
+			s.Format = name
+		}
+	}
+	return required
+}
+
+// applyBound 按类型把min、max、len转成数值范围、长度或元素个数
+func applyBound(s *OpenAPISchema, name string, n float64) {
+	i := int(n)
+	switch s.Type {
+	case "integer", "number":
+		switch name {
+		case "min":
+			s.Minimum = &n
+		case "max":
+			s.Maximum = &n
+		case "len":
+			s.Minimum, s.Maximum = &n, &n
+		}
+	case "string":
+		switch name {
+		case "min":
+			s.MinLength = &i
+		case "max":
+			s.MaxLength = &i
+		case "len":
+			s.MinLength, s.MaxLength = &i, &i
+		}
+	case "array":
+		switch name {
+		case "min":
+			s.MinItems = &i
+		case "max":
+			s.MaxItems = &i
+		case "len":
+			s.MinItems, s.MaxItems = &i, &i
+		}
+	}
+}
+
+// openAPIUIPage 是从CDN加载swagger-ui的页面
+const openAPIUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{title}}</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>window.onload = function () { SwaggerUIBundle({url: {{url}}, dom_id: "#swagger-ui"}) }</script>
+</body>
+</html>`
+
+// openAPIUI 返回文档页面，docPath是文档的路径
+func openAPIUI(title, docPath string) string {
+	return strings.NewReplacer("{{title}}", html.EscapeString(title), "{{url}}", strconv.Quote(docPath)).Replace(openAPIUIPage)
+}
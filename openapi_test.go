@@ -0,0 +1,139 @@
+package quick
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type testDocAddress struct {
+	City string `json:"city" validate:"nonzero" title:"城市"`
+}
+
+type testDocBase struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type testDocNode struct {
+	Name     string         `json:"name"`
+	Children []*testDocNode `json:"children"`
+}
+
+type testDocCreateReq struct {
+	testDocBase
+	Name    string            `json:"name" validate:"nonzero,min=2,max=20" title:"姓名"`
+	Age     int               `json:"age" validate:"min=18" title:"年龄"`
+	Gender  string            `json:"gender" validate:"enum=male|female"`
+	Email   string            `json:"email,omitempty" validate:"email"`
+	Code    string            `json:"code" validate:"regexp=^[a-z]+$"`
+	Tags    []string          `json:"tags" validate:"max=3"`
+	Address *testDocAddress   `json:"address" title:"地址"`
+	Extra   map[string]string `json:"extra"`
+	Secret  string            `json:"-"`
+	TeamID  uint              `param:"team"`
+}
+
+type testDocQuery struct {
+	Page    int    `query:"page" title:"页码"`
+	Keyword string `json:"keyword" validate:"nonzero"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	app := New(Config{OpenAPI: OpenAPIConfig{Enable: true, UIPath: "/pub/docs", Title: "测试"}})
+	ac := app.Context()
+	noop := func(c echo.Context) error { return nil }
+	ac.Route(http.MethodPost, "/teams/:team/users", noop, RouteDoc{Summary: "创建用户", Tags: []string{"user"}, Request: testDocCreateReq{}, Response: testDocNode{}})
+	ac.Route(http.MethodGet, "/users", noop, RouteDoc{Summary: "查询用户", Request: &testDocQuery{}, Response: []testDocNode{}})
+
+	doc := ac.OpenAPI()
+	assert.Equal(t, "测试", doc.Info.Title)
+
+	op := doc.Paths["/teams/{team}/users"]["post"]
+	if assert.NotNil(t, op) {
+		assert.Equal(t, "创建用户", op.Summary)
+		if assert.Len(t, op.Parameters, 1) {
+			assert.Equal(t, OpenAPIParameter{Name: "team", In: "path", Required: true, Schema: &OpenAPISchema{Type: "integer"}}, *op.Parameters[0])
+		}
+		assert.Equal(t, "#/components/schemas/testDocCreateReq", op.RequestBody.Content[echo.MIMEApplicationJSON].Schema.Ref)
+		assert.Equal(t, "#/components/schemas/Error", op.Responses["default"].Content[echo.MIMEApplicationJSON].Schema.Ref)
+	}
+
+	req := doc.Components.Schemas["testDocCreateReq"]
+	if assert.NotNil(t, req) {
+		assert.Equal(t, []string{"name"}, req.Required)
+		assert.NotContains(t, req.Properties, "Secret")
+		assert.NotContains(t, req.Properties, "TeamID")
+		assert.Equal(t, &OpenAPISchema{Type: "string", Format: "date-time"}, req.Properties["createdAt"])
+
+		name := req.Properties["name"]
+		assert.Equal(t, "姓名", name.Description)
+		assert.Equal(t, 2, *name.MinLength)
+		assert.Equal(t, 20, *name.MaxLength)
+		assert.Equal(t, float64(18), *req.Properties["age"].Minimum)
+		assert.Equal(t, []interface{}{"male", "female"}, req.Properties["gender"].Enum)
+		assert.Equal(t, "email", req.Properties["email"].Format)
+		assert.Equal(t, "^[a-z]+$", req.Properties["code"].Pattern)
+		assert.Equal(t, 3, *req.Properties["tags"].MaxItems)
+		assert.Equal(t, "string", req.Properties["extra"].AdditionalProperties.Type)
+
+		address := req.Properties["address"]
+		assert.Equal(t, "地址", address.Description)
+		assert.Equal(t, "#/components/schemas/testDocAddress", address.AllOf[0].Ref)
+		assert.Equal(t, []string{"city"}, doc.Components.Schemas["testDocAddress"].Required)
+	}
+
+	// 递归的类型
+	node := doc.Components.Schemas["testDocNode"]
+	if assert.NotNil(t, node) {
+		assert.Equal(t, "#/components/schemas/testDocNode", node.Properties["children"].Items.Ref)
+	}
+
+	op = doc.Paths["/users"]["get"]
+	if assert.NotNil(t, op) {
+		assert.Nil(t, op.RequestBody)
+		if assert.Len(t, op.Parameters, 2) {
+			assert.Equal(t, OpenAPIParameter{Name: "page", In: "query", Description: "页码", Schema: &OpenAPISchema{Type: "integer"}}, *op.Parameters[0])
+			assert.Equal(t, OpenAPIParameter{Name: "keyword", In: "query", Required: true, Schema: &OpenAPISchema{Type: "string"}}, *op.Parameters[1])
+		}
+		assert.Equal(t, "#/components/schemas/testDocNode", op.Responses["200"].Content[echo.MIMEApplicationJSON].Schema.Items.Ref)
+	}
+
+	e := app.ac.e
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pub/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var served map[string]interface{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Equal(t, "3.0.3", served["openapi"])
+	assert.Contains(t, served["paths"], "/users")
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pub/docs", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.Contains(rec.Body.String(), `url: "/pub/openapi.json"`))
+}
+
+func TestOpenAPIProblemFormat(t *testing.T) {
+	app := New(Config{Error: ErrorConfig{Format: "problem"}})
+	app.Context().Route(http.MethodDelete, "/users/:id", func(c echo.Context) error { return nil }, RouteDoc{})
+
+	doc := app.Context().OpenAPI()
+	op := doc.Paths["/users/{id}"]["delete"]
+	if assert.NotNil(t, op) {
+		assert.Equal(t, "id", op.Parameters[0].Name)
+		assert.NotNil(t, op.Responses["default"].Content[MIMEApplicationProblemJSON])
+	}
+	assert.Contains(t, doc.Components.Schemas["Error"].Properties, "detail")
+
+	// 没有开启时不提供文档
+	rec := httptest.NewRecorder()
+	app.ac.e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pub/openapi.json", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
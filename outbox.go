@@ -0,0 +1,156 @@
+package quick
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxMessage 是发件箱表
+// 事件和业务数据在同一个事务中写入，事务提交后由relay按写入顺序投递到PubSub
+type OutboxMessage struct {
+	ID            uint       `gorm:"primaryKey"`
+	Topic         string     `gorm:"type:varchar(100);not null"`
+	Payload       string     `gorm:"type:text"`
+	Attempts      int        `gorm:"not null;default:0"`
+	LastError     string     `gorm:"type:varchar(500)"`
+	NextAttemptAt *time.Time // 投递失败后下次重试的时间
+	SentAt        *time.Time `gorm:"index"`
+	CreatedAt     time.Time
+}
+
+// TableName 指定表名
+func (OutboxMessage) TableName() string {
+	return "outbox"
+}
+
+// MigrateOutbox 创建发件箱表
+func MigrateOutbox(db *gorm.DB) error {
+	return db.AutoMigrate(OutboxMessage{})
+}
+
+// PublishTx 在事务tx中写入发件箱
+// 事务提交后事件才会被投递，事务回滚则事件也不会被投递
+func PublishTx(tx *gorm.DB, topic string, payload string) error {
+	return tx.Create(&OutboxMessage{Topic: topic, Payload: payload}).Error
+}
+
+// outboxRelay 把已提交的发件箱事件投递到PubSub
+// 投递失败时会阻塞后续事件直到重试成功，以保证顺序；多实例同时运行时可能重复投递
+type outboxRelay struct {
+	db        *gorm.DB
+	publish   func(topic string, payload string) error
+	interval  time.Duration
+	batchSize int
+	retention time.Duration
+	logf      Logf
+	purgedAt  time.Time
+	stop      chan struct{}
+	done      sync.WaitGroup
+}
+
+func newOutboxRelay(db *gorm.DB, ps PubSub, cfg Outbox, logf Logf) *outboxRelay {
+	r := &outboxRelay{
+		db:        db,
+		publish:   ps.Publish,
+		interval:  time.Duration(cfg.PollInterval) * time.Millisecond,
+		batchSize: cfg.BatchSize,
+		retention: time.Duration(cfg.Retention) * 24 * time.Hour,
+		logf:      logf,
+		stop:      make(chan struct{}),
+	}
+	if r.interval <= 0 {
+		r.interval = time.Second
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = 100
+	}
+	if r.retention <= 0 {
+		r.retention = 7 * 24 * time.Hour
+	}
+	return r
+}
+
+func (r *outboxRelay) start() {
+	r.done.Add(1)
+	go func() {
+		defer r.done.Done()
+		for {
+			n, err := r.relay()
+			if err != nil {
+				r.logf("[ERROR] Outbox Relay Failed: %s", err.Error())
+			}
+			if n >= r.batchSize {
+				// 积压时不等待间隔继续投递，但仍然响应关闭
+				select {
+				case <-r.stop:
+					return
+				default:
+				}
+				continue
+			}
+			r.purge()
+
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(r.interval):
+			}
+		}
+	}()
+}
+
+func (r *outboxRelay) close() {
+	close(r.stop)
+	r.done.Wait()
+}
+
+// relay 投递一批事件，返回成功投递的数量
+func (r *outboxRelay) relay() (int, error) {
+	var msgs []OutboxMessage
+	if err := r.db.Where("sent_at IS NULL").Order("id").Limit(r.batchSize).Find(&msgs).Error; err != nil {
+		return 0, err
+	}
+
+	for i, m := range msgs {
+		now := time.Now()
+		if m.NextAttemptAt != nil && m.NextAttemptAt.After(now) {
+			return i, nil
+		}
+
+		if err := r.publish(m.Topic, m.Payload); err != nil {
+			next := now.Add(taskBackoff(m.Attempts + 1))
+			if uerr := r.db.Model(OutboxMessage{}).Where("id = ?", m.ID).Updates(map[string]interface{}{
+				"attempts":        m.Attempts + 1,
+				"last_error":      truncate(err.Error(), 500),
+				"next_attempt_at": next,
+			}).Error; uerr != nil {
+				r.logf("[ERROR] Outbox Record Attempt Failed: %s, id=%d", uerr.Error(), m.ID)
+			}
+			return i, fmt.Errorf("outbox message %d: %w", m.ID, err)
+		}
+
+		res := r.db.Model(OutboxMessage{}).Where("id = ? AND sent_at IS NULL", m.ID).Updates(map[string]interface{}{
+			"attempts": m.Attempts + 1,
+			"sent_at":  now,
+		})
+		if res.Error != nil {
+			return i, res.Error
+		}
+	}
+	return len(msgs), nil
+}
+
+// purge 每小时清理一次超过保留期限的已投递事件
+func (r *outboxRelay) purge() {
+	now := time.Now()
+	if now.Sub(r.purgedAt) < time.Hour {
+		return
+	}
+	r.purgedAt = now
+	if err := r.db.Where("sent_at < ?", now.Add(-r.retention)).Delete(OutboxMessage{}).Error; err != nil {
+		r.logf("[ERROR] Outbox Purge Failed: %s", err.Error())
+	}
+}
@@ -0,0 +1,96 @@
+package quick
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestOutboxRelay(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, MigrateOutbox(db))
+
+	logf := func(format string, args ...interface{}) {}
+	relay := newOutboxRelay(db, newMemPubSub(logf), Outbox{}, logf)
+
+	var published []string
+	fail := true
+	relay.publish = func(topic string, payload string) error {
+		if payload == "p2" && fail {
+			return errors.New("broker down")
+		}
+		published = append(published, payload)
+		return nil
+	}
+
+	assert.Nil(t, PublishTx(db, "t", "p1"))
+	assert.Nil(t, PublishTx(db, "t", "p2"))
+	assert.Nil(t, PublishTx(db, "t", "p3"))
+	db.Transaction(func(tx *gorm.DB) error {
+		assert.Nil(t, PublishTx(tx, "t", "rollback"))
+		return errors.New("rollback")
+	})
+
+	// p2失败后不会越过它投递p3
+	n, err := relay.relay()
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"p1"}, published)
+
+	var m OutboxMessage
+	assert.Nil(t, db.Where("payload = ?", "p2").Take(&m).Error)
+	assert.Equal(t, 1, m.Attempts)
+	assert.Equal(t, "broker down", m.LastError)
+
+	// 重试时间未到
+	fail = false
+	n, err = relay.relay()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	past := time.Now().Add(-time.Second)
+	db.Model(OutboxMessage{}).Where("id = ?", m.ID).Update("next_attempt_at", past)
+	n, err = relay.relay()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"p1", "p2", "p3"}, published)
+
+	var unsent int64
+	db.Model(OutboxMessage{}).Where("sent_at IS NULL").Count(&unsent)
+	assert.Equal(t, int64(0), unsent)
+}
+
+func TestOutboxRelayCloseUnderLoad(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	sqlDB, err := db.DB()
+	assert.Nil(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	assert.Nil(t, MigrateOutbox(db))
+
+	logf := func(format string, args ...interface{}) {}
+	relay := newOutboxRelay(db, newMemPubSub(logf), Outbox{BatchSize: 1}, logf)
+	// 每投递一个事件就产生一个新事件，积压一直不会清空
+	relay.publish = func(topic string, payload string) error {
+		return PublishTx(db, topic, payload)
+	}
+	assert.Nil(t, PublishTx(db, "t", "p"))
+	relay.start()
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		relay.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close blocked by backlog")
+	}
+}
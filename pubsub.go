@@ -1,77 +1,430 @@
 package quick
 
 import (
+	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrPubSubClosed 表示PubSub已经关闭
+	ErrPubSubClosed = errors.New("pubsub closed")
 )
 
 type PubSub interface {
-	// 发布事件
-	Publish(topic string, payload string)
-	// 订阅事件
-	Subscribe(topic string, cb func(string))
+	// 发布事件，关闭后返回ErrPubSubClosed
+	Publish(topic string, payload string) error
+	// 订阅事件，topic可以包含通配符，详见topic.go
+	Subscribe(topic string, cb func(string), opts ...SubscribeOption) (Subscription, error)
+	// 和Subscribe相同，cb同时收到事件的实际主题，用于通配符订阅
+	SubscribeTopic(topic string, cb func(topic, payload string), opts ...SubscribeOption) (Subscription, error)
+	// 返回各订阅的统计信息
+	Stats() []SubscriptionStats
 	// 关闭
 	Close()
 }
 
+// OverflowPolicy 是订阅的缓冲区满时的处理策略
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞发布方直到缓冲区有空位
+	OverflowBlock OverflowPolicy = iota
+	// OverflowBlockTimeout 阻塞发布方，超时后丢弃该事件
+	OverflowBlockTimeout
+	// OverflowDropOldest 丢弃缓冲区中最早的事件
+	OverflowDropOldest
+	// OverflowDropNewest 丢弃正在发布的事件
+	OverflowDropNewest
+)
+
+type (
+	// Subscription 是订阅句柄
+	Subscription interface {
+		// Topic 返回订阅的主题
+		Topic() string
+		// Unsubscribe 取消订阅，等缓冲区中的事件处理完后停止回调goroutine
+		// 不能在该订阅自己的回调中调用，否则会一直等待
+		Unsubscribe()
+	}
+
+	// SubscribeOption 是订阅选项
+	SubscribeOption func(o *subscribeOptions)
+
+	subscribeOptions struct {
+		buffer      int
+		policy      OverflowPolicy
+		timeout     time.Duration
+		concurrency int
+		name        string
+		retry       int
+		backoff     time.Duration
+	}
+
+	// SubscriptionStats 是订阅的统计信息
+	SubscriptionStats struct {
+		Topic     string `json:"topic"`
+		Buffered  int    `json:"buffered"`  // 缓冲区中待处理的事件数
+		Delivered uint64 `json:"delivered"` // 已放入缓冲区的事件数
+		Dropped   uint64 `json:"dropped"`   // 因缓冲区满被丢弃的事件数
+	}
+)
+
+// WithBuffer 设置缓冲区大小，默认8，溢出策略是丢弃时最小为1
+func WithBuffer(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.buffer = n
+	}
+}
+
+// WithOverflow 设置缓冲区满时的处理策略，默认OverflowBlock
+func WithOverflow(policy OverflowPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.policy = policy
+	}
+}
+
+// WithBlockTimeout 缓冲区满时最多阻塞d，超时后丢弃事件
+func WithBlockTimeout(d time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.policy = OverflowBlockTimeout
+		o.timeout = d
+	}
+}
+
+// WithConcurrency 设置同时执行回调的goroutine数，默认1，大于1时不保证处理顺序
+func WithConcurrency(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.concurrency = n
+	}
+}
+
+// WithName 设置订阅者名，用于记录和重新处理死信，默认为"主题#序号"
+// 多实例部署时各实例的同一订阅者需要同名
+func WithName(name string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.name = name
+	}
+}
+
+// WithRetry 设置回调失败后最多重试max次，第n次重试前等待backoff*2^(n-1)
+// 只对Context.SubscribeE和Context.Subscribe生效，重试用尽后事件写入死信
+func WithRetry(max int, backoff time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.retry = max
+		o.backoff = backoff
+	}
+}
+
+func newSubscribeOptions(opts []SubscribeOption) subscribeOptions {
+	o := subscribeOptions{buffer: 8, policy: OverflowBlock, concurrency: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.buffer < 0 {
+		o.buffer = 0
+	}
+	// 丢弃策略需要缓冲区才能判断是否溢出，没有缓冲区时每个事件都会被丢弃
+	if o.buffer == 0 && (o.policy == OverflowDropNewest || o.policy == OverflowDropOldest) {
+		o.buffer = 1
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+	if o.policy == OverflowBlockTimeout && o.timeout <= 0 {
+		o.timeout = time.Second
+	}
+	if o.retry < 0 {
+		o.retry = 0
+	}
+	if o.backoff <= 0 {
+		o.backoff = 100 * time.Millisecond
+	}
+	return o
+}
+
+// message 是放入订阅缓冲区的事件
+type message struct {
+	topic   string
+	payload string
+}
+
+// subscription 是一个订阅者，事件先放入缓冲区，再由若干goroutine执行回调
+type subscription struct {
+	topic     string
+	detach    func(s *subscription) // 从PubSub中移除该订阅
+	cb        func(topic, payload string)
+	opts      subscribeOptions
+	ch        chan message
+	quit      chan struct{}
+	stopOnce  sync.Once
+	done      sync.WaitGroup
+	delivered uint64
+	dropped   uint64
+}
+
+func newSubscription(topic string, cb func(topic, payload string), opts subscribeOptions) *subscription {
+	s := &subscription{
+		topic: topic,
+		cb:    cb,
+		opts:  opts,
+		ch:    make(chan message, opts.buffer),
+		quit:  make(chan struct{}),
+	}
+	for i := 0; i < opts.concurrency; i++ {
+		s.done.Add(1)
+		go s.work()
+	}
+	return s
+}
+
+func (s *subscription) work() {
+	defer s.done.Done()
+	for {
+		select {
+		case m := <-s.ch:
+			s.cb(m.topic, m.payload)
+		case <-s.quit:
+			// 处理完缓冲区中剩余的事件再退出
+			for {
+				select {
+				case m := <-s.ch:
+					s.cb(m.topic, m.payload)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver 按溢出策略把主题为topic的事件放入缓冲区，订阅已停止时返回ErrPubSubClosed
+func (s *subscription) deliver(topic, payload string) error {
+	m := message{topic: topic, payload: payload}
+	select {
+	case <-s.quit:
+		return ErrPubSubClosed
+	default:
+	}
+
+	switch s.opts.policy {
+	case OverflowBlockTimeout:
+		timer := time.NewTimer(s.opts.timeout)
+		defer timer.Stop()
+		select {
+		case s.ch <- m:
+		case <-s.quit:
+			return ErrPubSubClosed
+		case <-timer.C:
+			atomic.AddUint64(&s.dropped, 1)
+			return nil
+		}
+	case OverflowDropNewest:
+		select {
+		case s.ch <- m:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			return nil
+		}
+	case OverflowDropOldest:
+		for sent := false; !sent; {
+			select {
+			case s.ch <- m:
+				sent = true
+			case <-s.quit:
+				return ErrPubSubClosed
+			default:
+				select {
+				case <-s.ch:
+					atomic.AddUint64(&s.dropped, 1)
+				case <-s.quit:
+					return ErrPubSubClosed
+				default:
+				}
+			}
+		}
+	default:
+		select {
+		case s.ch <- m:
+		case <-s.quit:
+			return ErrPubSubClosed
+		}
+	}
+	atomic.AddUint64(&s.delivered, 1)
+	return nil
+}
+
+// stop 停止接收事件，并等待缓冲区中的事件处理完
+func (s *subscription) stop() {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+	})
+	s.done.Wait()
+}
+
+// Topic 实现Subscription
+func (s *subscription) Topic() string {
+	return s.topic
+}
+
+// Unsubscribe 实现Subscription
+func (s *subscription) Unsubscribe() {
+	if s.detach != nil {
+		s.detach(s)
+	}
+	s.stop()
+}
+
+func (s *subscription) stats() SubscriptionStats {
+	return SubscriptionStats{
+		Topic:     s.topic,
+		Buffered:  len(s.ch),
+		Delivered: atomic.LoadUint64(&s.delivered),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+	}
+}
+
 func newMemPubSub(logf Logf) PubSub {
 	return &memPubSub{
-		done:        sync.WaitGroup{},
 		mu:          sync.RWMutex{},
-		subscribers: make(map[string][]chan string),
+		subscribers: make(map[string][]*subscription),
 		logf:        logf,
 	}
 }
 
 type memPubSub struct {
-	done        sync.WaitGroup
 	mu          sync.RWMutex
-	subscribers map[string][]chan string
+	closed      bool
+	subscribers map[string][]*subscription // 不含通配符的订阅
+	wildcards   []*subscription            // 含通配符的订阅
 	logf        Logf
 }
 
-func (ps *memPubSub) Publish(topic string, payload string) {
+// Publish 发布事件，不持有锁等待订阅者，因此慢订阅者只会阻塞发布方自己
+func (ps *memPubSub) Publish(topic string, payload string) error {
 	ps.mu.RLock()
-	defer ps.mu.RUnlock()
+	if ps.closed {
+		ps.mu.RUnlock()
+		return ErrPubSubClosed
+	}
+	subs := append([]*subscription(nil), ps.subscribers[topic]...)
+	for _, s := range ps.wildcards {
+		if MatchTopic(s.topic, topic) {
+			subs = append(subs, s)
+		}
+	}
+	ps.mu.RUnlock()
 
-	if cs, ok := ps.subscribers[topic]; ok {
-		for _, c := range cs {
-			c <- payload
+	for _, s := range subs {
+		if err := s.deliver(topic, payload); err == ErrPubSubClosed && ps.isClosed() {
+			return err
 		}
 	}
+	return nil
 }
 
-func wf(logf Logf, cb func(string)) func(string) {
-	return func(s string) {
+func (ps *memPubSub) isClosed() bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.closed
+}
+
+func wf(logf Logf, cb func(topic, payload string)) func(topic, payload string) {
+	return func(topic, payload string) {
 		defer func() {
 			if err := recover(); err != nil {
 				logf("Subscriber Triggered Error: %#v", err)
 			}
 		}()
-		cb(s)
+		cb(topic, payload)
+	}
+}
+
+// payloadOnly 把只接收事件内容的回调转换成SubscribeTopic的回调
+func payloadOnly(cb func(string)) func(topic, payload string) {
+	return func(topic, payload string) {
+		cb(payload)
 	}
 }
 
-func (ps *memPubSub) Subscribe(topic string, cb func(string)) {
+func (ps *memPubSub) Subscribe(topic string, cb func(string), opts ...SubscribeOption) (Subscription, error) {
+	return ps.SubscribeTopic(topic, payloadOnly(cb), opts...)
+}
+
+func (ps *memPubSub) SubscribeTopic(topic string, cb func(topic, payload string), opts ...SubscribeOption) (Subscription, error) {
+	if err := ValidateTopic(topic); err != nil {
+		return nil, err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.closed {
+		return nil, ErrPubSubClosed
+	}
+	s := newSubscription(topic, wf(ps.logf, cb), newSubscribeOptions(opts))
+	s.detach = ps.detach
+	if isWildcardTopic(topic) {
+		ps.wildcards = append(ps.wildcards, s)
+	} else {
+		ps.subscribers[topic] = append(ps.subscribers[topic], s)
+	}
+	return s, nil
+}
+
+func (ps *memPubSub) detach(s *subscription) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 
-	c := make(chan string, 8)
-	ps.subscribers[topic] = append(ps.subscribers[topic], c)
+	ps.wildcards = removeSubscription(ps.wildcards, s)
+	if subs := removeSubscription(ps.subscribers[s.topic], s); len(subs) > 0 {
+		ps.subscribers[s.topic] = subs
+	} else {
+		delete(ps.subscribers, s.topic)
+	}
+}
 
-	ps.done.Add(1)
-	go func(_cb func(string)) {
-		defer ps.done.Done()
-		for payload := range c {
-			_cb(payload)
+func removeSubscription(subs []*subscription, s *subscription) []*subscription {
+	for i, v := range subs {
+		if v == s {
+			return append(subs[:i:i], subs[i+1:]...)
 		}
-	}(wf(ps.logf, cb))
+	}
+	return subs
+}
+
+func (ps *memPubSub) Stats() []SubscriptionStats {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	var stats []SubscriptionStats
+	for _, subs := range ps.subscribers {
+		for _, s := range subs {
+			stats = append(stats, s.stats())
+		}
+	}
+	for _, s := range ps.wildcards {
+		stats = append(stats, s.stats())
+	}
+	return stats
 }
 
 func (ps *memPubSub) Close() {
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		return
+	}
+	ps.closed = true
+	var subs []*subscription
 	for _, v := range ps.subscribers {
-		for _, c := range v {
-			close(c)
-		}
+		subs = append(subs, v...)
+	}
+	subs = append(subs, ps.wildcards...)
+	ps.mu.Unlock()
+
+	for _, s := range subs {
+		s.stop()
 	}
-	ps.done.Wait()
 }
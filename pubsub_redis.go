@@ -0,0 +1,621 @@
+package quick
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// redisStreamRefresh 是stream模式下发现新主题和认领未确认事件的间隔
+const redisStreamRefresh = 5 * time.Second
+
+// newRedisPubSub 构造redis实现的PubSub
+// broadcast模式使用redis的PUBLISH/SUBSCRIBE，每个实例的订阅者都会收到事件，实例离线期间的事件会丢失
+// stream模式每个主题使用一个redis stream，同名订阅在各实例中属于同一个消费者组，竞争消费，每个事件只会被其中一个实例处理
+func newRedisPubSub(client *redis.Client, cfg PubSubConfig, logf Logf) PubSub {
+	consumer := cfg.Consumer
+	if consumer == "" {
+		host, _ := os.Hostname()
+		consumer = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	group := cfg.Group
+	if group == "" {
+		group = "quick"
+	}
+	maxLen := cfg.MaxLen
+	if maxLen <= 0 {
+		maxLen = 10000
+	}
+	claimIdle := time.Duration(cfg.ClaimIdle) * time.Second
+	if claimIdle <= 0 {
+		claimIdle = time.Minute
+	}
+	return &redisPubSub{
+		client:    client,
+		stream:    cfg.Mode == "stream",
+		prefix:    cfg.Prefix,
+		group:     group,
+		consumer:  consumer,
+		maxLen:    maxLen,
+		claimIdle: claimIdle,
+		logf:      logf,
+		groups:    make(map[string]bool),
+	}
+}
+
+type redisPubSub struct {
+	mu         sync.Mutex
+	client     *redis.Client
+	stream     bool
+	prefix     string
+	group      string
+	consumer   string
+	maxLen     int64
+	claimIdle  time.Duration
+	logf       Logf
+	closed     bool
+	groups     map[string]bool // stream模式下本实例已使用的消费者组，同名订阅只能有一个
+	subs       []*redisSubscription
+	registered sync.Map // stream模式下已经登记到主题集合的主题
+}
+
+// redisSubscription 是redis实现中的一个订阅
+// broadcast模式下收到的事件按订阅选项放入本地缓冲区；stream模式下stream本身就是缓冲区，
+// 只有WithConcurrency生效，处理完事件后才确认
+type redisSubscription struct {
+	ps        *redisPubSub
+	topic     string
+	group     string
+	consumers []string
+	pubsub    *redis.PubSub
+	local     *subscription
+	quit      chan struct{}
+	stopOnce  sync.Once
+	done      sync.WaitGroup
+	delivered uint64
+
+	mu        sync.Mutex
+	streams   []string                     // 订阅的stream，通配符订阅时随新主题的出现增加
+	acked     map[string]map[string]string // stream -> 消费者 -> 最后确认的事件ID，""是创建消费者组时的起点
+	refreshed time.Time
+}
+
+// Topic 实现Subscription
+func (rs *redisSubscription) Topic() string {
+	return rs.topic
+}
+
+// Unsubscribe 实现Subscription
+// stream模式下删除本实例没有未确认事件的消费者，消费者组中没有消费者时删除消费者组
+func (rs *redisSubscription) Unsubscribe() {
+	rs.ps.mu.Lock()
+	for i, v := range rs.ps.subs {
+		if v == rs {
+			rs.ps.subs = append(rs.ps.subs[:i:i], rs.ps.subs[i+1:]...)
+			break
+		}
+	}
+	delete(rs.ps.groups, rs.group)
+	rs.ps.mu.Unlock()
+	rs.stop()
+
+	if rs.ps.stream {
+		for _, stream := range rs.streamKeys() {
+			rs.ps.leaveGroup(stream, rs.group, rs.consumers)
+		}
+	}
+}
+
+// stop 先停止本地缓冲区，阻塞在投递上的读取goroutine才能退出
+func (rs *redisSubscription) stop() {
+	rs.stopOnce.Do(func() {
+		close(rs.quit)
+		if rs.pubsub != nil {
+			if err := rs.pubsub.Close(); err != nil {
+				rs.ps.logf("[ERROR] PubSub Close Failed: %s", err.Error())
+			}
+		}
+	})
+	if rs.local != nil {
+		rs.local.stop()
+	}
+	rs.done.Wait()
+}
+
+func (rs *redisSubscription) stats() SubscriptionStats {
+	if rs.local != nil {
+		return rs.local.stats()
+	}
+	return SubscriptionStats{Topic: rs.topic, Delivered: atomic.LoadUint64(&rs.delivered)}
+}
+
+func (rs *redisSubscription) streamKeys() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]string(nil), rs.streams...)
+}
+
+// addStream 记录订阅的stream和消费者组的起点，调用时需要持有锁
+func (rs *redisSubscription) addStream(stream, start string) {
+	rs.streams = append(rs.streams, stream)
+	rs.acked[stream] = map[string]string{"": start}
+}
+
+// ack 记录消费者最后确认的事件ID
+func (rs *redisSubscription) ack(stream, consumer, id string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if m := rs.acked[stream]; m != nil {
+		m[consumer] = id
+	}
+}
+
+// resumeID 返回消费者组被删除后重新创建的起点，即各消费者最后确认的事件ID中最小的一个
+// 起点之后的事件都会重新投递，可能重复但不会丢失
+func (rs *redisSubscription) resumeID(stream string) string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	m := rs.acked[stream]
+	if m == nil {
+		return "0"
+	}
+	resume := ""
+	for _, consumer := range rs.consumers {
+		id, ok := m[consumer]
+		if !ok {
+			id = m[""]
+		}
+		if resume == "" || streamIDLess(id, resume) {
+			resume = id
+		}
+	}
+	if resume == "" {
+		return m[""]
+	}
+	return resume
+}
+
+// streamIDLess 比较两个stream事件ID，ID的格式是 毫秒时间戳-序号
+func streamIDLess(a, b string) bool {
+	am, as := parseStreamID(a)
+	bm, bs := parseStreamID(b)
+	if am != bm {
+		return am < bm
+	}
+	return as < bs
+}
+
+func parseStreamID(id string) (ms, seq uint64) {
+	i := strings.IndexByte(id, '-')
+	if i < 0 {
+		ms, _ = strconv.ParseUint(id, 10, 64)
+		return ms, 0
+	}
+	ms, _ = strconv.ParseUint(id[:i], 10, 64)
+	seq, _ = strconv.ParseUint(id[i+1:], 10, 64)
+	return ms, seq
+}
+
+func (rs *redisSubscription) hasStream(stream string) bool {
+	for _, s := range rs.streams {
+		if s == stream {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh 通配符订阅从主题集合中发现新的主题并创建消费者组，每隔redisStreamRefresh执行一次
+// 返回是否执行了刷新
+func (rs *redisSubscription) refresh() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if time.Since(rs.refreshed) < redisStreamRefresh {
+		return false
+	}
+	rs.refreshed = time.Now()
+	if !isWildcardTopic(rs.topic) {
+		return true
+	}
+
+	topics, err := rs.ps.client.SMembers(rs.ps.topicsKey()).Result()
+	if err != nil {
+		rs.ps.logf("[ERROR] PubSub Load Topics Failed: %s", err.Error())
+		return true
+	}
+	for _, topic := range topics {
+		stream := rs.ps.streamKey(topic)
+		if !MatchTopic(rs.topic, topic) || rs.hasStream(stream) {
+			continue
+		}
+		// 订阅后才出现的主题从头读取，不会漏掉发现之前发布的事件
+		if err := rs.ps.createGroup(stream, rs.group, "0"); err != nil {
+			rs.ps.logf("[ERROR] PubSub Create Group Failed: %s, group=%s stream=%s", err.Error(), rs.group, stream)
+			continue
+		}
+		rs.addStream(stream, "0")
+	}
+	return true
+}
+
+// redisGlobEscaper 转义redis模式订阅的特殊字符
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// redisPattern 把通配符主题转换成redis的模式，*和>都转换成*，再在本地精确匹配
+func redisPattern(prefix, topic string) string {
+	segs := strings.Split(topic, ".")
+	for i, seg := range segs {
+		if seg == "*" || seg == ">" {
+			segs[i] = "*"
+		} else {
+			segs[i] = redisGlobEscaper.Replace(seg)
+		}
+	}
+	return redisGlobEscaper.Replace(prefix) + strings.Join(segs, ".")
+}
+
+func (ps *redisPubSub) streamKey(topic string) string {
+	return ps.prefix + "stream:" + topic
+}
+
+// topicsKey 是stream模式下记录所有主题的集合，用于通配符订阅发现主题
+func (ps *redisPubSub) topicsKey() string {
+	return ps.prefix + "stream-topics"
+}
+
+// lastStreamID 返回stream中最后一个事件的ID，stream为空时返回"0"
+// 订阅时从这里创建消费者组，和"$"一样只消费订阅之后的事件，同时记下了起点
+func (ps *redisPubSub) lastStreamID(stream string) (string, error) {
+	msgs, err := ps.client.XRevRangeN(stream, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(msgs) == 0 {
+		return "0", nil
+	}
+	return msgs[0].ID, nil
+}
+
+func (ps *redisPubSub) createGroup(stream, group, start string) error {
+	err := ps.client.XGroupCreateMkStream(stream, group, start).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+func (ps *redisPubSub) Publish(topic string, payload string) error {
+	ps.mu.Lock()
+	closed := ps.closed
+	ps.mu.Unlock()
+	if closed {
+		return ErrPubSubClosed
+	}
+
+	if !ps.stream {
+		return ps.client.Publish(ps.prefix+topic, payload).Err()
+	}
+
+	pipe := ps.client.Pipeline()
+	pipe.XAdd(&redis.XAddArgs{
+		Stream:       ps.streamKey(topic),
+		MaxLenApprox: ps.maxLen,
+		Values:       map[string]interface{}{"topic": topic, "payload": payload},
+	})
+	_, registered := ps.registered.Load(topic)
+	if !registered {
+		pipe.SAdd(ps.topicsKey(), topic)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return err
+	}
+	if !registered {
+		ps.registered.Store(topic, true)
+	}
+	return nil
+}
+
+// Subscribe 实现PubSub
+func (ps *redisPubSub) Subscribe(topic string, cb func(string), opts ...SubscribeOption) (Subscription, error) {
+	return ps.SubscribeTopic(topic, payloadOnly(cb), opts...)
+}
+
+// SubscribeTopic 实现PubSub
+// stream模式下消费者组名由WithName设置的订阅者名决定，没有设置时使用主题，
+// 各实例的同一订阅需要同名，本实例中同一主题的多个订阅需要通过WithName区分
+func (ps *redisPubSub) SubscribeTopic(topic string, cb func(topic, payload string), opts ...SubscribeOption) (Subscription, error) {
+	if err := ValidateTopic(topic); err != nil {
+		return nil, err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.closed {
+		return nil, ErrPubSubClosed
+	}
+
+	o := newSubscribeOptions(opts)
+	cb = wf(ps.logf, cb)
+	rs := &redisSubscription{ps: ps, topic: topic, quit: make(chan struct{})}
+	if !ps.stream {
+		// 通配符订阅转换成redis的模式订阅，再在本地精确匹配
+		wildcard := isWildcardTopic(topic)
+		if wildcard {
+			rs.pubsub = ps.client.PSubscribe(redisPattern(ps.prefix, topic))
+		} else {
+			rs.pubsub = ps.client.Subscribe(ps.prefix + topic)
+		}
+		rs.local = newSubscription(topic, cb, o)
+		ps.subs = append(ps.subs, rs)
+		rs.done.Add(1)
+		go func() {
+			defer rs.done.Done()
+			for msg := range rs.pubsub.Channel() {
+				t := strings.TrimPrefix(msg.Channel, ps.prefix)
+				if wildcard && !MatchTopic(topic, t) {
+					continue
+				}
+				if err := rs.local.deliver(t, msg.Payload); err != nil {
+					return
+				}
+			}
+		}()
+		return rs, nil
+	}
+
+	name := o.name
+	if name == "" {
+		name = topic
+	}
+	rs.group = ps.group + ":" + name
+	if ps.groups[rs.group] {
+		return nil, errors.New("pubsub: duplicate subscriber name " + name + ", use WithName to distinguish subscriptions of the same topic")
+	}
+
+	var streams []string
+	if isWildcardTopic(topic) {
+		topics, err := ps.client.SMembers(ps.topicsKey()).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range topics {
+			if MatchTopic(topic, t) {
+				streams = append(streams, ps.streamKey(t))
+			}
+		}
+	} else {
+		streams = []string{ps.streamKey(topic)}
+	}
+	rs.acked = make(map[string]map[string]string)
+	for _, stream := range streams {
+		start, err := ps.lastStreamID(stream)
+		if err != nil {
+			return nil, err
+		}
+		if err := ps.createGroup(stream, rs.group, start); err != nil {
+			return nil, err
+		}
+		rs.addStream(stream, start)
+	}
+	ps.groups[rs.group] = true
+	ps.subs = append(ps.subs, rs)
+
+	for i := 0; i < o.concurrency; i++ {
+		consumer := ps.consumer
+		if i > 0 {
+			consumer = fmt.Sprintf("%s-%d", ps.consumer, i)
+		}
+		rs.consumers = append(rs.consumers, consumer)
+	}
+	for _, consumer := range rs.consumers {
+		consumer := consumer
+		rs.done.Add(1)
+		go func() {
+			defer rs.done.Done()
+			ps.consume(rs, consumer, func(topic, payload string) {
+				cb(topic, payload)
+				atomic.AddUint64(&rs.delivered, 1)
+			})
+		}()
+	}
+	return rs, nil
+}
+
+func (ps *redisPubSub) Stats() []SubscriptionStats {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	stats := make([]SubscriptionStats, len(ps.subs))
+	for i, rs := range ps.subs {
+		stats[i] = rs.stats()
+	}
+	return stats
+}
+
+// consume 先处理本消费者之前未确认的事件，再读取新事件
+// 每隔redisStreamRefresh发现新主题，并认领其他消费者超过claimIdle未确认的事件，比如重启前的实例留下的事件
+func (ps *redisPubSub) consume(rs *redisSubscription, consumer string, cb func(topic, payload string)) {
+	pending := true
+	for {
+		select {
+		case <-rs.quit:
+			return
+		default:
+		}
+
+		if rs.refresh() || pending {
+			for _, stream := range rs.streamKeys() {
+				if ps.claim(stream, rs.group, consumer) > 0 {
+					pending = true
+				}
+			}
+		}
+
+		streams := rs.streamKeys()
+		if len(streams) == 0 {
+			select {
+			case <-rs.quit:
+				return
+			case <-time.After(redisStreamRefresh):
+			}
+			continue
+		}
+		id := ">"
+		if pending {
+			id = "0"
+		}
+		args := make([]string, 0, len(streams)*2)
+		args = append(args, streams...)
+		for range streams {
+			args = append(args, id)
+		}
+
+		res, err := ps.client.XReadGroup(&redis.XReadGroupArgs{
+			Group:    rs.group,
+			Consumer: consumer,
+			Streams:  args,
+			Count:    10,
+			Block:    2 * time.Second,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			ps.logf("[ERROR] PubSub Read Group Failed: %s, group=%s", err.Error(), rs.group)
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				// 消费者组被其他实例取消订阅时删除，从最后确认的事件之后重新创建，期间发布的事件不会丢失
+				for _, stream := range streams {
+					if err := ps.createGroup(stream, rs.group, rs.resumeID(stream)); err != nil {
+						ps.logf("[ERROR] PubSub Create Group Failed: %s, group=%s stream=%s", err.Error(), rs.group, stream)
+					}
+				}
+			}
+			select {
+			case <-rs.quit:
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		count := 0
+		for _, stream := range res {
+			for _, msg := range stream.Messages {
+				count++
+				if t, _ := msg.Values["topic"].(string); MatchTopic(rs.topic, t) {
+					payload, _ := msg.Values["payload"].(string)
+					cb(t, payload)
+				}
+				if err := ps.client.XAck(stream.Stream, rs.group, msg.ID).Err(); err != nil {
+					ps.logf("[ERROR] PubSub Ack Failed: %s, group=%s id=%s", err.Error(), rs.group, msg.ID)
+					continue
+				}
+				rs.ack(stream.Stream, consumer, msg.ID)
+			}
+		}
+		if pending && count == 0 {
+			pending = false
+		}
+	}
+}
+
+// claim 把其他消费者超过claimIdle未确认的事件转给consumer，返回认领的数量
+func (ps *redisPubSub) claim(stream, group, consumer string) int {
+	entries, err := ps.client.XPendingExt(&redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Start:  "-",
+		End:    "+",
+		Count:  100,
+	}).Result()
+	if err != nil {
+		if err != redis.Nil {
+			ps.logf("[ERROR] PubSub Pending Failed: %s, group=%s stream=%s", err.Error(), group, stream)
+		}
+		return 0
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if e.Consumer != consumer && e.Idle >= ps.claimIdle {
+			ids = append(ids, e.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return 0
+	}
+	claimed, err := ps.client.XClaimJustID(&redis.XClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  ps.claimIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		ps.logf("[ERROR] PubSub Claim Failed: %s, group=%s stream=%s", err.Error(), group, stream)
+		return 0
+	}
+	return len(claimed)
+}
+
+// leaveGroup 删除没有未确认事件的消费者，有未确认事件的消费者保留给其他实例认领
+// 消费者组中没有消费者时删除消费者组
+func (ps *redisPubSub) leaveGroup(stream, group string, consumers []string) {
+	for _, consumer := range consumers {
+		pending, err := ps.client.XPendingExt(&redis.XPendingExtArgs{
+			Stream:   stream,
+			Group:    group,
+			Start:    "-",
+			End:      "+",
+			Count:    1,
+			Consumer: consumer,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			ps.logf("[ERROR] PubSub Pending Failed: %s, group=%s stream=%s", err.Error(), group, stream)
+			return
+		}
+		if len(pending) > 0 {
+			continue
+		}
+		if err := ps.client.XGroupDelConsumer(stream, group, consumer).Err(); err != nil {
+			ps.logf("[ERROR] PubSub Delete Consumer Failed: %s, group=%s stream=%s", err.Error(), group, stream)
+		}
+	}
+
+	groups, err := ps.client.XInfoGroups(stream).Result()
+	if err != nil {
+		ps.logf("[ERROR] PubSub Info Groups Failed: %s, stream=%s", err.Error(), stream)
+		return
+	}
+	for _, g := range groups {
+		if g.Name == group && g.Consumers == 0 && g.Pending == 0 {
+			if err := ps.client.XGroupDestroy(stream, group).Err(); err != nil {
+				ps.logf("[ERROR] PubSub Destroy Group Failed: %s, group=%s stream=%s", err.Error(), group, stream)
+			}
+		}
+	}
+}
+
+func (ps *redisPubSub) Close() {
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		return
+	}
+	ps.closed = true
+	subs := ps.subs
+	ps.subs = nil
+	ps.mu.Unlock()
+
+	for _, rs := range subs {
+		rs.stop()
+	}
+}
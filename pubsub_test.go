@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,3 +32,206 @@ func TestMemPubSub(t *testing.T) {
 	wg.Wait()
 	ps.Close()
 }
+
+func TestRedisPubSub(t *testing.T) {
+	// 需要启动redis
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	if err := client.Ping().Err(); err != nil {
+		t.Skip("redis not available: " + err.Error())
+	}
+
+	logf := func(format string, args ...interface{}) {}
+	for _, mode := range []string{"broadcast", "stream"} {
+		ps := newRedisPubSub(client, PubSubConfig{Mode: mode, Prefix: "quick-test:"}, logf)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		ps.Subscribe("topic1", func(s string) {
+			assert.Equal(t, "payload1", s)
+			wg.Done()
+		}, WithName("s1"))
+		ps.Subscribe("topic1", func(s string) {
+			assert.Equal(t, "payload1", s)
+			wg.Done()
+		}, WithName("s2"))
+		time.Sleep(100 * time.Millisecond)
+		ps.Publish("topic2", "payload2")
+		ps.Publish("topic1", "payload1")
+
+		wg.Wait()
+		ps.Close()
+	}
+	client.Del("quick-test:stream:topic1", "quick-test:stream:topic2", "quick-test:stream-topics")
+}
+
+func TestRedisPubSubGroupDeleted(t *testing.T) {
+	// 需要启动redis
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	if err := client.Ping().Err(); err != nil {
+		t.Skip("redis not available: " + err.Error())
+	}
+	defer client.Del("quick-test:stream:topic3", "quick-test:stream-topics")
+
+	logf := func(format string, args ...interface{}) {}
+	ps := newRedisPubSub(client, PubSubConfig{Mode: "stream", Prefix: "quick-test:"}, logf)
+	defer ps.Close()
+
+	received := make(chan string, 10)
+	_, err := ps.Subscribe("topic3", func(s string) { received <- s })
+	assert.Nil(t, err)
+	assert.Nil(t, ps.Publish("topic3", "1"))
+	assert.Equal(t, "1", <-received)
+
+	// 消费者组被删除期间发布的事件在重新创建消费者组后仍然投递
+	assert.Nil(t, client.XGroupDestroy("quick-test:stream:topic3", "quick:topic3").Err())
+	assert.Nil(t, ps.Publish("topic3", "2"))
+	assert.Nil(t, ps.Publish("topic3", "3"))
+	for _, want := range []string{"2", "3"} {
+		select {
+		case got := <-received:
+			assert.Equal(t, want, got)
+		case <-time.After(10 * time.Second):
+			t.Fatal("event published while the group was deleted is lost")
+		}
+	}
+}
+
+func TestRedisSubscriptionResumeID(t *testing.T) {
+	rs := &redisSubscription{consumers: []string{"c", "c-1"}, acked: make(map[string]map[string]string)}
+	assert.Equal(t, "0", rs.resumeID("s"))
+	rs.addStream("s", "1000-0")
+	assert.Equal(t, "1000-0", rs.resumeID("s"))
+	rs.ack("s", "c", "1002-3")
+	assert.Equal(t, "1000-0", rs.resumeID("s"))
+	rs.ack("s", "c-1", "1002-12")
+	assert.Equal(t, "1002-3", rs.resumeID("s"))
+	assert.True(t, streamIDLess("0", "1-0"))
+	assert.True(t, streamIDLess("9-5", "10-0"))
+}
+
+func TestRedisPattern(t *testing.T) {
+	assert.Equal(t, "p:admin.*", redisPattern("p:", "admin.>"))
+	assert.Equal(t, `p\*:a\?b\[c\].*.x`, redisPattern("p*:", "a?b[c].*.x"))
+}
+
+func TestMemPubSubOverflow(t *testing.T) {
+	logf := func(format string, args ...interface{}) {}
+	ps := newMemPubSub(logf)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var got []string
+	cb := func(s string) {
+		<-release
+		mu.Lock()
+		got = append(got, s)
+		mu.Unlock()
+	}
+	ps.Subscribe("newest", cb, WithBuffer(1), WithOverflow(OverflowDropNewest))
+	ps.Subscribe("oldest", cb, WithBuffer(1), WithOverflow(OverflowDropOldest))
+	ps.Subscribe("timeout", cb, WithBuffer(1), WithBlockTimeout(10*time.Millisecond))
+
+	// 第1个事件被回调取走阻塞，第2个进入缓冲区，第3个溢出
+	for _, topic := range []string{"newest", "oldest", "timeout"} {
+		for _, p := range []string{"1", "2", "3"} {
+			assert.Nil(t, ps.Publish(topic, topic+p))
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	for _, st := range ps.Stats() {
+		assert.Equal(t, uint64(1), st.Dropped, st.Topic)
+	}
+
+	close(release)
+	ps.Close()
+	assert.ElementsMatch(t, []string{"newest1", "newest2", "oldest1", "oldest3", "timeout1", "timeout2"}, got)
+	assert.Equal(t, ErrPubSubClosed, ps.Publish("newest", "4"))
+}
+
+func TestSubscriptionDropWithoutBuffer(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDropNewest, OverflowDropOldest} {
+		opts := newSubscribeOptions([]SubscribeOption{WithBuffer(0), WithOverflow(policy)})
+		assert.Equal(t, 1, opts.buffer)
+
+		release := make(chan struct{})
+		s := newSubscription("t", func(topic, payload string) { <-release }, opts)
+		// 回调阻塞时发布不会一直空转等待消费者
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 3; i++ {
+				assert.Nil(t, s.deliver("t", "p"))
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("deliver blocked without a buffer")
+		}
+		assert.True(t, s.stats().Dropped > 0)
+
+		close(release)
+		s.stop()
+		assert.Equal(t, ErrPubSubClosed, s.deliver("t", "p"))
+	}
+}
+
+func TestMemPubSubConcurrency(t *testing.T) {
+	logf := func(format string, args ...interface{}) {}
+	ps := newMemPubSub(logf)
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	ps.Subscribe("topic1", func(s string) {
+		time.Sleep(200 * time.Millisecond)
+		wg.Done()
+	}, WithConcurrency(4))
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, ps.Publish("topic1", "payload"))
+	}
+	wg.Wait()
+	assert.True(t, time.Since(start) < 400*time.Millisecond)
+	ps.Close()
+}
+
+func TestMemPubSubWildcardAndUnsubscribe(t *testing.T) {
+	logf := func(format string, args ...interface{}) {}
+	ps := newMemPubSub(logf)
+
+	var mu sync.Mutex
+	got := map[string][]string{}
+	record := func(name string) func(string) {
+		return func(s string) {
+			mu.Lock()
+			got[name] = append(got[name], s)
+			mu.Unlock()
+		}
+	}
+
+	one, err := ps.Subscribe("admin.*", record("one"))
+	assert.Nil(t, err)
+	_, err = ps.Subscribe("admin.>", record("all"))
+	assert.Nil(t, err)
+	exact, err := ps.Subscribe("admin.created", record("exact"))
+	assert.Nil(t, err)
+	assert.Equal(t, "admin.created", exact.Topic())
+	_, err = ps.Subscribe("admin.>.x", record("bad"))
+	assert.Equal(t, ErrInvalidTopic, err)
+
+	assert.Nil(t, ps.Publish("admin.created", "p1"))
+	assert.Nil(t, ps.Publish("admin.role.created", "p2"))
+
+	// 取消订阅会先处理完已投递的事件
+	one.Unsubscribe()
+	exact.Unsubscribe()
+	assert.Nil(t, ps.Publish("admin.created", "p3"))
+	assert.Equal(t, 1, len(ps.Stats()))
+
+	ps.Close()
+	assert.Equal(t, []string{"p1"}, got["one"])
+	assert.Equal(t, []string{"p1"}, got["exact"])
+	assert.Equal(t, []string{"p1", "p2", "p3"}, got["all"])
+}
@@ -0,0 +1,204 @@
+package quick
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Frame 是调用栈中的一帧
+type Frame struct {
+	File     string
+	Line     int
+	Function string
+}
+
+// Stack 是调用栈，第一帧是错误的构造位置
+type Stack []Frame
+
+// StackTracer 是记录了调用栈的错误，FineErr和BizErr实现了它
+type StackTracer interface {
+	StackTrace() Stack
+}
+
+const maxStackDepth = 32
+
+var (
+	captureStack int32
+	helpers      sync.Map // 函数名 => struct{}
+)
+
+// SetCaptureStack 设置构造FineErr、BizErr时是否记录完整调用栈，默认只记录构造位置一帧
+// 记录完整调用栈开销较大，一般只在排查问题时打开
+// 错误的构造不依赖App，因此这是进程级的设置，对所有App生效，应该在main中构造App之前设置一次
+func SetCaptureStack(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&captureStack, v)
+}
+
+// Helper 把调用它的函数标记为构造错误的辅助函数，记录错误的构造位置时会跳过它，类似testing.T.Helper
+//
+//	func notFound(what string) error {
+//		quick.Helper()
+//		return quick.NewFineErr(http.StatusNotFound, what+"不存在")
+//	}
+func Helper() {
+	pc, _, _, ok := runtime.Caller(1)
+	if !ok {
+		return
+	}
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		helpers.LoadOrStore(fn.Name(), struct{}{})
+	}
+}
+
+func isHelper(function string) bool {
+	_, ok := helpers.Load(function)
+	return ok
+}
+
+// callers 返回调用栈，跳过callers自身、调用它的构造函数以及所有辅助函数
+// 没有打开SetCaptureStack时只返回第一帧
+func callers() Stack {
+	pc := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+
+	full := atomic.LoadInt32(&captureStack) == 1
+	var stack Stack
+	for {
+		frame, more := frames.Next()
+		if len(stack) > 0 || !isHelper(frame.Function) {
+			stack = append(stack, Frame{File: frame.File, Line: frame.Line, Function: frame.Function})
+			if !full {
+				break
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// String 返回 file:line function
+func (f Frame) String() string {
+	return fmt.Sprintf("%s:%d %s", f.File, f.Line, f.Function)
+}
+
+// short 返回 file.go:line，file只保留文件名
+func (f Frame) short() string {
+	file := f.File
+	if i := strings.LastIndexByte(file, '/'); i >= 0 {
+		file = file[i+1:]
+	}
+	return fmt.Sprintf("%s:%d", file, f.Line)
+}
+
+// Top 返回第一帧，即错误的构造位置
+func (s Stack) Top() Frame {
+	if len(s) == 0 {
+		return Frame{}
+	}
+	return s[0]
+}
+
+// errorCode 返回err自身的错误码，用于计算指纹
+func errorCode(err error) string {
+	switch t := err.(type) {
+	case *FineErr:
+		return fmt.Sprintf("%d %s", t.Code, t.Message)
+	case BizErr:
+		return t.code
+	case *BizErr:
+		return t.code
+	case *ErrCode:
+		return t.Code
+	case *CodeErr:
+		return t.ErrCode.Code
+	}
+	return ""
+}
+
+// Fingerprint 返回err的指纹，用于错误分组
+// 指纹由错误链中每个错误的类型、错误码和构造位置（函数名，不含行号）计算，不包含可能带有变量的消息，
+// 因此同一处代码返回的同一种错误指纹相同；链中都是普通错误时末端错误的消息也参与计算
+func Fingerprint(err error) string {
+	if err == nil {
+		return ""
+	}
+	h := sha1.New()
+	known := false
+	var last error
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		code := errorCode(e)
+		fmt.Fprintf(h, "%T|%s", e, code)
+		if st, ok := e.(StackTracer); ok {
+			fmt.Fprintf(h, "|%s", st.StackTrace().Top().Function)
+			known = true
+		}
+		if code != "" {
+			known = true
+		}
+		h.Write([]byte{'\n'})
+		last = e
+	}
+	// 链中没有错误码和构造位置时只能通过消息区分
+	if !known {
+		h.Write([]byte(last.Error()))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// FormatChain 把错误链格式化成多行文本用于日志，首行是完整消息，之后每行是一个错误的类型和它自身的消息，记录了调用栈的错误附加调用栈
+//
+//	login admin: account_locked: locked by admin
+//	  [0] *quick.wrapErr: login admin
+//	  [1] quick.BizErr: account_locked
+//	      at /app/service.go:42 main.(*Service).Login
+//	  [2] *errors.errorString: locked by admin
+func FormatChain(err error) string {
+	if err == nil {
+		return ""
+	}
+	// 单个没有调用栈的错误只返回消息
+	if _, ok := err.(StackTracer); !ok && errors.Unwrap(err) == nil {
+		return err.Error()
+	}
+	var b strings.Builder
+	b.WriteString(err.Error())
+	i := 0
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		msg := e.Error()
+		if next := errors.Unwrap(e); next != nil {
+			msg = strings.TrimSuffix(msg, ": "+next.Error())
+		}
+		fmt.Fprintf(&b, "\n  [%d] %T: %s", i, e, msg)
+		if st, ok := e.(StackTracer); ok {
+			for _, f := range st.StackTrace() {
+				fmt.Fprintf(&b, "\n      at %s", f)
+			}
+		}
+		i++
+	}
+	return b.String()
+}
+
+// stackCaller 返回错误链中第一个记录了调用栈的错误的构造位置，格式为 file.go:line
+func stackCaller(err error) string {
+	var st StackTracer
+	if errors.As(err, &st) {
+		if s := st.StackTrace(); len(s) > 0 {
+			return s.Top().short()
+		}
+	}
+	return ""
+}
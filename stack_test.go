@@ -0,0 +1,71 @@
+package quick
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testNotFound(what string) *FineErr {
+	Helper()
+	return NewFineErr(http.StatusNotFound, what+"不存在")
+}
+
+func testLocked(id int) error {
+	Helper()
+	return errTestAccountLocked.Wrap(fmt.Errorf("account %d locked", id))
+}
+
+func TestCaller(t *testing.T) {
+	fe := testNotFound("订单")
+	assert.True(t, strings.HasSuffix(fe.Caller, "quick.TestCaller"), fe.Caller)
+	assert.Len(t, fe.StackTrace(), 1)
+
+	fe = TraceError(errors.New("boom"))
+	assert.True(t, strings.HasSuffix(fe.Caller, "quick.TestCaller"), fe.Caller)
+
+	_, _, fn := errTestQuota.Wrap(nil).Caller()
+	assert.Equal(t, "github.com/hiwjd/quick.TestCaller", fn)
+
+	var be BizErr
+	assert.True(t, errors.As(testLocked(1), &be))
+	_, _, fn = be.Caller()
+	assert.Equal(t, "github.com/hiwjd/quick.TestCaller", fn)
+	assert.True(t, strings.HasPrefix(stackCaller(Wrap(be, "login")), "stack_test.go:"))
+
+	SetCaptureStack(true)
+	defer SetCaptureStack(false)
+	st := testNotFound("订单").StackTrace()
+	assert.True(t, len(st) > 1)
+	assert.Equal(t, "github.com/hiwjd/quick.TestCaller", st[0].Function)
+}
+
+func TestFingerprint(t *testing.T) {
+	var fps []string
+	for i := 0; i < 2; i++ {
+		fps = append(fps, Fingerprint(Wrapf(testLocked(i), "login %d", i)))
+	}
+	assert.Equal(t, fps[0], fps[1])
+	assert.Len(t, fps[0], 16)
+	assert.NotEqual(t, fps[0], Fingerprint(testLocked(1)))
+	assert.NotEqual(t, Fingerprint(errors.New("a")), Fingerprint(errors.New("b")))
+	assert.Equal(t, "", Fingerprint(nil))
+}
+
+func TestFormatChain(t *testing.T) {
+	assert.Equal(t, "boom", FormatChain(errors.New("boom")))
+
+	err := Wrap(errTestAccountLocked.Wrap(errors.New("locked by admin")), "login admin")
+	lines := strings.Split(FormatChain(err), "\n")
+	if assert.Len(t, lines, 5) {
+		assert.Equal(t, "login admin: account_locked: locked by admin", lines[0])
+		assert.Equal(t, "  [0] *quick.wrapErr: login admin", lines[1])
+		assert.Equal(t, "  [1] quick.BizErr: account_locked", lines[2])
+		assert.True(t, strings.HasSuffix(lines[3], " github.com/hiwjd/quick.TestFormatChain"), lines[3])
+		assert.Equal(t, "  [2] *errors.errorString: locked by admin", lines[4])
+	}
+}
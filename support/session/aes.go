@@ -0,0 +1,238 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hiwjd/quick/util"
+	"github.com/rs/xid"
+)
+
+var (
+	// ErrInvalidToken 表示key格式错误、密钥不存在或者解密失败
+	ErrInvalidToken = errors.New("无效的会话")
+	// ErrSessionRevoked 表示会话已经通过Del撤销
+	ErrSessionRevoked = errors.New("会话已撤销")
+)
+
+// Reissuer 是通过换发key延长会话的Storage
+// 比如AesStorage的过期时间加密在key中，RefreshTTL不能延长它，只能换发新的key
+type Reissuer interface {
+	// Reissue 校验key后换发有效期为ttl的新key，新旧key属于同一个会话，Del任意一个都会撤销整个会话
+	// 不需要换发时返回原key
+	Reissue(key string, ttl time.Duration) (newKey string, err error)
+}
+
+// AesKey 是带key id的aes密钥
+type AesKey struct {
+	ID  string // 不能包含.
+	AES *util.SimpleAES
+}
+
+// AesOption 是AesStorage的选项
+type AesOption func(s *AesStorage)
+
+// WithRevocationList 设置撤销列表，设置后Del才会使key失效
+func WithRevocationList(rl RevocationList) AesOption {
+	return func(s *AesStorage) {
+		s.revoked = rl
+	}
+}
+
+// AesStorage 是用aes加密用户信息的方式做会话存储的一种实现
+// key的格式是 key id + "." + 密文，密文中包含会话ID、签发时间、过期时间和会话数据
+// 也兼容升级前没有key id前缀的旧格式key，旧格式key使用key id为0的密钥解密，没有过期时间
+type AesStorage struct {
+	keys    []AesKey
+	revoked RevocationList
+	now     func() time.Time
+}
+
+// aesPayload 是加密的内容
+type aesPayload struct {
+	ID        string          `json:"jti"`
+	IssuedAt  int64           `json:"iat"`
+	ExpiresAt int64           `json:"exp,omitempty"` // 0表示不会失效
+	Data      json.RawMessage `json:"data"`
+}
+
+// NewAesStorage 构造AesStorage，saes的key id为0
+func NewAesStorage(saes *util.SimpleAES, opts ...AesOption) *AesStorage {
+	s, _ := NewAesKeyStorage([]AesKey{{ID: "0", AES: saes}}, opts...)
+	return s
+}
+
+// NewAesKeyStorage 构造使用多个密钥的AesStorage，用于轮换密钥
+// keys[0]用于加密，所有密钥都可以解密，轮换时把新密钥放在最前面，旧密钥在旧key都过期后再移除
+func NewAesKeyStorage(keys []AesKey, opts ...AesOption) (*AesStorage, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("至少需要一个密钥")
+	}
+	ids := make(map[string]bool)
+	for _, k := range keys {
+		if k.AES == nil || strings.Contains(k.ID, ".") || ids[k.ID] {
+			return nil, errors.New("无效或重复的key id: " + k.ID)
+		}
+		ids[k.ID] = true
+	}
+
+	s := &AesStorage{
+		keys: keys,
+		now:  time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// Get 实现Storage，过期的key返回ErrSessionExpired，撤销的key返回ErrSessionRevoked
+func (s *AesStorage) Get(key string, session interface{}) error {
+	p, err := s.open(key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(p.Data, session)
+}
+
+// Set 实现Storage
+func (s *AesStorage) Set(session interface{}, ttl time.Duration) (key string, err error) {
+	var bs []byte
+	if bs, err = json.Marshal(session); err != nil {
+		return
+	}
+
+	return s.seal(aesPayload{ID: xid.New().String(), Data: bs}, ttl)
+}
+
+// RefreshTTL 实现Storage，过期时间在key中不能修改，需要延长会话时使用Reissue
+func (s *AesStorage) RefreshTTL(key string, ttl time.Duration) error {
+	return nil
+}
+
+// Del 实现Storage，把会话加入撤销列表，没有设置撤销列表时什么也不做
+func (s *AesStorage) Del(key string) error {
+	if s.revoked == nil {
+		return nil
+	}
+	p, err := s.open(key)
+	if err != nil {
+		if err == ErrSessionExpired || err == ErrSessionRevoked {
+			return nil
+		}
+		return err
+	}
+
+	var until time.Time
+	if p.ExpiresAt > 0 {
+		until = time.Unix(p.ExpiresAt, 0)
+	}
+	return s.revoked.Revoke(p.ID, until)
+}
+
+// Reissue 实现Reissuer，剩余有效期超过ttl的一半并且使用当前密钥加密时返回原key，避免每次请求都换发
+func (s *AesStorage) Reissue(key string, ttl time.Duration) (newKey string, err error) {
+	p, err := s.open(key)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(key, s.keys[0].ID+".") {
+		if ttl == 0 && p.ExpiresAt == 0 {
+			return key, nil
+		}
+		if ttl > 0 && p.ExpiresAt > 0 && time.Unix(p.ExpiresAt, 0).Sub(s.now()) > ttl/2 {
+			return key, nil
+		}
+	}
+	return s.seal(p, ttl)
+}
+
+// seal 用当前密钥加密p，签发时间和过期时间按ttl重新设置
+func (s *AesStorage) seal(p aesPayload, ttl time.Duration) (string, error) {
+	now := s.now()
+	p.IssuedAt = now.Unix()
+	p.ExpiresAt = 0
+	if ttl > 0 {
+		p.ExpiresAt = now.Add(ttl).Unix()
+	}
+
+	bs, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	k := s.keys[0]
+	sealed, err := k.AES.Enc(bs)
+	if err != nil {
+		return "", err
+	}
+	return k.ID + "." + sealed, nil
+}
+
+// legacyKeyID 是旧格式key使用的密钥的key id，即NewAesStorage的密钥
+const legacyKeyID = "0"
+
+func (s *AesStorage) key(kid string) *util.SimpleAES {
+	for _, k := range s.keys {
+		if k.ID == kid {
+			return k.AES
+		}
+	}
+	return nil
+}
+
+// openLegacy 解密旧格式的key，旧格式是没有key id前缀的会话数据密文，没有过期时间
+// 兼容升级前签发的key，避免升级后所有用户都需要重新登录，Reissue会把它换发成新格式
+func (s *AesStorage) openLegacy(key string) (p aesPayload, err error) {
+	saes := s.key(legacyKeyID)
+	if saes == nil {
+		return p, ErrInvalidToken
+	}
+	bs, err := saes.Dec(key)
+	if err != nil || !json.Valid(bs) {
+		return p, ErrInvalidToken
+	}
+	// 旧格式没有会话ID，用key的哈希代替，以便Del可以撤销
+	// 截取到30个字符以内，和RevokedToken.TokenID的列宽一致
+	return aesPayload{ID: "legacy-" + SessionID(key)[:23], Data: bs}, nil
+}
+
+// open 解密key并校验过期时间和撤销列表
+func (s *AesStorage) open(key string) (p aesPayload, err error) {
+	i := strings.IndexByte(key, '.')
+	if i < 0 {
+		// base64url编码的旧格式key不包含.
+		if p, err = s.openLegacy(key); err != nil {
+			return p, err
+		}
+	} else {
+		saes := s.key(key[:i])
+		if saes == nil {
+			return p, ErrInvalidToken
+		}
+		bs, err := saes.Dec(key[i+1:])
+		if err != nil {
+			return p, ErrInvalidToken
+		}
+		if err = json.Unmarshal(bs, &p); err != nil || p.ID == "" {
+			return p, ErrInvalidToken
+		}
+	}
+
+	if p.ExpiresAt > 0 && s.now().Unix() >= p.ExpiresAt {
+		return p, ErrSessionExpired
+	}
+	if s.revoked != nil {
+		revoked, err := s.revoked.IsRevoked(p.ID)
+		if err != nil {
+			return p, err
+		}
+		if revoked {
+			return p, ErrSessionRevoked
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,142 @@
+package session
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hiwjd/quick/util"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestAES(t *testing.T, key string) *util.SimpleAES {
+	saes, err := util.NewSimpleAES([]byte(key))
+	assert.Nil(t, err)
+	return saes
+}
+
+func TestAesStorageExpire(t *testing.T) {
+	storage := NewAesStorage(newTestAES(t, "1234567812345678"))
+	now := time.Now()
+	storage.now = func() time.Time { return now }
+
+	key, err := storage.Set(&fakeSession{ID: 1}, time.Minute)
+	assert.Nil(t, err)
+	permanent, err := storage.Set(&fakeSession{ID: 2}, 0)
+	assert.Nil(t, err)
+
+	// 剩余有效期超过一半时不换发
+	newKey, err := storage.Reissue(key, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, key, newKey)
+
+	now = now.Add(40 * time.Second)
+	newKey, err = storage.Reissue(key, time.Minute)
+	assert.Nil(t, err)
+	assert.NotEqual(t, key, newKey)
+
+	now = now.Add(30 * time.Second)
+	var v fakeSession
+	assert.Equal(t, ErrSessionExpired, storage.Get(key, &v))
+	_, err = storage.Reissue(key, time.Minute)
+	assert.Equal(t, ErrSessionExpired, err)
+	assert.Nil(t, storage.Get(newKey, &v))
+	assert.Equal(t, uint(1), v.ID)
+	assert.Nil(t, storage.Get(permanent, &v))
+	assert.Equal(t, uint(2), v.ID)
+
+	for _, invalid := range []string{"", "abc", "0.abc", "1." + newKey[2:], newKey[:len(newKey)-4]} {
+		assert.Equal(t, ErrInvalidToken, storage.Get(invalid, &v), invalid)
+	}
+}
+
+func TestAesStorageRotate(t *testing.T) {
+	oldAES, newAES := newTestAES(t, "1234567812345678"), newTestAES(t, "8765432187654321")
+	old := NewAesStorage(oldAES)
+	key, err := old.Set(&fakeSession{ID: 1}, time.Hour)
+	assert.Nil(t, err)
+
+	_, err = NewAesKeyStorage(nil)
+	assert.NotNil(t, err)
+	_, err = NewAesKeyStorage([]AesKey{{ID: "1", AES: newAES}, {ID: "1", AES: oldAES}})
+	assert.NotNil(t, err)
+
+	storage, err := NewAesKeyStorage([]AesKey{{ID: "1", AES: newAES}, {ID: "0", AES: oldAES}})
+	assert.Nil(t, err)
+	var v fakeSession
+	assert.Nil(t, storage.Get(key, &v))
+	assert.Equal(t, uint(1), v.ID)
+
+	// 用旧密钥加密的key总是换发
+	newKey, err := storage.Reissue(key, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.", newKey[:2])
+	assert.Equal(t, ErrInvalidToken, old.Get(newKey, &v))
+}
+
+func TestAesStorageLegacy(t *testing.T) {
+	saes := newTestAES(t, "1234567812345678")
+	// 升级前的key是会话数据直接加密，没有key id前缀
+	bs, err := json.Marshal(&fakeSession{ID: 1})
+	assert.Nil(t, err)
+	legacy, err := saes.Enc(bs)
+	assert.Nil(t, err)
+
+	storage, err := NewAesKeyStorage([]AesKey{{ID: "1", AES: newTestAES(t, "8765432187654321")}, {ID: "0", AES: saes}})
+	assert.Nil(t, err)
+	var v fakeSession
+	assert.Nil(t, storage.Get(legacy, &v))
+	assert.Equal(t, uint(1), v.ID)
+
+	newKey, err := storage.Reissue(legacy, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.", newKey[:2])
+	v = fakeSession{}
+	assert.Nil(t, storage.Get(newKey, &v))
+	assert.Equal(t, uint(1), v.ID)
+
+	// 旧格式的key可以通过mysql的撤销列表撤销，会话ID不超过TokenID的列宽
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&RevokedToken{}))
+	revocable, err := NewAesKeyStorage([]AesKey{{ID: "0", AES: saes}}, WithRevocationList(NewMysqlRevocationList(db)))
+	assert.Nil(t, err)
+	assert.Nil(t, revocable.Del(legacy))
+	assert.Equal(t, ErrSessionRevoked, revocable.Get(legacy, &v))
+	var revoked RevokedToken
+	assert.Nil(t, db.Take(&revoked).Error)
+	assert.LessOrEqual(t, len(revoked.TokenID), 30)
+
+	// 没有key id为0的密钥时不能解密旧格式的key
+	rotated, err := NewAesKeyStorage([]AesKey{{ID: "1", AES: saes}})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrInvalidToken, rotated.Get(legacy, &v))
+}
+
+func TestAesStorageRevoke(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&RevokedToken{}))
+	storage := NewAesStorage(newTestAES(t, "1234567812345678"), WithRevocationList(NewMysqlRevocationList(db)))
+
+	key, err := storage.Set(&fakeSession{ID: 1}, time.Hour)
+	assert.Nil(t, err)
+	now := time.Now().Add(40 * time.Minute)
+	storage.now = func() time.Time { return now }
+	newKey, err := storage.Reissue(key, time.Hour)
+	assert.Nil(t, err)
+	permanent, err := storage.Set(&fakeSession{ID: 2}, 0)
+	assert.Nil(t, err)
+
+	// 撤销一个key会撤销换发出的所有key
+	assert.Nil(t, storage.Del(key))
+	assert.Nil(t, storage.Del(key))
+	var v fakeSession
+	assert.Equal(t, ErrSessionRevoked, storage.Get(key, &v))
+	assert.Equal(t, ErrSessionRevoked, storage.Get(newKey, &v))
+
+	assert.Nil(t, storage.Del(permanent))
+	assert.Equal(t, ErrSessionRevoked, storage.Get(permanent, &v))
+}
@@ -0,0 +1,558 @@
+package session
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// JWT签名算法
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// ErrRefreshDisabled 表示没有配置刷新令牌的存储
+var ErrRefreshDisabled = errors.New("未开启刷新令牌")
+
+// ErrRefreshTokenUsed 表示刷新令牌不存在、已过期或者已经使用过
+var ErrRefreshTokenUsed = errors.New("刷新令牌已失效")
+
+// JWTKey 是JWT的密钥
+// Key的类型：HS256是[]byte；RS256是*rsa.PrivateKey，只验证时可以是*rsa.PublicKey；ES256是P-256的*ecdsa.PrivateKey或*ecdsa.PublicKey
+type JWTKey struct {
+	ID  string // kid
+	Alg string
+	Key interface{}
+}
+
+// JWTConfig 是JWTStorage的配置
+type JWTConfig struct {
+	Keys     []JWTKey      // Keys[0]用于签名，所有密钥都用于验证，轮换时把新密钥放在最前面
+	Issuer   string        // 签发时写入iss，验证时不为空则要求iss相同
+	Audience string        // 签发时写入aud，验证时不为空则要求aud包含它
+	Leeway   time.Duration // 验证exp和nbf时允许的时钟误差
+	// Refresh 是刷新令牌的服务端存储，比如RedisStorage、MysqlStorage，刷新令牌就是它返回的key，为nil时不支持刷新
+	// 实现了Taker时刷新令牌原子地使用，否则并发使用同一个刷新令牌可能都成功
+	Refresh    Storage
+	RefreshTTL time.Duration  // 刷新令牌的有效期，默认7天
+	Revoked    RevocationList // 撤销列表，设置后Del才会使令牌失效
+}
+
+// JWTStorage 是JWT实现的Storage，令牌可以由其他服务通过JWKS中的公钥验证
+// Set传入的会话按json序列化后作为claims，iss、aud、exp、iat、jti由JWTStorage设置，会话中json名为sub的字段可以作为subject
+// Get验证令牌后把claims按json反序列化到会话中
+// jti是会话ID，换发和刷新得到的令牌jti不变，Del撤销一个令牌会撤销整个会话
+type JWTStorage struct {
+	cfg JWTConfig
+	now func() time.Time
+}
+
+// jwtHeader 是JWT的头
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// jwtClaims 是JWTStorage使用的注册claims
+type jwtClaims struct {
+	ID        string          `json:"jti"`
+	Issuer    string          `json:"iss,omitempty"`
+	Audience  jwtAudience     `json:"aud,omitempty"`
+	IssuedAt  int64           `json:"iat"`
+	ExpiresAt int64           `json:"exp,omitempty"`
+	NotBefore int64           `json:"nbf,omitempty"`
+	data      json.RawMessage // 会话数据
+}
+
+// jwtAudience 兼容字符串和字符串数组两种aud
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err == nil {
+		*a = jwtAudience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(bs, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// refreshRecord 是存储在服务端的刷新令牌
+type refreshRecord struct {
+	SessionID string          `json:"sid"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// NewJWTStorage 构造JWTStorage
+func NewJWTStorage(cfg JWTConfig) (*JWTStorage, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("至少需要一个密钥")
+	}
+	ids := make(map[string]bool)
+	for _, k := range cfg.Keys {
+		if err := checkJWTKey(k); err != nil {
+			return nil, err
+		}
+		if ids[k.ID] {
+			return nil, errors.New("重复的key id: " + k.ID)
+		}
+		ids[k.ID] = true
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = 7 * 24 * time.Hour
+	}
+	return &JWTStorage{cfg: cfg, now: time.Now}, nil
+}
+
+func checkJWTKey(k JWTKey) error {
+	ok := false
+	switch k.Alg {
+	case HS256:
+		secret, isBytes := k.Key.([]byte)
+		ok = isBytes && len(secret) > 0
+	case RS256:
+		switch k.Key.(type) {
+		case *rsa.PrivateKey, *rsa.PublicKey:
+			ok = true
+		}
+	case ES256:
+		switch key := k.Key.(type) {
+		case *ecdsa.PrivateKey:
+			ok = key.Curve == elliptic.P256()
+		case *ecdsa.PublicKey:
+			ok = key.Curve == elliptic.P256()
+		}
+	}
+	if !ok {
+		return errors.New("无效的密钥: " + k.ID + " " + k.Alg)
+	}
+	return nil
+}
+
+// Get 实现Storage，过期的令牌返回ErrSessionExpired，撤销的令牌返回ErrSessionRevoked
+func (s *JWTStorage) Get(key string, session interface{}) error {
+	c, err := s.parse(key)
+	if err != nil {
+		return err
+	}
+	if err = s.checkRevoked(c.ID); err != nil {
+		return err
+	}
+	return json.Unmarshal(c.data, session)
+}
+
+// Set 实现Storage
+func (s *JWTStorage) Set(session interface{}, ttl time.Duration) (key string, err error) {
+	var bs []byte
+	if bs, err = json.Marshal(session); err != nil {
+		return
+	}
+	return s.sign(xid.New().String(), bs, ttl)
+}
+
+// RefreshTTL 实现Storage，过期时间在令牌中不能修改，需要延长会话时使用Reissue或者刷新令牌
+func (s *JWTStorage) RefreshTTL(key string, ttl time.Duration) error {
+	return nil
+}
+
+// Del 实现Storage，把会话加入撤销列表，没有设置撤销列表时什么也不做
+func (s *JWTStorage) Del(key string) error {
+	if s.cfg.Revoked == nil {
+		return nil
+	}
+	c, err := s.parse(key)
+	if err != nil {
+		if err == ErrSessionExpired {
+			return nil
+		}
+		return err
+	}
+
+	var until time.Time
+	if c.ExpiresAt > 0 {
+		until = time.Unix(c.ExpiresAt, 0).Add(s.cfg.Leeway)
+	}
+	if s.cfg.Refresh != nil {
+		// 刷新令牌可以在访问令牌过期后换出新的访问令牌，撤销记录需要保留到刷新令牌过期
+		until = s.now().Add(s.cfg.RefreshTTL)
+	}
+	return s.cfg.Revoked.Revoke(c.ID, until)
+}
+
+// Reissue 实现Reissuer，剩余有效期超过ttl的一半并且使用当前密钥签名时返回原令牌
+func (s *JWTStorage) Reissue(key string, ttl time.Duration) (newKey string, err error) {
+	c, err := s.parse(key)
+	if err != nil {
+		return "", err
+	}
+	if err = s.checkRevoked(c.ID); err != nil {
+		return "", err
+	}
+
+	if h, _ := parseJWTHeader(key); h.Kid == s.cfg.Keys[0].ID {
+		if ttl == 0 && c.ExpiresAt == 0 {
+			return key, nil
+		}
+		if ttl > 0 && c.ExpiresAt > 0 && time.Unix(c.ExpiresAt, 0).Sub(s.now()) > ttl/2 {
+			return key, nil
+		}
+	}
+	return s.sign(c.ID, c.data, ttl)
+}
+
+// SetWithRefresh 存储会话，同时返回存储在服务端的刷新令牌，刷新令牌的有效期是RefreshTTL
+func (s *JWTStorage) SetWithRefresh(session interface{}, ttl time.Duration) (key, refreshToken string, err error) {
+	if s.cfg.Refresh == nil {
+		return "", "", ErrRefreshDisabled
+	}
+	var bs []byte
+	if bs, err = json.Marshal(session); err != nil {
+		return
+	}
+
+	sid := xid.New().String()
+	if key, err = s.sign(sid, bs, ttl); err != nil {
+		return
+	}
+	refreshToken, err = s.cfg.Refresh.Set(refreshRecord{SessionID: sid, Data: bs}, s.cfg.RefreshTTL)
+	return
+}
+
+// Refresh 用刷新令牌换出访问令牌和新的刷新令牌，原刷新令牌失效
+// 刷新令牌中的会话先反序列化到session中，访问令牌的有效期由ttl根据会话决定，比如记住登录的会话不过期
+func (s *JWTStorage) Refresh(refreshToken string, session interface{}, ttl func() time.Duration) (key, newRefreshToken string, err error) {
+	if s.cfg.Refresh == nil {
+		return "", "", ErrRefreshDisabled
+	}
+	var rec refreshRecord
+	if err = s.takeRefresh(refreshToken, &rec); err != nil {
+		return
+	}
+	if err = s.checkRevoked(rec.SessionID); err != nil {
+		return
+	}
+	if err = json.Unmarshal(rec.Data, session); err != nil {
+		return
+	}
+
+	if key, err = s.sign(rec.SessionID, rec.Data, ttl()); err != nil {
+		return
+	}
+	newRefreshToken, err = s.cfg.Refresh.Set(rec, s.cfg.RefreshTTL)
+	return
+}
+
+// takeRefresh 读取并删除刷新令牌，刷新令牌只能使用一次
+// Refresh实现了Taker时原子地读取并删除，并发使用同一个刷新令牌只有一个成功，其他返回ErrRefreshTokenUsed
+func (s *JWTStorage) takeRefresh(refreshToken string, rec *refreshRecord) error {
+	t, ok := s.cfg.Refresh.(Taker)
+	if !ok {
+		if err := s.cfg.Refresh.Get(refreshToken, rec); err != nil {
+			return err
+		}
+		return s.cfg.Refresh.Del(refreshToken)
+	}
+	if err := t.Take(refreshToken, rec); err != nil {
+		if err == ErrSessionExpired {
+			return ErrRefreshTokenUsed
+		}
+		return err
+	}
+	return nil
+}
+
+// RevokeRefresh 使刷新令牌失效
+func (s *JWTStorage) RevokeRefresh(refreshToken string) error {
+	if s.cfg.Refresh == nil {
+		return ErrRefreshDisabled
+	}
+	return s.cfg.Refresh.Del(refreshToken)
+}
+
+func (s *JWTStorage) checkRevoked(id string) error {
+	if s.cfg.Revoked == nil {
+		return nil
+	}
+	revoked, err := s.cfg.Revoked.IsRevoked(id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+// sign 用Keys[0]签名，data是会话的json对象
+func (s *JWTStorage) sign(id string, data []byte, ttl time.Duration) (string, error) {
+	var claims map[string]interface{}
+	if err := json.Unmarshal(data, &claims); err != nil || claims == nil {
+		return "", errors.New("会话必须序列化为json对象")
+	}
+	now := s.now()
+	claims["jti"] = id
+	claims["iat"] = now.Unix()
+	delete(claims, "exp")
+	delete(claims, "nbf")
+	if ttl > 0 {
+		claims["exp"] = now.Add(ttl).Unix()
+	}
+	if s.cfg.Issuer != "" {
+		claims["iss"] = s.cfg.Issuer
+	}
+	if s.cfg.Audience != "" {
+		claims["aud"] = s.cfg.Audience
+	}
+
+	k := s.cfg.Keys[0]
+	header, err := json.Marshal(jwtHeader{Alg: k.Alg, Typ: "JWT", Kid: k.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64(header) + "." + b64(payload)
+	sig, err := signJWT(k, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + b64(sig), nil
+}
+
+// parse 验证签名和claims，不检查撤销列表
+func (s *JWTStorage) parse(token string) (c jwtClaims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return c, ErrInvalidToken
+	}
+	h, err := parseJWTHeader(token)
+	if err != nil {
+		return c, ErrInvalidToken
+	}
+
+	var key *JWTKey
+	for i, k := range s.cfg.Keys {
+		if k.ID == h.Kid || h.Kid == "" && len(s.cfg.Keys) == 1 {
+			key = &s.cfg.Keys[i]
+			break
+		}
+	}
+	// alg必须和密钥的一致，防止用公钥当作HS256的密钥伪造签名
+	if key == nil || key.Alg != h.Alg {
+		return c, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !verifyJWT(*key, []byte(parts[0]+"."+parts[1]), sig) {
+		return c, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return c, ErrInvalidToken
+	}
+	if err = json.Unmarshal(payload, &c); err != nil {
+		return c, ErrInvalidToken
+	}
+	c.data = payload
+
+	now := s.now()
+	if c.ExpiresAt > 0 && !now.Before(time.Unix(c.ExpiresAt, 0).Add(s.cfg.Leeway)) {
+		return c, ErrSessionExpired
+	}
+	if c.NotBefore > 0 && now.Add(s.cfg.Leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return c, ErrInvalidToken
+	}
+	if s.cfg.Issuer != "" && c.Issuer != s.cfg.Issuer {
+		return c, ErrInvalidToken
+	}
+	if s.cfg.Audience != "" && !contains(c.Audience, s.cfg.Audience) {
+		return c, ErrInvalidToken
+	}
+	return c, nil
+}
+
+func parseJWTHeader(token string) (h jwtHeader, err error) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return h, ErrInvalidToken
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(bs, &h)
+	return
+}
+
+func b64(bs []byte) string {
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func signJWT(k JWTKey, input []byte) ([]byte, error) {
+	digest := sha256.Sum256(input)
+	switch key := k.Key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// ES256的签名是定长的r和s
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+	return nil, errors.New("密钥只能用于验证: " + k.ID)
+}
+
+func verifyJWT(k JWTKey, input, sig []byte) bool {
+	digest := sha256.Sum256(input)
+	switch key := k.Key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(input)
+		return subtle.ConstantTimeCompare(mac.Sum(nil), sig) == 1
+	case *rsa.PrivateKey:
+		return rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig) == nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PrivateKey:
+		return verifyES256(&key.PublicKey, digest[:], sig)
+	case *ecdsa.PublicKey:
+		return verifyES256(key, digest[:], sig)
+	}
+	return false
+}
+
+func verifyES256(key *ecdsa.PublicKey, digest, sig []byte) bool {
+	if len(sig) != 64 {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	return ecdsa.Verify(key, digest, r, s)
+}
+
+// JWK 是JSON Web Key中的公钥
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet 是JWKS
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS 返回RS256和ES256密钥的公钥，HS256的密钥不公开
+func (s *JWTStorage) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range s.cfg.Keys {
+		var pub crypto.PublicKey
+		switch key := k.Key.(type) {
+		case *rsa.PrivateKey:
+			pub = &key.PublicKey
+		case *ecdsa.PrivateKey:
+			pub = &key.PublicKey
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			pub = key
+		default:
+			continue
+		}
+
+		jwk := JWK{Kid: k.ID, Alg: k.Alg, Use: "sig"}
+		switch key := pub.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64(key.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(key.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk.Kty, jwk.Crv = "EC", "P-256"
+			x, y := make([]byte, 32), make([]byte, 32)
+			key.X.FillBytes(x)
+			key.Y.FillBytes(y)
+			jwk.X, jwk.Y = b64(x), b64(y)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// JWKSHandler 返回提供JWKS的http.Handler，在echo中可以通过echo.WrapHandler注册
+func (s *JWTStorage) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(s.JWKS())
+	})
+}
+
+// ParseJWKS 解析JWKS中的RS256和ES256公钥，用于只验证令牌的服务，其他类型的密钥被忽略
+func ParseJWKS(data []byte) ([]JWTKey, error) {
+	var set JWKSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []JWTKey
+	for _, jwk := range set.Keys {
+		switch {
+		case jwk.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err1 != nil || err2 != nil {
+				return nil, errors.New("无效的RSA公钥: " + jwk.Kid)
+			}
+			pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			keys = append(keys, JWTKey{ID: jwk.Kid, Alg: RS256, Key: pub})
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if err1 != nil || err2 != nil {
+				return nil, errors.New("无效的EC公钥: " + jwk.Kid)
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				return nil, errors.New("无效的EC公钥: " + jwk.Kid)
+			}
+			keys = append(keys, JWTKey{ID: jwk.Kid, Alg: ES256, Key: pub})
+		}
+	}
+	return keys, nil
+}
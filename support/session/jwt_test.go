@@ -0,0 +1,189 @@
+package session
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type jwtSession struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name"`
+}
+
+func TestJWTStorage(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	cases := []JWTKey{
+		{ID: "hs", Alg: HS256, Key: []byte("secret")},
+		{ID: "rs", Alg: RS256, Key: rsaKey},
+		{ID: "es", Alg: ES256, Key: ecKey},
+	}
+	for _, key := range cases {
+		storage, err := NewJWTStorage(JWTConfig{Keys: []JWTKey{key}, Issuer: "quick", Audience: "admin"})
+		assert.Nil(t, err)
+		now := time.Now()
+		storage.now = func() time.Time { return now }
+
+		token, err := storage.Set(&jwtSession{Subject: "1", Name: "admin"}, time.Minute)
+		assert.Nil(t, err)
+
+		var claims map[string]interface{}
+		payload, _ := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[1])
+		assert.Nil(t, json.Unmarshal(payload, &claims))
+		assert.Equal(t, "1", claims["sub"])
+		assert.Equal(t, "quick", claims["iss"])
+		assert.Equal(t, "admin", claims["aud"])
+
+		var v jwtSession
+		assert.Nil(t, storage.Get(token, &v), key.Alg)
+		assert.Equal(t, jwtSession{Subject: "1", Name: "admin"}, v)
+
+		// 篡改payload
+		forged := strings.Split(token, ".")
+		forged[1] = b64([]byte(strings.Replace(string(payload), `"admin"`, `"root"`, 1)))
+		assert.Equal(t, ErrInvalidToken, storage.Get(strings.Join(forged, "."), &v))
+
+		now = now.Add(time.Minute)
+		assert.Equal(t, ErrSessionExpired, storage.Get(token, &v))
+	}
+
+	_, err = NewJWTStorage(JWTConfig{Keys: []JWTKey{{ID: "rs", Alg: ES256, Key: rsaKey}}})
+	assert.NotNil(t, err)
+}
+
+func TestJWTStorageValidate(t *testing.T) {
+	storage, err := NewJWTStorage(JWTConfig{Keys: []JWTKey{{ID: "1", Alg: HS256, Key: []byte("secret")}}, Issuer: "quick", Audience: "admin"})
+	assert.Nil(t, err)
+	other, err := NewJWTStorage(JWTConfig{Keys: []JWTKey{{ID: "1", Alg: HS256, Key: []byte("secret")}}, Issuer: "other", Audience: "admin"})
+	assert.Nil(t, err)
+	token, err := other.Set(&jwtSession{Subject: "1"}, time.Minute)
+	assert.Nil(t, err)
+
+	var v jwtSession
+	assert.Equal(t, ErrInvalidToken, storage.Get(token, &v))
+	assert.Equal(t, ErrInvalidToken, storage.Get("a.b.c", &v))
+
+	// alg为none的令牌
+	none := b64([]byte(`{"alg":"none","kid":"1"}`)) + "." + b64([]byte(`{"jti":"x","iss":"quick","aud":"admin"}`)) + "."
+	assert.Equal(t, ErrInvalidToken, storage.Get(none, &v))
+}
+
+func TestJWTStorageRotateAndJWKS(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	old, err := NewJWTStorage(JWTConfig{Keys: []JWTKey{{ID: "old", Alg: ES256, Key: oldKey}}})
+	assert.Nil(t, err)
+	token, err := old.Set(&jwtSession{Subject: "1"}, time.Hour)
+	assert.Nil(t, err)
+
+	storage, err := NewJWTStorage(JWTConfig{Keys: []JWTKey{{ID: "new", Alg: RS256, Key: newKey}, {ID: "old", Alg: ES256, Key: oldKey}, {ID: "hs", Alg: HS256, Key: []byte("secret")}}})
+	assert.Nil(t, err)
+	reissued, err := storage.Reissue(token, time.Hour)
+	assert.Nil(t, err)
+	assert.NotEqual(t, token, reissued)
+	same, err := storage.Reissue(reissued, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, reissued, same)
+
+	// 只有公钥的服务通过JWKS验证
+	rec := httptest.NewRecorder()
+	storage.JWKSHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/jwks.json", nil))
+	keys, err := ParseJWKS(rec.Body.Bytes())
+	assert.Nil(t, err)
+	assert.Len(t, keys, 2)
+	verifier, err := NewJWTStorage(JWTConfig{Keys: keys})
+	assert.Nil(t, err)
+
+	var v jwtSession
+	assert.Nil(t, verifier.Get(token, &v))
+	assert.Nil(t, verifier.Get(reissued, &v))
+	assert.Equal(t, "1", v.Subject)
+	_, err = verifier.Set(&v, time.Hour)
+	assert.NotNil(t, err)
+}
+
+func minute() time.Duration { return time.Minute }
+
+func TestJWTStorageRefresh(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&Token{}, &RevokedToken{}))
+	storage, err := NewJWTStorage(JWTConfig{
+		Keys:    []JWTKey{{ID: "1", Alg: HS256, Key: []byte("secret")}},
+		Refresh: NewMysqlStorage(db),
+		Revoked: NewMysqlRevocationList(db),
+	})
+	assert.Nil(t, err)
+
+	token, refresh, err := storage.SetWithRefresh(&jwtSession{Subject: "1"}, time.Minute)
+	assert.Nil(t, err)
+
+	var v jwtSession
+	token2, refresh2, err := storage.Refresh(refresh, &v, minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", v.Subject)
+	v = jwtSession{}
+	assert.Nil(t, storage.Get(token2, &v))
+	assert.Equal(t, "1", v.Subject)
+
+	// 刷新令牌只能使用一次
+	_, _, err = storage.Refresh(refresh, &v, minute)
+	assert.Equal(t, ErrRefreshTokenUsed, err)
+
+	// 撤销会话后访问令牌和刷新令牌都失效
+	assert.Nil(t, storage.Del(token))
+	assert.Equal(t, ErrSessionRevoked, storage.Get(token2, &v))
+	_, _, err = storage.Refresh(refresh2, &v, minute)
+	assert.Equal(t, ErrSessionRevoked, err)
+
+	_, refresh3, err := storage.SetWithRefresh(&jwtSession{Subject: "2"}, time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, storage.RevokeRefresh(refresh3))
+	_, _, err = storage.Refresh(refresh3, &v, minute)
+	assert.Equal(t, ErrRefreshTokenUsed, err)
+}
+
+func TestJWTStorageRefreshConcurrent(t *testing.T) {
+	storage, err := NewJWTStorage(JWTConfig{
+		Keys:    []JWTKey{{ID: "1", Alg: HS256, Key: []byte("secret")}},
+		Refresh: NewMemoryStorage(),
+	})
+	assert.Nil(t, err)
+	_, refresh, err := storage.SetWithRefresh(&jwtSession{Subject: "1"}, time.Minute)
+	assert.Nil(t, err)
+
+	// 并发使用同一个刷新令牌只有一个成功
+	var wg sync.WaitGroup
+	var succeeded int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v jwtSession
+			if _, _, err := storage.Refresh(refresh, &v, minute); err == nil {
+				atomic.AddInt32(&succeeded, 1)
+			} else {
+				assert.Equal(t, ErrRefreshTokenUsed, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), succeeded)
+}
@@ -0,0 +1,249 @@
+package session
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// memorySweepInterval 是MemoryStorage清理过期会话的最短间隔
+const memorySweepInterval = time.Minute
+
+// memoryEntry 是内存中的会话
+type memoryEntry struct {
+	data     []byte
+	expireAt time.Time // 零值表示不会失效
+	info     Info      // Subject为空表示不属于任何用户
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// MemoryStorage 是存储在内存中的会话，实现了Storage、UserStorage、Updater和Taker
+// 适用于单节点部署和测试，重启后会话丢失；过期的会话在访问时删除，存储会话时也会定期清理
+type MemoryStorage struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	opts      options
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryStorage 构造MemoryStorage
+func NewMemoryStorage(opts ...Option) *MemoryStorage {
+	return &MemoryStorage{
+		entries: make(map[string]*memoryEntry),
+		opts:    buildOptions(opts),
+		now:     time.Now,
+	}
+}
+
+// get 返回未过期的会话，过期的会话被删除，调用时需要持有锁
+func (s *MemoryStorage) get(key string) (*memoryEntry, bool) {
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(s.now()) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return e, true
+}
+
+func (s *MemoryStorage) expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return s.now().Add(ttl)
+}
+
+// sweep 定期删除过期的会话，调用时需要持有锁
+func (s *MemoryStorage) sweep() {
+	now := s.now()
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if e.expired(now) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Get 实现Storage
+func (s *MemoryStorage) Get(key string, session interface{}) error {
+	s.mu.Lock()
+	e, ok := s.get(key)
+	s.mu.Unlock()
+	if !ok {
+		return ErrSessionExpired
+	}
+	return json.Unmarshal(e.data, session)
+}
+
+// Set 实现Storage
+func (s *MemoryStorage) Set(session interface{}, ttl time.Duration) (key string, err error) {
+	return s.SetFor("", session, ttl, Client{})
+}
+
+// RefreshTTL 实现Storage，和RedisStorage一样把存活时间重置为ttl，ttl=0表示不会失效
+func (s *MemoryStorage) RefreshTTL(key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.get(key)
+	if !ok {
+		return ErrSessionExpired
+	}
+	e.expireAt = s.expireAt(ttl)
+	return nil
+}
+
+// Del 实现Storage
+func (s *MemoryStorage) Del(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// Take 实现Taker
+func (s *MemoryStorage) Take(key string, session interface{}) error {
+	s.mu.Lock()
+	e, ok := s.get(key)
+	delete(s.entries, key)
+	s.mu.Unlock()
+	if !ok {
+		return ErrSessionExpired
+	}
+	return json.Unmarshal(e.data, session)
+}
+
+// Update 实现Updater
+func (s *MemoryStorage) Update(key string, session interface{}) error {
+	bs, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.get(key)
+	if !ok {
+		return ErrSessionExpired
+	}
+	e.data = bs
+	return nil
+}
+
+// SetFor 实现UserStorage，subject为空时和Set相同
+func (s *MemoryStorage) SetFor(subject string, session interface{}, ttl time.Duration, client Client) (key string, err error) {
+	var bs []byte
+	if bs, err = json.Marshal(session); err != nil {
+		return
+	}
+
+	client = client.bounded()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	key = xid.New().String()
+	now := s.now()
+	s.entries[key] = &memoryEntry{
+		data:     bs,
+		expireAt: s.expireAt(ttl),
+		info: Info{
+			ID:        SessionID(key),
+			Key:       key,
+			Subject:   subject,
+			Device:    client.Device,
+			IP:        client.IP,
+			CreatedAt: now,
+			LastSeen:  now,
+		},
+	}
+
+	if subject != "" && s.opts.maxSessions > 0 {
+		infos := s.list(subject)
+		for i := 0; i < len(infos)-s.opts.maxSessions; i++ {
+			delete(s.entries, infos[i].Key)
+		}
+	}
+	return
+}
+
+// list 按创建时间返回subject有效的会话，调用时需要持有锁
+func (s *MemoryStorage) list(subject string) []Info {
+	now := s.now()
+	var infos []Info
+	for _, e := range s.entries {
+		if e.info.Subject == subject && !e.expired(now) {
+			infos = append(infos, e.info)
+		}
+	}
+	// xid按时间递增，创建时间相同时按key排序
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].CreatedAt.Equal(infos[j].CreatedAt) {
+			return infos[i].Key < infos[j].Key
+		}
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+	return infos
+}
+
+// Touch 实现UserStorage
+func (s *MemoryStorage) Touch(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.get(key); ok {
+		e.info.LastSeen = s.now()
+	}
+	return nil
+}
+
+// List 实现UserStorage
+func (s *MemoryStorage) List(subject string) ([]Info, error) {
+	if subject == "" {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list(subject), nil
+}
+
+// Revoke 实现UserStorage
+func (s *MemoryStorage) Revoke(subject, id string) error {
+	if subject == "" {
+		return ErrSessionNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, info := range s.list(subject) {
+		if info.ID == id {
+			delete(s.entries, info.Key)
+			return nil
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeAll 实现UserStorage
+func (s *MemoryStorage) RevokeAll(subject string, except ...string) error {
+	if subject == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, e := range s.entries {
+		if e.info.Subject == subject && !contains(except, key) {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
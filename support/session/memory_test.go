@@ -0,0 +1,80 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	now := time.Now()
+	storage.now = func() time.Time { return now }
+
+	key, err := storage.Set(&fakeSession{ID: 1, Name: "admin"}, time.Minute)
+	assert.Nil(t, err)
+	permanent, err := storage.Set(&fakeSession{ID: 2}, 0)
+	assert.Nil(t, err)
+
+	var v fakeSession
+	assert.Nil(t, storage.Get(key, &v))
+	assert.Equal(t, "admin", v.Name)
+
+	assert.Nil(t, storage.Update(key, &fakeSession{ID: 1, Name: "root"}))
+	assert.Nil(t, storage.Get(key, &v))
+	assert.Equal(t, "root", v.Name)
+
+	now = now.Add(50 * time.Second)
+	assert.Nil(t, storage.RefreshTTL(key, time.Minute))
+	now = now.Add(50 * time.Second)
+	assert.Nil(t, storage.Get(key, &v))
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, ErrSessionExpired, storage.Get(key, &v))
+	assert.Equal(t, ErrSessionExpired, storage.Update(key, &v))
+	assert.Nil(t, storage.Get(permanent, &v))
+
+	// 存储会话时清理过期的会话
+	expired, err := storage.Set(&fakeSession{ID: 3}, time.Second)
+	assert.Nil(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = storage.Set(&fakeSession{ID: 4}, 0)
+	assert.Nil(t, err)
+	assert.NotContains(t, storage.entries, expired)
+	assert.Len(t, storage.entries, 2)
+
+	assert.Nil(t, storage.Del(permanent))
+	assert.Equal(t, ErrSessionExpired, storage.Get(permanent, &v))
+}
+
+func TestMemoryUserStorage(t *testing.T) {
+	testUserStorage(t, NewMemoryStorage(WithMaxSessions(2)))
+}
+
+func TestMemoryStorageConcurrent(t *testing.T) {
+	storage := NewMemoryStorage(WithMaxSessions(5))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			subject := fmt.Sprint(i % 2)
+			key, err := storage.SetFor(subject, &fakeSession{ID: uint(i)}, time.Minute, Client{})
+			assert.Nil(t, err)
+			var v fakeSession
+			storage.Get(key, &v)
+			storage.Touch(key)
+			storage.Update(key, &v)
+			storage.List(subject)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, subject := range []string{"0", "1"} {
+		infos, err := storage.List(subject)
+		assert.Nil(t, err)
+		assert.Len(t, infos, 5)
+	}
+}
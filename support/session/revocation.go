@@ -0,0 +1,81 @@
+package session
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevocationList 记录被撤销的会话ID，用于key自身包含会话数据的Storage，比如AesStorage
+type RevocationList interface {
+	Revoke(id string, until time.Time) error // 撤销会话，until是会话的过期时间，之后可以删除记录，零值表示永久
+	IsRevoked(id string) (bool, error)       // 会话是否已撤销
+}
+
+// redisRevocationList 是redis实现的RevocationList，记录在会话过期后自动删除
+type redisRevocationList struct {
+	prefix string
+	client *redis.Client
+}
+
+// NewRedisRevocationList 构造redis实现的RevocationList，键为 prefix+"revoked:"+id
+func NewRedisRevocationList(prefix string, client *redis.Client) RevocationList {
+	return &redisRevocationList{
+		prefix: prefix,
+		client: client,
+	}
+}
+
+func (rl *redisRevocationList) Revoke(id string, until time.Time) error {
+	var ttl time.Duration
+	if !until.IsZero() {
+		if ttl = time.Until(until); ttl <= 0 {
+			return nil
+		}
+	}
+	return rl.client.Set(rl.prefix+"revoked:"+id, 1, ttl).Err()
+}
+
+func (rl *redisRevocationList) IsRevoked(id string) (bool, error) {
+	n, err := rl.client.Exists(rl.prefix + "revoked:" + id).Result()
+	return n > 0, err
+}
+
+// RevokedToken 是mysql中存储撤销的会话的表
+type RevokedToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	TokenID   string `gorm:"type:varchar(30);uniqueIndex"`
+	Permanent bool
+	ExpireAt  time.Time
+	CreatedAt time.Time
+}
+
+// mysqlRevocationList 是mysql实现的RevocationList，需要创建RevokedToken表
+type mysqlRevocationList struct {
+	db *gorm.DB
+}
+
+// NewMysqlRevocationList 构造mysql实现的RevocationList
+func NewMysqlRevocationList(db *gorm.DB) RevocationList {
+	return &mysqlRevocationList{
+		db: db,
+	}
+}
+
+func (rl *mysqlRevocationList) Revoke(id string, until time.Time) error {
+	rt := RevokedToken{
+		TokenID:   id,
+		Permanent: until.IsZero(),
+		ExpireAt:  until,
+		CreatedAt: time.Now(),
+	}
+	return rl.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rt).Error
+}
+
+func (rl *mysqlRevocationList) IsRevoked(id string) (bool, error) {
+	var n int64
+	err := rl.db.Model(&RevokedToken{}).Where("token_id = ? AND (permanent = ? OR expire_at > ?)", id, true, time.Now()).Count(&n).Error
+	return n > 0, err
+}
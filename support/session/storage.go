@@ -1,12 +1,13 @@
 package session
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v7"
-	"github.com/hiwjd/quick/util"
 	"github.com/rs/xid"
 	"gorm.io/gorm"
 )
@@ -24,17 +25,41 @@ type Storage interface {
 	Del(key string) error                                               // 删除会话
 }
 
-// RedisStorage redis实现的Storage
+// Updater 是可以修改会话数据的Storage，key和过期时间不变，会话不存在或已过期时返回错误
+// RedisStorage、MysqlStorage、MemoryStorage实现了它，key中包含会话数据的AesStorage、JWTStorage不能修改
+type Updater interface {
+	Update(key string, session interface{}) error
+}
+
+// Taker 是可以原子地读取并删除会话的Storage，并发Take同一个key时只有一个成功，其他返回ErrSessionExpired
+// 用于只能使用一次的key，比如JWTStorage的刷新令牌；RedisStorage、MysqlStorage、MemoryStorage实现了它
+type Taker interface {
+	Take(key string, session interface{}) error
+}
+
+// redisTakeScript 原子地读取并删除key
+var redisTakeScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if v then
+  redis.call('DEL', KEYS[1])
+end
+return v
+`)
+
+// RedisStorage redis实现的Storage，同时实现了UserStorage
+// 通过SetFor存储的会话，元信息存在 prefix+"meta:"+key 的hash中，用户的会话索引存在 prefix+"user:"+subject 的有序集合中
 type RedisStorage struct {
 	prefix string
 	client *redis.Client
+	opts   options
 }
 
 // NewRedisStorage 构造redis实现的Storage
-func NewRedisStorage(prefix string, client *redis.Client) Storage {
+func NewRedisStorage(prefix string, client *redis.Client, opts ...Option) *RedisStorage {
 	return &RedisStorage{
 		prefix: prefix,
 		client: client,
+		opts:   buildOptions(opts),
 	}
 }
 
@@ -42,6 +67,14 @@ func (s RedisStorage) unifyKey(key string) string {
 	return s.prefix + key
 }
 
+func (s RedisStorage) metaKey(key string) string {
+	return s.prefix + "meta:" + key
+}
+
+func (s RedisStorage) userKey(subject string) string {
+	return s.prefix + "user:" + subject
+}
+
 // Get 实现Storage
 func (s *RedisStorage) Get(key string, session interface{}) (err error) {
 	var bs []byte
@@ -66,67 +99,246 @@ func (s *RedisStorage) Set(session interface{}, ttl time.Duration) (key string,
 
 // RefreshTTL 实现Storage
 func (s *RedisStorage) RefreshTTL(key string, ttl time.Duration) error {
-	return s.client.Expire(s.unifyKey(key), ttl).Err()
-}
-
-// Del 实现Storage
-func (s *RedisStorage) Del(key string) error {
-	return s.client.Del(s.unifyKey(key)).Err()
+	_, err := s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Expire(s.unifyKey(key), ttl)
+		pipe.Expire(s.metaKey(key), ttl)
+		return nil
+	})
+	return err
 }
 
-// AesStorage 是用aes加密用户信息的方式做会话存储的一种实现
-type AesStorage struct {
-	saes *util.SimpleAES
+// Update 实现Updater，只修改存在的会话并保留剩余的存活时间
+func (s *RedisStorage) Update(key string, session interface{}) error {
+	bs, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	ttl, err := s.client.PTTL(s.unifyKey(key)).Result()
+	if err != nil {
+		return err
+	}
+	// -2表示不存在，-1表示没有过期时间
+	switch ttl {
+	case -2:
+		return ErrSessionExpired
+	case -1:
+		ttl = 0
+	}
+	ok, err := s.client.SetXX(s.unifyKey(key), bs, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSessionExpired
+	}
+	return nil
 }
 
-// NewAesStorage 构造AesStorage
-func NewAesStorage(saes *util.SimpleAES) *AesStorage {
-	return &AesStorage{
-		saes: saes,
+// Del 实现Storage
+func (s *RedisStorage) Del(key string) error {
+	subject, err := s.client.HGet(s.metaKey(key), "subject").Result()
+	if err != nil && err != redis.Nil {
+		return err
 	}
+	return s.del(subject, key)
 }
 
-// Get 实现Storage
-func (s *AesStorage) Get(key string, session interface{}) error {
-	bs, err := s.saes.Dec(key)
+// Take 实现Taker
+func (s *RedisStorage) Take(key string, session interface{}) error {
+	v, err := redisTakeScript.Run(s.client, []string{s.unifyKey(key)}).Text()
+	if err == redis.Nil {
+		return ErrSessionExpired
+	}
 	if err != nil {
 		return err
 	}
+	// 会话数据已经删除，再清理元信息和索引
+	if err = s.Del(key); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(v), session)
+}
 
-	err = json.Unmarshal(bs, session)
+// del 删除会话数据、元信息和索引
+func (s *RedisStorage) del(subject string, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		members := make([]interface{}, len(keys))
+		for i, key := range keys {
+			pipe.Del(s.unifyKey(key), s.metaKey(key))
+			members[i] = key
+		}
+		if subject != "" {
+			pipe.ZRem(s.userKey(subject), members...)
+		}
+		return nil
+	})
 	return err
 }
 
-// Set 实现Storage
-func (s *AesStorage) Set(session interface{}, ttl time.Duration) (key string, err error) {
+// SetFor 实现UserStorage
+func (s *RedisStorage) SetFor(subject string, session interface{}, ttl time.Duration, client Client) (key string, err error) {
 	var bs []byte
 	if bs, err = json.Marshal(session); err != nil {
 		return
 	}
+	client = client.bounded()
+	key = xid.New().String()
+	now := time.Now()
+	ms := now.UnixNano() / int64(time.Millisecond)
+	metaKey := s.metaKey(key)
+	_, err = s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(s.unifyKey(key), bs, ttl)
+		pipe.HSet(metaKey, "subject", subject, "device", client.Device, "ip", client.IP, "createdAt", ms, "lastSeen", ms)
+		if ttl > 0 {
+			pipe.Expire(metaKey, ttl)
+		}
+		pipe.ZAdd(s.userKey(subject), &redis.Z{Score: float64(ms), Member: key})
+		return nil
+	})
+	if err != nil {
+		return
+	}
 
-	key, err = s.saes.Enc(bs)
+	if s.opts.maxSessions > 0 {
+		var keys []string
+		if keys, err = s.liveKeys(subject); err != nil {
+			return
+		}
+		if n := len(keys) - s.opts.maxSessions; n > 0 {
+			err = s.del(subject, keys[:n]...)
+		}
+	}
 	return
 }
 
-// RefreshTTL 实现Storage
-func (s *AesStorage) RefreshTTL(key string, ttl time.Duration) error {
-	return nil
+// liveKeys 按创建时间返回subject未过期的会话，同时清理索引中已过期的会话
+func (s *RedisStorage) liveKeys(subject string) ([]string, error) {
+	keys, err := s.client.ZRange(s.userKey(subject), 0, -1).Result()
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	cmds := make([]*redis.IntCmd, len(keys))
+	if _, err = s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Exists(s.unifyKey(key))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	var live []string
+	var expired []interface{}
+	for i, key := range keys {
+		if cmds[i].Val() > 0 {
+			live = append(live, key)
+		} else {
+			expired = append(expired, key)
+		}
+	}
+	if len(expired) > 0 {
+		if err = s.client.ZRem(s.userKey(subject), expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return live, nil
 }
 
-// Del 实现Storage
-func (s *AesStorage) Del(key string) error {
-	return nil
+// Touch 实现UserStorage，不是通过SetFor存储的会话忽略
+func (s *RedisStorage) Touch(key string) error {
+	metaKey := s.metaKey(key)
+	n, err := s.client.Exists(metaKey).Result()
+	if err != nil || n == 0 {
+		return err
+	}
+	return s.client.HSet(metaKey, "lastSeen", time.Now().UnixNano()/int64(time.Millisecond)).Err()
+}
+
+// List 实现UserStorage
+func (s *RedisStorage) List(subject string) ([]Info, error) {
+	keys, err := s.liveKeys(subject)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	cmds := make([]*redis.StringStringMapCmd, len(keys))
+	if _, err = s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(s.metaKey(key))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	infos := make([]Info, 0, len(keys))
+	for i, key := range keys {
+		m := cmds[i].Val()
+		if len(m) == 0 {
+			continue
+		}
+		infos = append(infos, Info{
+			ID:        SessionID(key),
+			Key:       key,
+			Subject:   m["subject"],
+			Device:    m["device"],
+			IP:        m["ip"],
+			CreatedAt: parseMillis(m["createdAt"]),
+			LastSeen:  parseMillis(m["lastSeen"]),
+		})
+	}
+	return infos, nil
+}
+
+// Revoke 实现UserStorage
+func (s *RedisStorage) Revoke(subject, id string) error {
+	keys, err := s.liveKeys(subject)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if SessionID(key) == id {
+			return s.del(subject, key)
+		}
+	}
+	return ErrSessionNotFound
 }
 
-// MysqlStorage 是存储在mysql中的会话
+// RevokeAll 实现UserStorage
+func (s *RedisStorage) RevokeAll(subject string, except ...string) error {
+	keys, err := s.client.ZRange(s.userKey(subject), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	var revoked []string
+	for _, key := range keys {
+		if !contains(except, key) {
+			revoked = append(revoked, key)
+		}
+	}
+	return s.del(subject, revoked...)
+}
+
+func parseMillis(s string) time.Time {
+	ms, _ := strconv.ParseInt(s, 10, 64)
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// MysqlStorage 是存储在mysql中的会话，同时实现了UserStorage
 type MysqlStorage struct {
-	db *gorm.DB
+	db   *gorm.DB
+	opts options
 }
 
 // NewMysqlStorage 构造MysqlStorage
-func NewMysqlStorage(db *gorm.DB) *MysqlStorage {
+func NewMysqlStorage(db *gorm.DB, opts ...Option) *MysqlStorage {
 	return &MysqlStorage{
-		db: db,
+		db:   db,
+		opts: buildOptions(opts),
 	}
 }
 
@@ -154,11 +366,12 @@ func (s *MysqlStorage) Set(session interface{}, ttl time.Duration) (key string,
 	key = xid.New().String()
 	now := time.Now()
 	ss := Token{
-		Token:     key,
-		Data:      string(bs),
-		Permenent: ttl == 0,
-		ExpireAt:  now.Add(ttl),
-		CreatedAt: now,
+		Token:      key,
+		Data:       string(bs),
+		Permenent:  ttl == 0,
+		ExpireAt:   now.Add(ttl),
+		LastSeenAt: now,
+		CreatedAt:  now,
 	}
 
 	err = s.db.Create(&ss).Error
@@ -182,12 +395,199 @@ func (s *MysqlStorage) Del(key string) error {
 	return s.db.Where("token=?", key).Delete(Token{}).Error
 }
 
+// Take 实现Taker，删除影响的行数为1才算成功
+func (s *MysqlStorage) Take(key string, session interface{}) error {
+	var ss Token
+	if err := s.db.Where("token = ?", key).First(&ss).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionExpired
+		}
+		return err
+	}
+
+	result := s.db.Where("token = ?", key).Delete(Token{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return ErrSessionExpired
+	}
+
+	if !ss.Permenent && ss.ExpireAt.Before(time.Now()) {
+		return ErrSessionExpired
+	}
+	return json.Unmarshal([]byte(ss.Data), session)
+}
+
+// Update 实现Updater
+func (s *MysqlStorage) Update(key string, session interface{}) error {
+	bs, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	var ss Token
+	if err := s.db.Where("token = ?", key).First(&ss).Error; err != nil {
+		return err
+	}
+	if !ss.Permenent && ss.ExpireAt.Before(time.Now()) {
+		return ErrSessionExpired
+	}
+	return s.db.Model(&Token{}).Where("id = ?", ss.ID).Update("data", string(bs)).Error
+}
+
+// purgeBatchSize 是PurgeExpired每次删除的数量，避免长时间锁表
+const purgeBatchSize = 1000
+
+// PurgeExpired 分批删除已过期的会话，可以作为定时任务注册
+//
+//	ac.Schedule("@hourly", storage.PurgeExpired)
+func (s *MysqlStorage) PurgeExpired(ctx context.Context) error {
+	now := time.Now()
+	for {
+		var ids []uint
+		if err := s.db.WithContext(ctx).Model(&Token{}).Where("permenent = ? AND expire_at < ?", false, now).
+			Order("id").Limit(purgeBatchSize).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := s.db.WithContext(ctx).Where("id IN ?", ids).Delete(Token{}).Error; err != nil {
+			return err
+		}
+		if len(ids) < purgeBatchSize {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// SetFor 实现UserStorage
+func (s *MysqlStorage) SetFor(subject string, session interface{}, ttl time.Duration, client Client) (key string, err error) {
+	var bs []byte
+	if bs, err = json.Marshal(session); err != nil {
+		return
+	}
+	client = client.bounded()
+
+	key = xid.New().String()
+	now := time.Now()
+	ss := Token{
+		Token:      key,
+		Subject:    subject,
+		Device:     client.Device,
+		IP:         client.IP,
+		Data:       string(bs),
+		Permenent:  ttl == 0,
+		ExpireAt:   now.Add(ttl),
+		LastSeenAt: now,
+		CreatedAt:  now,
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&ss).Error; err != nil {
+			return err
+		}
+		if s.opts.maxSessions <= 0 {
+			return nil
+		}
+
+		var ids []uint
+		if err := s.live(tx, subject).Model(&Token{}).Order("id").Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		n := len(ids) - s.opts.maxSessions
+		if n <= 0 {
+			return nil
+		}
+		return tx.Where("id IN ?", ids[:n]).Delete(Token{}).Error
+	})
+	return
+}
+
+// live 查询subject未过期的会话
+func (s *MysqlStorage) live(db *gorm.DB, subject string) *gorm.DB {
+	return db.Where("subject = ? AND (permenent = ? OR expire_at > ?)", subject, true, time.Now())
+}
+
+// Touch 实现UserStorage，为了减少写入，一分钟内只更新一次
+func (s *MysqlStorage) Touch(key string) error {
+	now := time.Now()
+	return s.db.Model(&Token{}).Where("token = ? AND last_seen_at < ?", key, now.Add(-time.Minute)).Update("last_seen_at", now).Error
+}
+
+// List 实现UserStorage
+func (s *MysqlStorage) List(subject string) ([]Info, error) {
+	var tokens []Token
+	if err := s.live(s.db, subject).Order("id").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	infos := make([]Info, len(tokens))
+	for i, t := range tokens {
+		infos[i] = Info{
+			ID:        SessionID(t.Token),
+			Key:       t.Token,
+			Subject:   t.Subject,
+			Device:    t.Device,
+			IP:        t.IP,
+			CreatedAt: t.CreatedAt,
+			LastSeen:  t.LastSeenAt,
+		}
+	}
+	return infos, nil
+}
+
+// Revoke 实现UserStorage
+func (s *MysqlStorage) Revoke(subject, id string) error {
+	var keys []string
+	if err := s.db.Model(&Token{}).Where("subject = ?", subject).Pluck("token", &keys).Error; err != nil {
+		return err
+	}
+	key := ""
+	for _, k := range keys {
+		if SessionID(k) == id {
+			key = k
+			break
+		}
+	}
+	if key == "" {
+		return ErrSessionNotFound
+	}
+
+	result := s.db.Where("subject = ? AND token = ?", subject, key).Delete(Token{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAll 实现UserStorage
+func (s *MysqlStorage) RevokeAll(subject string, except ...string) error {
+	db := s.db.Where("subject = ?", subject)
+	if len(except) > 0 {
+		db = db.Where("token NOT IN ?", except)
+	}
+	return db.Delete(Token{}).Error
+}
+
 // Token 是mysql中存储session的表
+// Subject、Device、IP只有通过SetFor存储的会话才有
 type Token struct {
-	ID        uint   `gorm:"primaryKey"`
-	Token     string `gorm:"type:varchar(30);uniqueIndex"`
-	Data      string `gorm:"type:varchar(255)"`
-	Permenent bool
-	ExpireAt  time.Time
-	CreatedAt time.Time
+	ID         uint   `gorm:"primaryKey"`
+	Token      string `gorm:"type:varchar(30);uniqueIndex"`
+	Subject    string `gorm:"type:varchar(64);index"`
+	Device     string `gorm:"type:varchar(255)"`
+	IP         string `gorm:"type:varchar(64)"`
+	Data       string `gorm:"type:text"`
+	Permenent  bool
+	ExpireAt   time.Time
+	LastSeenAt time.Time
+	CreatedAt  time.Time
 }
@@ -1,12 +1,19 @@
 package session
 
 import (
+	"context"
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-redis/redis/v7"
 	"github.com/hiwjd/quick/util"
+	"github.com/rs/xid"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 type fakeSession struct {
@@ -54,3 +61,132 @@ func TestAesStorage(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, sess, &v)
 }
+
+func testUserStorage(t *testing.T, storage UserStorage) {
+	var keys []string
+	for i := 0; i < 3; i++ {
+		key, err := storage.SetFor("1", &fakeSession{ID: 1, Name: "admin"}, time.Minute, Client{Device: "chrome", IP: "127.0.0.1"})
+		assert.Nil(t, err)
+		keys = append(keys, key)
+		time.Sleep(2 * time.Millisecond)
+	}
+	_, err := storage.SetFor("2", &fakeSession{ID: 2}, 0, Client{})
+	assert.Nil(t, err)
+
+	// 超过上限淘汰最早的会话
+	var v fakeSession
+	assert.NotNil(t, storage.Get(keys[0], &v))
+	infos, err := storage.List("1")
+	assert.Nil(t, err)
+	if assert.Len(t, infos, 2) {
+		assert.Equal(t, keys[1], infos[0].Key)
+		assert.Equal(t, SessionID(keys[1]), infos[0].ID)
+		assert.NotEqual(t, keys[1], infos[0].ID)
+		assert.Equal(t, "chrome", infos[0].Device)
+		assert.Equal(t, "127.0.0.1", infos[0].IP)
+		assert.False(t, infos[0].CreatedAt.IsZero())
+	}
+	assert.Nil(t, storage.Touch(keys[1]))
+
+	// 只能通过会话ID撤销
+	assert.Equal(t, ErrSessionNotFound, storage.Revoke("1", keys[1]))
+	assert.Equal(t, ErrSessionNotFound, storage.Revoke("2", infos[0].ID))
+	assert.Nil(t, storage.Revoke("1", infos[0].ID))
+	assert.NotNil(t, storage.Get(keys[1], &v))
+
+	key, err := storage.SetFor("1", &fakeSession{ID: 1}, time.Minute, Client{})
+	assert.Nil(t, err)
+	assert.Nil(t, storage.RevokeAll("1", key))
+	infos, err = storage.List("1")
+	assert.Nil(t, err)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, key, infos[0].Key)
+	}
+
+	assert.Nil(t, storage.Del(key))
+	infos, err = storage.List("1")
+	assert.Nil(t, err)
+	assert.Len(t, infos, 0)
+
+	infos, err = storage.List("2")
+	assert.Nil(t, err)
+	assert.Len(t, infos, 1)
+}
+
+func TestRedisUserStorage(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	if err := client.Ping().Err(); err != nil {
+		t.Skip("需要启动redis")
+	}
+	prefix := "test-session-" + xid.New().String() + ":"
+	testUserStorage(t, NewRedisStorage(prefix, client, WithMaxSessions(2)))
+}
+
+func TestMysqlUserStorage(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&Token{}))
+	testUserStorage(t, NewMysqlStorage(db, WithMaxSessions(2)))
+}
+
+func TestLongClient(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&Token{}))
+
+	// 超过列宽的User-Agent和IP截断后存储，不会截断多字节字符
+	ua := strings.Repeat("a", 254) + strings.Repeat("中", 46)
+	assert.Equal(t, 300, utf8.RuneCountInString(ua))
+	client := Client{Device: ua, IP: strings.Repeat("1", 100)}
+	for _, storage := range []UserStorage{NewMysqlStorage(db), NewMemoryStorage()} {
+		_, err := storage.SetFor("u1", &fakeSession{ID: 1}, time.Minute, client)
+		assert.Nil(t, err)
+		infos, err := storage.List("u1")
+		assert.Nil(t, err)
+		if assert.Len(t, infos, 1) {
+			assert.Equal(t, strings.Repeat("a", 254), infos[0].Device)
+			assert.Len(t, infos[0].IP, 64)
+		}
+	}
+}
+
+func TestMysqlStorageUpdateAndPurge(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&Token{}))
+	storage := NewMysqlStorage(db)
+
+	// 超过255字节的会话
+	big := &fakeSession{ID: 1, Name: strings.Repeat("a", 1000)}
+	key, err := storage.Set(big, time.Minute)
+	assert.Nil(t, err)
+	var v fakeSession
+	assert.Nil(t, storage.Get(key, &v))
+	assert.Equal(t, big, &v)
+
+	assert.Nil(t, storage.Update(key, &fakeSession{ID: 1, Name: "admin"}))
+	assert.Nil(t, storage.Get(key, &v))
+	assert.Equal(t, "admin", v.Name)
+
+	permanent, err := storage.Set(&fakeSession{ID: 2}, 0)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = storage.Set(&fakeSession{ID: 3}, time.Nanosecond)
+		assert.Nil(t, err)
+	}
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, storage.PurgeExpired(context.Background()))
+
+	var n int64
+	assert.Nil(t, db.Model(&Token{}).Count(&n).Error)
+	assert.Equal(t, int64(2), n)
+	assert.Nil(t, storage.Get(permanent, &v))
+	assert.Nil(t, storage.Get(key, &v))
+}
+
+func TestInfoJSON(t *testing.T) {
+	bs, err := json.Marshal(Info{ID: SessionID("secret-key"), Key: "secret-key"})
+	assert.Nil(t, err)
+	assert.NotContains(t, string(bs), "secret-key")
+	assert.Contains(t, string(bs), `"id":"`+SessionID("secret-key")+`"`)
+}
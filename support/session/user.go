@@ -0,0 +1,105 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrSessionNotFound 表示用户没有这个会话
+var ErrSessionNotFound = errors.New("会话不存在")
+
+// Client 是创建会话的客户端信息
+// 存储时Device截断到255字节、IP截断到64字节，和MysqlStorage的列宽一致
+type Client struct {
+	Device string // 设备，一般是User-Agent
+	IP     string
+}
+
+const (
+	maxDeviceLen = 255
+	maxIPLen     = 64
+)
+
+// bounded 返回截断到列宽的客户端信息，过长的User-Agent不会导致存储会话失败
+func (c Client) bounded() Client {
+	return Client{Device: truncate(c.Device, maxDeviceLen), IP: truncate(c.IP, maxIPLen)}
+}
+
+// truncate 把s截断到不超过n字节，不会截断多字节字符
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Info 是会话的元信息，不包含会话数据
+// Key是会话key本身，可以直接使用会话，不能返回给客户端；需要标识会话时使用ID
+type Info struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"-"`
+	Subject   string    `json:"subject"`
+	Device    string    `json:"device"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"createdAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// UserStorage 是可以按用户管理会话的Storage，subject是用户的唯一标识，比如管理员ID
+// 只有通过SetFor存储的会话才能按用户管理，通过Set存储的会话不属于任何用户
+type UserStorage interface {
+	Storage
+	// SetFor 为subject存储会话，超过WithMaxSessions设置的上限时淘汰最早创建的会话
+	SetFor(subject string, session interface{}, ttl time.Duration, client Client) (key string, err error)
+	// Touch 记录会话的最后活跃时间
+	Touch(key string) error
+	// List 按创建时间返回subject有效的会话
+	List(subject string) ([]Info, error)
+	// Revoke 删除subject的ID为id的会话，id是Info.ID，会话不属于subject时返回ErrSessionNotFound
+	Revoke(subject, id string) error
+	// RevokeAll 删除subject除except外的所有会话，比如修改密码后只保留当前会话
+	RevokeAll(subject string, except ...string) error
+}
+
+// Option 是RedisStorage和MysqlStorage的选项
+type Option func(o *options)
+
+type options struct {
+	maxSessions int
+}
+
+// WithMaxSessions 设置每个用户同时有效的会话数上限，超过时淘汰最早创建的会话，默认不限制
+func WithMaxSessions(n int) Option {
+	return func(o *options) {
+		o.maxSessions = n
+	}
+}
+
+func buildOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// SessionID 返回会话key对应的会话ID，会话ID由key的哈希生成，可以返回给客户端用于标识会话
+func SessionID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -9,6 +9,11 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrSceneMissing 表示短信场景不存在，参数scene是场景ID
+	ErrSceneMissing = quick.RegisterErrCode("sms.scene_missing", http.StatusInternalServerError, "短信场景缺失: {{scene}}", map[string]string{"en": "SMS scene missing: {{scene}}"})
+)
+
 // NewDBFetchScene 返回数据库实现的FetchScene
 func NewDBFetchScene(db *gorm.DB) FetchScene {
 	return (&dbFetchScene{db}).Fetch
@@ -44,7 +49,7 @@ func (s *dbFetchScene) Fetch(sceneID string) (*Scene, error) {
 	var sm SceneModel
 	if err := s.db.Where("scene_id=?", sceneID).First(&sm).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			err = quick.NewFineErr(http.StatusInternalServerError, "短信场景缺失")
+			err = ErrSceneMissing.Wrap(err, "scene", sceneID)
 		}
 		return nil, err
 	}
@@ -0,0 +1,257 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTaskDuplicated 表示相同唯一键的任务还未执行完成
+	ErrTaskDuplicated = errors.New("task with same unique key already exists")
+	// ErrTaskHandlerMissing 表示任务没有注册处理方法
+	ErrTaskHandlerMissing = errors.New("task handler missing")
+)
+
+type (
+	// Task 是任务队列中的一个任务
+	Task struct {
+		ID        string    `json:"id"`
+		Name      string    `json:"name"`      // 任务名，对应RegisterTask注册的处理方法
+		Payload   string    `json:"payload"`   // 任务参数
+		Priority  int       `json:"priority"`  // 优先级，越大越先执行
+		UniqueKey string    `json:"uniqueKey"` // 唯一键，任务执行完成前相同唯一键的任务无法重复投递
+		Attempts  int       `json:"attempts"`  // 已投递次数
+		MaxRetry  int       `json:"maxRetry"`  // 最多重试次数
+		RunAt     time.Time `json:"runAt"`     // 可执行时间，任务被取出后会被推迟到可见性超时时间
+		LastError string    `json:"lastError"` // 最近一次执行的错误
+	}
+
+	// TaskHandler 是任务处理方法，返回error时任务会按退避策略重试
+	TaskHandler func(ctx context.Context, payload string) error
+
+	// TaskOption 是投递任务时的选项
+	TaskOption func(t *Task)
+
+	// TaskBroker 是任务队列的存储后端
+	// 任务被Pop后在visibility时间内不会再次被取出，超时未Ack则会重新投递，即至少投递一次
+	TaskBroker interface {
+		// Push 投递任务，唯一键冲突时返回ErrTaskDuplicated
+		Push(ctx context.Context, task *Task) error
+		// Pop 取出一个到期的任务，没有任务时返回nil, nil
+		Pop(ctx context.Context, visibility time.Duration) (*Task, error)
+		// Ack 确认任务执行成功并删除任务
+		Ack(ctx context.Context, task *Task) error
+		// Retry 设置任务在runAt时重新执行
+		Retry(ctx context.Context, task *Task, runAt time.Time) error
+		// Bury 把任务移到死信存储中
+		Bury(ctx context.Context, task *Task) error
+	}
+)
+
+// WithDelay 延迟d后执行
+func WithDelay(d time.Duration) TaskOption {
+	return func(t *Task) {
+		t.RunAt = time.Now().Add(d)
+	}
+}
+
+// WithRunAt 在指定时间执行
+func WithRunAt(runAt time.Time) TaskOption {
+	return func(t *Task) {
+		t.RunAt = runAt
+	}
+}
+
+// WithPriority 设置优先级
+func WithPriority(priority int) TaskOption {
+	return func(t *Task) {
+		t.Priority = priority
+	}
+}
+
+// WithUniqueKey 设置唯一键
+func WithUniqueKey(key string) TaskOption {
+	return func(t *Task) {
+		t.UniqueKey = key
+	}
+}
+
+// WithMaxRetry 设置最多重试次数
+func WithMaxRetry(n int) TaskOption {
+	return func(t *Task) {
+		t.MaxRetry = n
+	}
+}
+
+// taskBrokerTimeout 是任务执行后调用Ack、Bury、Retry的超时时间
+const taskBrokerTimeout = 10 * time.Second
+
+// taskQueue 负责投递任务和调度worker执行任务
+type taskQueue struct {
+	mu           sync.RWMutex
+	broker       TaskBroker
+	handlers     map[string]TaskHandler
+	concurrency  int
+	pollInterval time.Duration
+	visibility   time.Duration
+	maxRetry     int
+	logf         Logf
+	stop         chan struct{}
+	done         sync.WaitGroup
+}
+
+func newTaskQueue(broker TaskBroker, cfg TaskQueue, logf Logf) *taskQueue {
+	tq := &taskQueue{
+		broker:       broker,
+		handlers:     make(map[string]TaskHandler),
+		concurrency:  cfg.Concurrency,
+		pollInterval: time.Duration(cfg.PollInterval) * time.Millisecond,
+		visibility:   time.Duration(cfg.VisibilityTimeout) * time.Second,
+		maxRetry:     cfg.MaxRetry,
+		logf:         logf,
+		stop:         make(chan struct{}),
+	}
+	if tq.concurrency < 1 {
+		tq.concurrency = 4
+	}
+	if tq.pollInterval <= 0 {
+		tq.pollInterval = time.Second
+	}
+	if tq.visibility <= 0 {
+		tq.visibility = 5 * time.Minute
+	}
+	if tq.maxRetry < 0 {
+		tq.maxRetry = 0
+	} else if tq.maxRetry == 0 {
+		tq.maxRetry = 3
+	}
+	return tq
+}
+
+func (tq *taskQueue) register(name string, h TaskHandler) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	tq.handlers[name] = h
+}
+
+func (tq *taskQueue) handler(name string) (TaskHandler, bool) {
+	tq.mu.RLock()
+	defer tq.mu.RUnlock()
+	h, ok := tq.handlers[name]
+	return h, ok
+}
+
+func (tq *taskQueue) enqueue(ctx context.Context, name string, payload string, opts ...TaskOption) (string, error) {
+	task := &Task{
+		Name:     name,
+		Payload:  payload,
+		MaxRetry: tq.maxRetry,
+		RunAt:    time.Now(),
+	}
+	for _, opt := range opts {
+		opt(task)
+	}
+	if err := tq.broker.Push(ctx, task); err != nil {
+		return "", err
+	}
+	return task.ID, nil
+}
+
+func (tq *taskQueue) start() {
+	for i := 0; i < tq.concurrency; i++ {
+		tq.done.Add(1)
+		go tq.work()
+	}
+}
+
+// close 停止取任务，并等待执行中的任务结束
+func (tq *taskQueue) close() {
+	close(tq.stop)
+	tq.done.Wait()
+}
+
+func (tq *taskQueue) work() {
+	defer tq.done.Done()
+	for {
+		select {
+		case <-tq.stop:
+			return
+		default:
+		}
+
+		task, err := tq.broker.Pop(context.Background(), tq.visibility)
+		if err != nil {
+			tq.logf("[ERROR] Task Pop Failed: %s", err.Error())
+		}
+		if err != nil || task == nil {
+			select {
+			case <-tq.stop:
+				return
+			case <-time.After(tq.pollInterval):
+			}
+			continue
+		}
+
+		tq.process(task)
+	}
+}
+
+func (tq *taskQueue) process(task *Task) {
+	runCtx, cancelRun := context.WithTimeout(context.Background(), tq.visibility)
+	err := tq.run(runCtx, task)
+	cancelRun()
+
+	// 执行的超时时间是handler的，不能用于Ack，否则handler在快超时时结束会Ack失败并被重复执行
+	ctx, cancel := context.WithTimeout(context.Background(), taskBrokerTimeout)
+	defer cancel()
+
+	if err == nil {
+		if err = tq.broker.Ack(ctx, task); err != nil {
+			tq.logf("[ERROR] Task Ack Failed: %s, id=%s name=%s", err.Error(), task.ID, task.Name)
+		}
+		return
+	}
+
+	task.LastError = err.Error()
+	if task.Attempts > task.MaxRetry {
+		tq.logf("[ERROR] Task Dead: %s, id=%s name=%s attempts=%d", err.Error(), task.ID, task.Name, task.Attempts)
+		if err = tq.broker.Bury(ctx, task); err != nil {
+			tq.logf("[ERROR] Task Bury Failed: %s, id=%s name=%s", err.Error(), task.ID, task.Name)
+		}
+		return
+	}
+
+	tq.logf("[ERROR] Task Failed: %s, id=%s name=%s attempts=%d", err.Error(), task.ID, task.Name, task.Attempts)
+	if err = tq.broker.Retry(ctx, task, time.Now().Add(taskBackoff(task.Attempts))); err != nil {
+		tq.logf("[ERROR] Task Retry Failed: %s, id=%s name=%s", err.Error(), task.ID, task.Name)
+	}
+}
+
+func (tq *taskQueue) run(ctx context.Context, task *Task) (err error) {
+	h, ok := tq.handler(task.Name)
+	if !ok {
+		return ErrTaskHandlerMissing
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("task panic: %#v", r)
+		}
+	}()
+	return h(ctx, task.Payload)
+}
+
+// taskBackoff 返回第attempts次失败后的重试间隔，从1秒开始翻倍，最长10分钟
+func taskBackoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= 10*time.Minute {
+			return 10 * time.Minute
+		}
+	}
+	return d
+}
@@ -0,0 +1,173 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DBTaskBroker 是数据库实现的TaskBroker
+// 需要先通过MigrateTaskQueue创建表
+type DBTaskBroker struct {
+	db *gorm.DB
+}
+
+// NewDBTaskBroker 构造DBTaskBroker
+func NewDBTaskBroker(db *gorm.DB) *DBTaskBroker {
+	return &DBTaskBroker{db: db}
+}
+
+// MigrateTaskQueue 创建DBTaskBroker需要的表
+func MigrateTaskQueue(db *gorm.DB) error {
+	return db.AutoMigrate(TaskModel{}, TaskDeadModel{})
+}
+
+// TaskModel 是任务队列表
+type TaskModel struct {
+	ID        uint      `gorm:"primaryKey"`
+	Name      string    `gorm:"type:varchar(100);not null"`
+	Payload   string    `gorm:"type:text"`
+	Priority  int       `gorm:"not null;default:0"`
+	UniqueKey *string   `gorm:"type:varchar(100);uniqueIndex"`
+	Attempts  int       `gorm:"not null;default:0"`
+	MaxRetry  int       `gorm:"not null;default:0"`
+	RunAt     time.Time `gorm:"index"`
+	LastError string    `gorm:"type:varchar(500)"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TableName 指定表名
+func (TaskModel) TableName() string {
+	return "task_queue"
+}
+
+// TaskDeadModel 是多次重试仍失败的任务
+type TaskDeadModel struct {
+	ID        uint   `gorm:"primaryKey"`
+	TaskID    string `gorm:"type:varchar(30)"`
+	Name      string `gorm:"type:varchar(100);not null"`
+	Payload   string `gorm:"type:text"`
+	UniqueKey string `gorm:"type:varchar(100)"`
+	Attempts  int
+	LastError string `gorm:"type:varchar(500)"`
+	CreatedAt time.Time
+}
+
+// TableName 指定表名
+func (TaskDeadModel) TableName() string {
+	return "task_dead"
+}
+
+func (m TaskModel) toTask() *Task {
+	t := &Task{
+		ID:        strconv.FormatUint(uint64(m.ID), 10),
+		Name:      m.Name,
+		Payload:   m.Payload,
+		Priority:  m.Priority,
+		Attempts:  m.Attempts,
+		MaxRetry:  m.MaxRetry,
+		RunAt:     m.RunAt,
+		LastError: m.LastError,
+	}
+	if m.UniqueKey != nil {
+		t.UniqueKey = *m.UniqueKey
+	}
+	return t
+}
+
+// Push 实现TaskBroker
+func (b *DBTaskBroker) Push(ctx context.Context, task *Task) error {
+	m := TaskModel{
+		Name:     task.Name,
+		Payload:  task.Payload,
+		Priority: task.Priority,
+		MaxRetry: task.MaxRetry,
+		RunAt:    task.RunAt,
+	}
+	if task.UniqueKey != "" {
+		key := task.UniqueKey
+		m.UniqueKey = &key
+	}
+
+	// 由unique_key的唯一索引保证去重，先查再插入在并发时会重复
+	if err := b.db.WithContext(ctx).Create(&m).Error; err != nil {
+		if de := TranslateDBError(err); de != nil && de.Kind == DBErrDuplicate && m.UniqueKey != nil {
+			return ErrTaskDuplicated
+		}
+		return err
+	}
+	task.ID = strconv.FormatUint(uint64(m.ID), 10)
+	return nil
+}
+
+// Pop 实现TaskBroker
+// 先查出到期任务，再以attempts做乐观锁抢占，多个实例同时抢占时只有一个能成功
+func (b *DBTaskBroker) Pop(ctx context.Context, visibility time.Duration) (*Task, error) {
+	db := b.db.WithContext(ctx)
+	for i := 0; i < 3; i++ {
+		now := time.Now()
+		var m TaskModel
+		err := db.Where("run_at <= ?", now).Order("priority desc, run_at asc, id asc").Take(&m).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		runAt := now.Add(visibility)
+		res := db.Model(TaskModel{}).
+			Where("id = ? AND attempts = ?", m.ID, m.Attempts).
+			Updates(map[string]interface{}{"attempts": m.Attempts + 1, "run_at": runAt})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			m.Attempts++
+			m.RunAt = runAt
+			return m.toTask(), nil
+		}
+	}
+	return nil, nil
+}
+
+// Ack 实现TaskBroker
+func (b *DBTaskBroker) Ack(ctx context.Context, task *Task) error {
+	return b.db.WithContext(ctx).Where("id = ? AND attempts = ?", task.ID, task.Attempts).Delete(TaskModel{}).Error
+}
+
+// Retry 实现TaskBroker
+func (b *DBTaskBroker) Retry(ctx context.Context, task *Task, runAt time.Time) error {
+	return b.db.WithContext(ctx).Model(TaskModel{}).
+		Where("id = ? AND attempts = ?", task.ID, task.Attempts).
+		Updates(map[string]interface{}{"run_at": runAt, "last_error": truncate(task.LastError, 500)}).Error
+}
+
+// Bury 实现TaskBroker
+func (b *DBTaskBroker) Bury(ctx context.Context, task *Task) error {
+	return b.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND attempts = ?", task.ID, task.Attempts).Delete(TaskModel{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return tx.Create(&TaskDeadModel{
+			TaskID:    task.ID,
+			Name:      task.Name,
+			Payload:   task.Payload,
+			UniqueKey: task.UniqueKey,
+			Attempts:  task.Attempts,
+			LastError: truncate(task.LastError, 500),
+		}).Error
+	})
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
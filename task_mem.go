@@ -0,0 +1,131 @@
+package quick
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// MemTaskBroker 是内存实现的TaskBroker，重启后任务会丢失，用于测试和单机场景
+type MemTaskBroker struct {
+	mu     sync.Mutex
+	tasks  map[string]*Task
+	unique map[string]string
+	dead   []Task
+}
+
+// NewMemTaskBroker 构造MemTaskBroker
+func NewMemTaskBroker() *MemTaskBroker {
+	return &MemTaskBroker{
+		tasks:  make(map[string]*Task),
+		unique: make(map[string]string),
+	}
+}
+
+// Push 实现TaskBroker
+func (b *MemTaskBroker) Push(ctx context.Context, task *Task) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if task.UniqueKey != "" {
+		if _, ok := b.unique[task.UniqueKey]; ok {
+			return ErrTaskDuplicated
+		}
+	}
+
+	task.ID = xid.New().String()
+	t := *task
+	b.tasks[t.ID] = &t
+	if t.UniqueKey != "" {
+		b.unique[t.UniqueKey] = t.ID
+	}
+	return nil
+}
+
+// Pop 实现TaskBroker
+func (b *MemTaskBroker) Pop(ctx context.Context, visibility time.Duration) (*Task, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	var best *Task
+	for _, t := range b.tasks {
+		if t.RunAt.After(now) {
+			continue
+		}
+		if best == nil || t.Priority > best.Priority ||
+			(t.Priority == best.Priority && (t.RunAt.Before(best.RunAt) || (t.RunAt.Equal(best.RunAt) && t.ID < best.ID))) {
+			best = t
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	best.Attempts++
+	best.RunAt = now.Add(visibility)
+	t := *best
+	return &t, nil
+}
+
+// Ack 实现TaskBroker
+func (b *MemTaskBroker) Ack(ctx context.Context, task *Task) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.leased(task); ok {
+		b.remove(task.ID)
+	}
+	return nil
+}
+
+// Retry 实现TaskBroker
+func (b *MemTaskBroker) Retry(ctx context.Context, task *Task, runAt time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if t, ok := b.leased(task); ok {
+		t.RunAt = runAt
+		t.LastError = task.LastError
+	}
+	return nil
+}
+
+// Bury 实现TaskBroker
+func (b *MemTaskBroker) Bury(ctx context.Context, task *Task) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.leased(task); ok {
+		b.remove(task.ID)
+		b.dead = append(b.dead, *task)
+	}
+	return nil
+}
+
+// Dead 返回死信任务
+func (b *MemTaskBroker) Dead() []Task {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Task(nil), b.dead...)
+}
+
+// leased 返回仍被task持有的任务，超过可见性时间后被再次取出的任务不再属于task
+func (b *MemTaskBroker) leased(task *Task) (*Task, bool) {
+	t, ok := b.tasks[task.ID]
+	if !ok || t.Attempts != task.Attempts {
+		return nil, false
+	}
+	return t, true
+}
+
+func (b *MemTaskBroker) remove(id string) {
+	if t, ok := b.tasks[id]; ok {
+		if t.UniqueKey != "" {
+			delete(b.unique, t.UniqueKey)
+		}
+		delete(b.tasks, id)
+	}
+}
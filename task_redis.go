@@ -0,0 +1,179 @@
+package quick
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/rs/xid"
+)
+
+// RedisTaskBroker 是redis实现的TaskBroker
+// 任务数据存在hash中，按可执行时间排序的id存在zset中
+//
+//	{prefix}tasks   hash id => 任务json
+//	{prefix}queue   zset id => 可执行时间(毫秒)
+//	{prefix}unique: string 唯一键 => id
+//	{prefix}dead    list 死信任务json
+type RedisTaskBroker struct {
+	prefix string
+	client *redis.Client
+}
+
+// NewRedisTaskBroker 构造RedisTaskBroker
+func NewRedisTaskBroker(prefix string, client *redis.Client) *RedisTaskBroker {
+	return &RedisTaskBroker{
+		prefix: prefix,
+		client: client,
+	}
+}
+
+var (
+	// KEYS: tasks queue unique  ARGV: id json score
+	redisTaskPushScript = redis.NewScript(`
+if KEYS[3] ~= '' then
+  if redis.call('SET', KEYS[3], ARGV[1], 'NX') == false then
+    return 0
+  end
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+return 1
+`)
+
+	// 在最早到期的若干任务中选优先级最高的
+	// KEYS: tasks queue  ARGV: now visibleAt
+	redisTaskPopScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1], 'LIMIT', 0, 50)
+local best, bestTask
+for _, id in ipairs(ids) do
+  local raw = redis.call('HGET', KEYS[1], id)
+  if raw then
+    local t = cjson.decode(raw)
+    if best == nil or (t.priority or 0) > (bestTask.priority or 0) then
+      best = id
+      bestTask = t
+    end
+  else
+    redis.call('ZREM', KEYS[2], id)
+  end
+end
+if best == nil then
+  return false
+end
+bestTask.attempts = (bestTask.attempts or 0) + 1
+local raw = cjson.encode(bestTask)
+redis.call('HSET', KEYS[1], best, raw)
+redis.call('ZADD', KEYS[2], ARGV[2], best)
+return raw
+`)
+
+	// 只有attempts一致时才操作，避免处理已被重新投递的任务
+	// KEYS: tasks queue unique dead  ARGV: id attempts action json score
+	redisTaskSettleScript = redis.NewScript(`
+local raw = redis.call('HGET', KEYS[1], ARGV[1])
+if not raw then
+  return 0
+end
+local t = cjson.decode(raw)
+if tostring(t.attempts) ~= ARGV[2] then
+  return 0
+end
+if ARGV[3] == 'retry' then
+  redis.call('HSET', KEYS[1], ARGV[1], ARGV[4])
+  redis.call('ZADD', KEYS[2], ARGV[5], ARGV[1])
+  return 1
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+if KEYS[3] ~= '' then
+  redis.call('DEL', KEYS[3])
+end
+if ARGV[3] == 'bury' then
+  redis.call('LPUSH', KEYS[4], ARGV[4])
+end
+return 1
+`)
+)
+
+func (b *RedisTaskBroker) key(k string) string {
+	return b.prefix + k
+}
+
+func (b *RedisTaskBroker) uniqueKey(task *Task) string {
+	if task.UniqueKey == "" {
+		return ""
+	}
+	return b.key("unique:" + task.UniqueKey)
+}
+
+// Push 实现TaskBroker
+func (b *RedisTaskBroker) Push(ctx context.Context, task *Task) error {
+	task.ID = xid.New().String()
+	bs, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{b.key("tasks"), b.key("queue"), b.uniqueKey(task)}
+	n, err := redisTaskPushScript.Run(b.client, keys, task.ID, string(bs), task.RunAt.UnixNano()/int64(time.Millisecond)).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTaskDuplicated
+	}
+	return nil
+}
+
+// Pop 实现TaskBroker
+func (b *RedisTaskBroker) Pop(ctx context.Context, visibility time.Duration) (*Task, error) {
+	now := time.Now()
+	visibleAt := now.Add(visibility)
+	keys := []string{b.key("tasks"), b.key("queue")}
+	raw, err := redisTaskPopScript.Run(b.client, keys, now.UnixNano()/int64(time.Millisecond), visibleAt.UnixNano()/int64(time.Millisecond)).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var task Task
+	if err = json.Unmarshal([]byte(raw), &task); err != nil {
+		return nil, err
+	}
+	task.RunAt = visibleAt
+	return &task, nil
+}
+
+// Ack 实现TaskBroker
+func (b *RedisTaskBroker) Ack(ctx context.Context, task *Task) error {
+	return b.settle(task, "ack", "", 0)
+}
+
+// Retry 实现TaskBroker
+func (b *RedisTaskBroker) Retry(ctx context.Context, task *Task, runAt time.Time) error {
+	t := *task
+	t.RunAt = runAt
+	bs, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return b.settle(task, "retry", string(bs), runAt.UnixNano()/int64(time.Millisecond))
+}
+
+// Bury 实现TaskBroker
+func (b *RedisTaskBroker) Bury(ctx context.Context, task *Task) error {
+	bs, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	return b.settle(task, "bury", string(bs), 0)
+}
+
+func (b *RedisTaskBroker) settle(task *Task, action string, data string, score int64) error {
+	keys := []string{b.key("tasks"), b.key("queue"), b.uniqueKey(task), b.key("dead")}
+	return redisTaskSettleScript.Run(b.client, keys, task.ID, task.Attempts, action, data, score).Err()
+}
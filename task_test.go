@@ -0,0 +1,110 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testTaskBroker(t *testing.T, broker TaskBroker) {
+	ctx := context.Background()
+
+	assert.Nil(t, broker.Push(ctx, &Task{Name: "low", RunAt: time.Now()}))
+	assert.Nil(t, broker.Push(ctx, &Task{Name: "high", Priority: 10, RunAt: time.Now()}))
+	assert.Nil(t, broker.Push(ctx, &Task{Name: "delayed", Priority: 100, RunAt: time.Now().Add(time.Hour)}))
+	assert.Nil(t, broker.Push(ctx, &Task{Name: "unique", UniqueKey: "k1", RunAt: time.Now().Add(time.Hour)}))
+	assert.Equal(t, ErrTaskDuplicated, broker.Push(ctx, &Task{Name: "unique", UniqueKey: "k1", RunAt: time.Now()}))
+
+	task, err := broker.Pop(ctx, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "high", task.Name)
+	assert.Equal(t, 1, task.Attempts)
+
+	// 可见性超时前不会再次取出
+	low, err := broker.Pop(ctx, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "low", low.Name)
+	none, err := broker.Pop(ctx, time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, none)
+
+	assert.Nil(t, broker.Ack(ctx, task))
+	assert.Nil(t, broker.Retry(ctx, low, time.Now().Add(-time.Second)))
+	again, err := broker.Pop(ctx, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "low", again.Name)
+	assert.Equal(t, 2, again.Attempts)
+
+	// 可见性超时后会重新投递，之前的持有者不能再确认
+	redelivered, err := broker.Pop(ctx, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, redelivered.Attempts)
+	assert.Nil(t, broker.Ack(ctx, again))
+	assert.Nil(t, broker.Bury(ctx, redelivered))
+	none, err = broker.Pop(ctx, time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, none)
+}
+
+func TestMemTaskBroker(t *testing.T) {
+	broker := NewMemTaskBroker()
+	testTaskBroker(t, broker)
+	assert.Equal(t, 1, len(broker.Dead()))
+}
+
+func TestDBTaskBroker(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, MigrateTaskQueue(db))
+
+	testTaskBroker(t, NewDBTaskBroker(db))
+
+	var dead []TaskDeadModel
+	assert.Nil(t, db.Find(&dead).Error)
+	assert.Equal(t, 1, len(dead))
+	assert.Equal(t, "low", dead[0].Name)
+}
+
+func TestTaskQueue(t *testing.T) {
+	logf := func(format string, args ...interface{}) {}
+	broker := NewMemTaskBroker()
+	tq := newTaskQueue(broker, TaskQueue{PollInterval: 10, MaxRetry: 1}, logf)
+
+	var mu sync.Mutex
+	var got []string
+	var wg sync.WaitGroup
+	wg.Add(3)
+	tq.register("ok", func(ctx context.Context, payload string) error {
+		mu.Lock()
+		got = append(got, payload)
+		mu.Unlock()
+		wg.Done()
+		return nil
+	})
+	tq.register("fail", func(ctx context.Context, payload string) error {
+		wg.Done()
+		return errors.New("always fail")
+	})
+
+	_, err := tq.enqueue(context.Background(), "ok", "p1")
+	assert.Nil(t, err)
+	_, err = tq.enqueue(context.Background(), "fail", "p2", WithDelay(10*time.Millisecond))
+	assert.Nil(t, err)
+	tq.start()
+
+	wg.Wait()
+	tq.close()
+
+	assert.Equal(t, []string{"p1"}, got)
+	dead := broker.Dead()
+	if assert.Equal(t, 1, len(dead)) {
+		assert.Equal(t, "always fail", dead[0].LastError)
+		assert.Equal(t, 2, dead[0].Attempts)
+	}
+}
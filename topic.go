@@ -0,0 +1,55 @@
+package quick
+
+import (
+	"errors"
+	"strings"
+)
+
+// 主题以.分隔成多段，订阅时可以使用通配符：
+//   *  匹配一段，比如admin.*匹配admin.created，不匹配admin.role.created
+//   >  只能出现在最后，匹配剩余的一段或多段，比如admin.>匹配admin.created和admin.role.created
+
+var (
+	// ErrInvalidTopic 表示订阅的主题不合法
+	ErrInvalidTopic = errors.New("invalid topic")
+)
+
+// isWildcardTopic 返回主题是否包含通配符
+func isWildcardTopic(pattern string) bool {
+	for _, seg := range strings.Split(pattern, ".") {
+		if seg == "*" || seg == ">" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTopic 检查主题是否合法，主题可以包含通配符
+func ValidateTopic(pattern string) error {
+	segs := strings.Split(pattern, ".")
+	for i, seg := range segs {
+		if seg == "" || (seg == ">" && i != len(segs)-1) {
+			return ErrInvalidTopic
+		}
+	}
+	return nil
+}
+
+// MatchTopic 返回topic是否匹配主题pattern，pattern可以包含通配符
+func MatchTopic(pattern, topic string) bool {
+	if pattern == topic {
+		return true
+	}
+
+	ps := strings.Split(pattern, ".")
+	ts := strings.Split(topic, ".")
+	for i, p := range ps {
+		if p == ">" {
+			return len(ts) > i
+		}
+		if i >= len(ts) || (p != "*" && p != ts[i]) {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
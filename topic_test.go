@@ -0,0 +1,37 @@
+package quick
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"admin.created", "admin.created", true},
+		{"admin.created", "admin.updated", false},
+		{"admin.*", "admin.created", true},
+		{"admin.*", "admin.role.created", false},
+		{"admin.*", "admin", false},
+		{"*.created", "admin.created", true},
+		{"admin.>", "admin.created", true},
+		{"admin.>", "admin.role.created", true},
+		{"admin.>", "admin", false},
+		{">", "admin", true},
+		{"admin.*.created", "admin.role.created", true},
+		{"admin.*.created", "admin.role.updated", false},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, MatchTopic(tc.pattern, tc.topic), tc.pattern+" "+tc.topic)
+	}
+
+	assert.Nil(t, ValidateTopic("admin.>"))
+	assert.Nil(t, ValidateTopic("*.created"))
+	assert.Equal(t, ErrInvalidTopic, ValidateTopic("admin.>.created"))
+	assert.Equal(t, ErrInvalidTopic, ValidateTopic("admin..created"))
+	assert.Equal(t, ErrInvalidTopic, ValidateTopic(""))
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"io"
 )
 
@@ -51,5 +52,8 @@ func (s *SimpleAES) Dec(data string) ([]byte, error) {
 		return nil, err
 	}
 	nonceSize := s.gcm.NonceSize()
+	if len(bs) < nonceSize {
+		return nil, errors.New("密文长度不足")
+	}
 	return s.gcm.Open(nil, bs[:nonceSize], bs[nonceSize:], nil)
 }